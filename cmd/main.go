@@ -2,24 +2,37 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/OpenPaaSDev/openpaas/internal"
 	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
 	"github.com/OpenPaaSDev/openpaas/internal/runtime"
 	"github.com/spf13/cobra"
 )
 
+// offline annotates commands that only read the configuration, which run without the local dependencies.
+const offline = "offline"
+
+// logger is set up from the --log-level and --log-format flags before any command runs.
+var logger *logging.Logger
+
+// workspaceFile and cluster select the cluster of a workspace commands act on when no config file is given.
+var workspaceFile, cluster string
+
 func main() {
 	err := os.Setenv("ANSIBLE_HOST_KEY_CHECKING", "False")
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	if !runtime.HasDependencies() {
-		os.Exit(1)
-	}
 
 	rootCmd := &cobra.Command{
 		Use:   "openpaas",
@@ -33,7 +46,29 @@ func main() {
 		},
 	}
 
-	rootCmd.AddCommand(sync(), envRC())
+	var logLevel, logFormat string
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format: text or json")
+	rootCmd.PersistentFlags().StringVar(&workspaceFile, "workspace", conf.DefaultWorkspaceFile, "workspace file listing the clusters")
+	rootCmd.PersistentFlags().StringVar(&cluster, "cluster", "", "cluster of the workspace to act on, instead of the one selected with use")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		level, e := logging.ParseLevel(logLevel)
+		if e != nil {
+			return e
+		}
+		format, e := logging.ParseFormat(logFormat)
+		if e != nil {
+			return e
+		}
+		logger = logging.New(os.Stderr, level, format)
+		logging.SetDefault(logger)
+		if cmd.Annotations[offline] == "" && !runtime.HasDependencies() {
+			os.Exit(1)
+		}
+		return nil
+	}
+
+	rootCmd.AddCommand(sync(), plan(), destroy(), validate(), schema(), envRC(), vaultCmd(), scale(), upgrade(), certs(), backup(), restore(), use())
 
 	err = rootCmd.Execute()
 	if err != nil {
@@ -43,18 +78,76 @@ func main() {
 }
 
 func sync() *cobra.Command {
-	var configFile string
+	var configFiles []string
+	var phases internal.PhaseOptions
 	cmd := &cobra.Command{
 		Use:   "sync",
 		Short: "bootstraps and starts a cluster or syncs the cluster to its desired state",
 		Long:  `bootstraps and starts a cluster or syncs the cluster to its desired state`,
 		Run: func(cmd *cobra.Command, args []string) {
-			config, err := conf.Load(configFile)
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			err = internal.Bootstrap(context.Background(), config, phases, logger)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+	cmd.Flags().StringVar(&phases.From, "from-phase", "", "run this phase and all after it, even if their inputs are unchanged")
+	cmd.Flags().StringVar(&phases.Only, "only-phase", "", "run only this phase, even if its inputs are unchanged")
+	cmd.MarkFlagsMutuallyExclusive("from-phase", "only-phase")
+
+	return cmd
+}
+
+func plan() *cobra.Command {
+	var configFiles []string
+	var verbose bool
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "shows the changes sync would make to the cluster, without applying them",
+		Long:  `shows the infrastructure, configuration and Consul ACL changes sync would make to the cluster, without applying them`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			report, err := internal.Plan(context.Background(), config, logger)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			report.Print(os.Stdout, verbose)
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "print the ansible diff output of playbooks with changes")
+
+	return cmd
+}
+
+func destroy() *cobra.Command {
+	var configFiles []string
+	var opts internal.DestroyOptions
+	cmd := &cobra.Command{
+		Use:   "destroy",
+		Short: "tears down the cluster and archives or purges its generated files",
+		Long:  `tears down the infrastructure of the cluster with terraform, after confirming the datacenter name and optionally snapshotting consul, vault and nomad, then archives or purges the files sync generated in base_dir`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := loadConfig(configFiles)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
 			}
-			err = internal.Bootstrap(context.Background(), config, configFile)
+			err = internal.Destroy(context.Background(), config, opts, os.Stdin, os.Stdout, logger)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
@@ -62,25 +155,150 @@ func sync() *cobra.Command {
 		},
 	}
 
-	addFlags(cmd, &configFile)
+	addFlags(cmd, &configFiles)
+	cmd.Flags().StringVar(&opts.Confirm, "confirm", "", "name of the datacenter to destroy, asked for when not given")
+	cmd.Flags().BoolVar(&opts.Snapshot, "snapshot", false, "save consul, vault and nomad snapshots to base_dir/snapshots first")
+	cmd.Flags().BoolVar(&opts.Purge, "purge", false, "delete the generated files instead of archiving them to base_dir/destroyed")
+
+	return cmd
+}
+
+func validate() *cobra.Command {
+	var configFiles []string
+	cmd := &cobra.Command{
+		Use:         "validate",
+		Short:       "checks the configuration file for problems",
+		Annotations: map[string]string{offline: "true"},
+		Long:        `checks the configuration file for unknown fields, wrong values and missing settings, reporting every problem with its line`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s is valid\n", strings.Join(config.Paths(), " + "))
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+
+	return cmd
+}
+
+func schema() *cobra.Command {
+	var outFile string
+	cmd := &cobra.Command{
+		Use:         "schema",
+		Short:       "prints the JSON schema of the configuration file",
+		Long:        `prints the JSON schema of the configuration file, including the settings of every cloud provider, for editors to validate and complete configs with`,
+		Annotations: map[string]string{offline: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			out, err := json.MarshalIndent(conf.JSONSchema(), "", "  ")
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			out = append(out, '\n')
+			if outFile == "" {
+				fmt.Print(string(out))
+				return
+			}
+			err = os.WriteFile(filepath.Clean(outFile), out, 0600)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&outFile, "out.file", "o", "", "file to write the schema to instead of stdout")
 
 	return cmd
 }
 
 func envRC() *cobra.Command {
-	var configFile string
+	var configFiles []string
 	var targetDir string
+	var all bool
 	cmd := &cobra.Command{
 		Use:   "genenv",
 		Short: "Generate env file to source for your environment",
-		Long:  `Generate env file to source for your environment`,
+		Long:  `Generate env file to source for your environment. Clusters of a workspace get their own .envrc.<cluster> file`,
 		Run: func(cmd *cobra.Command, args []string) {
-			config, err := conf.Load(configFile)
+			if len(configFiles) > 0 {
+				config, err := readConfig(configFiles)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				err = internal.GenerateEnvFile(config, targetDir)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				return
+			}
+
+			ws, err := conf.LoadWorkspace(workspaceFile)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
 			}
-			err = internal.GenerateEnvFile(config, targetDir)
+			clusters := ws.Names()
+			if !all {
+				name, e := ws.Cluster(cluster)
+				if e != nil {
+					fmt.Println(e)
+					os.Exit(1)
+				}
+				clusters = []string{name}
+			}
+			for _, name := range clusters {
+				config, e := ws.Load(name)
+				if e != nil {
+					fmt.Println(e)
+					os.Exit(1)
+				}
+				e = internal.GenerateClusterEnvFile(config, name, targetDir)
+				if e != nil {
+					fmt.Println(e)
+					os.Exit(1)
+				}
+			}
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+	cmd.Flags().StringVarP(&targetDir, "target.dir", "t", "", "target directory of .envrc file")
+	cmd.Flags().BoolVar(&all, "all", false, "generate the env files of every cluster of the workspace")
+
+	return cmd
+}
+
+func vaultCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vault",
+		Short: "operates the vault servers of the cluster",
+		Long:  `operates the vault servers of the cluster`,
+	}
+	cmd.AddCommand(vaultUnseal())
+	return cmd
+}
+
+func vaultUnseal() *cobra.Command {
+	var configFiles []string
+	var keyFiles []string
+	cmd := &cobra.Command{
+		Use:   "unseal",
+		Short: "unseals the vault servers with the key shares of their holders",
+		Long:  `unseals the vault servers, reading decrypted key shares from files or asking for them until the threshold is reached`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			err = internal.UnsealVault(config, keyFiles, os.Stdin, os.Stdout, logger)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
@@ -88,24 +306,317 @@ func envRC() *cobra.Command {
 		},
 	}
 
-	addFlags(cmd, &configFile)
-	cmd.Flags().StringVarP(&targetDir, "target.dir", "t", "", "target directory of .envrc file")
+	addFlags(cmd, &configFiles)
+	cmd.Flags().StringSliceVarP(&keyFiles, "key.file", "k", []string{}, "file holding a decrypted unseal key share, can be repeated")
 
-	err := cmd.MarkFlagRequired("config.file")
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	return cmd
+}
+
+func scale() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scale",
+		Short: "changes the number of machines of the cluster",
+		Long:  `changes the number of machines of the cluster`,
+	}
+	cmd.AddCommand(scaleClients())
+	return cmd
+}
+
+func scaleClients() *cobra.Command {
+	var configFiles []string
+	cmd := &cobra.Command{
+		Use:   "clients N",
+		Short: "scales the nomad clients of the cluster to N, draining the ones removed",
+		Long:  `scales the nomad clients of the cluster to N and writes N to cluster_config.clients. Removed clients are drained, waiting for their allocations to migrate, and leave consul before terraform destroys them. Only the new clients are configured.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Printf("%s is not a number of clients\n", args[0])
+				os.Exit(1)
+			}
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			err = internal.ScaleClients(context.Background(), config, n, logger)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
 	}
 
+	addFlags(cmd, &configFiles)
+
 	return cmd
 }
 
-func addFlags(cmd *cobra.Command, file *string) {
-	cmd.Flags().StringVarP(file, "config.file", "f", "", "configuration file")
+func upgrade() *cobra.Command {
+	var configFiles []string
+	var keyFiles []string
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "upgrades consul, nomad and vault to the versions pinned in cluster_config.versions",
+		Long:  `upgrades consul, nomad and vault to the versions pinned in cluster_config.versions. Servers are upgraded one at a time with the leader last, waiting for autopilot to report the cluster as healthy as before after each one, and restarted vault servers are unsealed with the given key shares. The upgrade stops at the first server that does not come back healthy.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			err = internal.Upgrade(context.Background(), config, keyFiles, os.Stdin, os.Stdout, logger)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+	cmd.Flags().StringSliceVarP(&keyFiles, "key.file", "k", []string{}, "file holding a decrypted unseal key share, can be repeated")
+
+	return cmd
+}
+
+func certs() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "certs",
+		Short: "lists and rotates the TLS certificates of consul, nomad and vault",
+		Long:  `lists and rotates the TLS certificates of consul, nomad and vault`,
+	}
+	cmd.AddCommand(certsStatus(), certsRotate())
+	return cmd
+}
+
+func certsStatus() *cobra.Command {
+	var configFiles []string
+	cmd := &cobra.Command{
+		Use:         "status",
+		Short:       "lists the certificates of the cluster with their SANs and expiry",
+		Annotations: map[string]string{offline: "true"},
+		Long:        `lists the certificates in base_dir/secrets with the names they are issued for and when they expire, flagging the ones expiring within 30 days and the ones missing hosts of the inventory, which openpaas certs rotate fixes`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			certs, err := internal.Certificates(config)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			now := time.Now()
+			for _, c := range certs {
+				status := ""
+				if c.Expiring(now) {
+					status += " (expiring)"
+				}
+				if len(c.Missing) > 0 {
+					status += fmt.Sprintf(" (missing %s)", strings.Join(c.Missing, ","))
+				}
+				fmt.Printf("%s\t%s\t%s\t%d days left%s\n", c.File, c.Subject, strings.Join(c.SANs(), ","),
+					int(c.NotAfter.Sub(now).Hours()/24), status)
+			}
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+
+	return cmd
+}
+
+func certsRotate() *cobra.Command {
+	var configFiles []string
+	cmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "reissues the certificates of the cluster and rolls them out",
+		Long:  `reissues the certificates of the consul servers, of nomad and of vault from the existing CAs, for the hosts of the current inventory, and rolls them out one host at a time, reloading consul, nomad and vault. The rotation stops at the first host after which the cluster is not as healthy as before.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			err = internal.RotateCertificates(context.Background(), config, logger)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+
+	return cmd
+}
+
+func backup() *cobra.Command {
+	var configFiles []string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "uploads encrypted consul, vault and nomad snapshots to backup.bucket",
+		Long:  `takes consul snapshots, which hold vault, and nomad snapshots of the cluster, encrypts them with OPENPAAS_BACKUP_PASSPHRASE and uploads them to backup.bucket, keeping the newest backup.keep backups`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			stamp, err := internal.BackupCluster(config, logger)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("backup %s uploaded\n", stamp)
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+	cmd.AddCommand(backupList())
+
+	return cmd
+}
+
+func backupList() *cobra.Command {
+	var configFiles []string
+	cmd := &cobra.Command{
+		Use:         "list",
+		Short:       "lists the backups of the cluster in backup.bucket",
+		Annotations: map[string]string{offline: "true"},
+		Long:        `lists the backups of the cluster in backup.bucket, newest first. Incomplete backups, missing a snapshot, can't be restored.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			backups, err := internal.ListBackups(config)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			for _, b := range backups {
+				status := ""
+				if !b.Complete {
+					status = " (incomplete)"
+				}
+				fmt.Printf("%s\t%d bytes%s\n", b.Timestamp, b.Size, status)
+			}
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+
+	return cmd
+}
 
-	err := cmd.MarkFlagRequired("config.file")
+func restore() *cobra.Command {
+	var configFiles []string
+	var opts internal.RestoreOptions
+	cmd := &cobra.Command{
+		Use:   "restore [timestamp]",
+		Short: "restores consul, vault and nomad from a backup in backup.bucket",
+		Long:  `replaces the consul state, which holds vault, and the nomad state of the cluster with a backup in backup.bucket, the newest complete one unless a timestamp of openpaas backup list is given, after confirming the datacenter name`,
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 1 {
+				opts.Backup = args[0]
+			}
+			config, err := loadConfig(configFiles)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			err = internal.Restore(config, opts, os.Stdin, os.Stdout, logger)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addFlags(cmd, &configFiles)
+	cmd.Flags().StringVar(&opts.Confirm, "confirm", "", "name of the datacenter to restore, asked for when not given")
+
+	return cmd
+}
+
+func use() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "use [cluster]",
+		Short:       "selects the cluster of the workspace commands act on",
+		Long:        `selects the cluster of the workspace commands act on when neither --cluster nor --config.file is given, or lists the clusters without an argument`,
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{offline: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			ws, err := conf.LoadWorkspace(workspaceFile)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if len(args) == 1 {
+				err = ws.Use(args[0])
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				fmt.Printf("using cluster %s\n", args[0])
+				return
+			}
+			current, err := ws.Current()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			for _, name := range ws.Names() {
+				marker := " "
+				if name == current {
+					marker = "*"
+				}
+				fmt.Printf("%s %s\t%s\n", marker, name, ws.ClusterBaseDir(name))
+			}
+		},
+	}
+
+	return cmd
+}
+
+// loadConfig loads the configuration file with its overlays and validates it, so that no command acts on
+// a broken config.
+func loadConfig(files []string) (*conf.Config, error) {
+	config, err := readConfig(files)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
+	}
+	return config, config.Validate()
+}
+
+// readConfig loads the configuration files, or without them the config of the selected cluster of the
+// workspace.
+func readConfig(files []string) (*conf.Config, error) {
+	if len(files) > 0 {
+		if cluster != "" {
+			return nil, errors.New("--cluster selects a cluster of the workspace and can't be combined with --config.file")
+		}
+		return conf.Load(files...)
+	}
+	ws, err := conf.LoadWorkspace(workspaceFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no --config.file given and no workspace file %s found", workspaceFile)
+	}
+	if err != nil {
+		return nil, err
 	}
+	name, err := ws.Cluster(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return ws.Load(name)
+}
+
+func addFlags(cmd *cobra.Command, files *[]string) {
+	cmd.Flags().StringArrayVarP(files, "config.file", "f", []string{}, "configuration file, repeat it for overlays merged into the files before them, defaults to the cluster of the workspace")
 }
@@ -2,13 +2,22 @@ package ansible
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
 	"github.com/OpenPaaSDev/openpaas/internal/runtime"
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
 )
 
 type Client interface {
 	Run(file string) error
+	Check(file string, out io.Writer) error
+	// Limit returns a client that runs playbooks on the given hosts of the inventory only.
+	Limit(hosts ...string) Client
 }
 
 type ansibleClient struct {
@@ -16,17 +25,104 @@ type ansibleClient struct {
 	secretsFile string
 	user        string
 	configPath  string
+	log         *logging.Logger
+
+	limit []string
 }
 
-func NewClient(inventory, secretsFile, user, configPath string) Client {
+func NewClient(inventory, secretsFile, user, configPath string, log *logging.Logger) Client {
 	return &ansibleClient{
 		inventory:   inventory,
 		secretsFile: secretsFile,
 		user:        user,
 		configPath:  configPath,
+		log:         log,
 	}
 }
 
 func (client *ansibleClient) Run(file string) error {
-	return runtime.Exec(&runtime.EmptyEnv{}, fmt.Sprintf("ansible-playbook %s -i %s -u %s -e @%s -e @%s", file, client.inventory, client.user, client.secretsFile, client.configPath), os.Stdout)
+	vars, cleanup, err := client.decryptVars()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	start := time.Now()
+	client.log.Info("running playbook", "playbook", filepath.Base(file))
+	err = runtime.Run(&runtime.EmptyEnv{}, os.Stdout, "ansible-playbook", client.args(file, vars)...)
+	if err != nil {
+		return fmt.Errorf("playbook %s: %w", filepath.Base(file), err)
+	}
+	client.log.Debug("playbook finished", "playbook", filepath.Base(file), "duration", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Check runs the playbook in check mode, reporting the changes it would make to out without applying them.
+func (client *ansibleClient) Check(file string, out io.Writer) error {
+	vars, cleanup, err := client.decryptVars()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	client.log.Debug("checking playbook", "playbook", filepath.Base(file))
+	// the diff shows rendered templates, which hold secrets
+	redacted := runtime.NewRedactWriter(out)
+	defer redacted.Flush() //nolint
+	return runtime.Run(&runtime.EmptyEnv{}, redacted, "ansible-playbook", append(client.args(file, vars), "--check", "--diff")...)
+}
+
+func (client *ansibleClient) Limit(hosts ...string) Client {
+	limited := *client
+	limited.limit = hosts
+	return &limited
+}
+
+func (client *ansibleClient) args(file string, vars []string) []string {
+	args := []string{file, "-i", client.inventory, "-u", client.user}
+	for _, v := range vars {
+		args = append(args, "-e", "@"+v)
+	}
+	if len(client.limit) > 0 {
+		args = append(args, "--limit", strings.Join(client.limit, ","))
+	}
+	return args
+}
+
+// decryptVars writes the plaintext secrets and config to private temp files for the duration of a
+// playbook run, as the files themselves may be encrypted, and returns them in the order ansible reads them.
+func (client *ansibleClient) decryptVars() ([]string, func(), error) {
+	files := []string{}
+	cleanup := func() {
+		for _, file := range files {
+			os.Remove(file) //nolint
+		}
+	}
+	for _, file := range []string{client.secretsFile, client.configPath} {
+		decrypted, err := decrypt(file)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		files = append(files, decrypted)
+	}
+	return files, cleanup, nil
+}
+
+func decrypt(file string) (string, error) {
+	plaintext, err := secrets.ReadFile(file, secrets.KeyProviderFromEnv())
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "openpaas-*.yml")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(plaintext)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(f.Name()) //nolint
+		return "", err
+	}
+	return f.Name(), nil
 }
@@ -1,10 +1,13 @@
 package ansible
 
 import (
+	"os"
 	"os/user"
 	"path/filepath"
 	"testing"
 
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -12,7 +15,44 @@ func TestAnsible(t *testing.T) {
 	currentUser, err := user.Current()
 	assert.NoError(t, err)
 
-	ansibleClient := NewClient(filepath.Join("testdata", "inventory"), filepath.Join("testdata", "secrets"), currentUser.Username, filepath.Join("testdata", "secrets"))
+	ansibleClient := NewClient(filepath.Join("testdata", "inventory"), filepath.Join("testdata", "secrets"), currentUser.Username, filepath.Join("testdata", "secrets"), logging.Discard())
 	err = ansibleClient.Run(filepath.Join("testdata", "ansible.yml"))
 	assert.NoError(t, err)
 }
+
+func TestAnsibleLimit(t *testing.T) {
+	client := NewClient("inventory", "secrets.yml", "root", "config.yml", logging.Discard())
+	limited := client.Limit("127.0.0.4", "127.0.0.5")
+
+	vars := []string{"plain.yml", "config.yml"}
+	assert.NotContains(t, client.(*ansibleClient).args("base.yml", vars), "--limit")
+	assert.Equal(t, []string{"base.yml", "-i", "inventory", "-u", "root", "-e", "@plain.yml", "-e", "@config.yml", "--limit", "127.0.0.4,127.0.0.5"},
+		limited.(*ansibleClient).args("base.yml", vars))
+}
+
+func TestDecryptVars(t *testing.T) {
+	t.Setenv(secrets.PassphraseEnv, "passphrase")
+	dir := t.TempDir()
+	secretsFile, configFile := filepath.Join(dir, "secrets.yml"), filepath.Join(dir, "config.resolved.yml")
+	kp := secrets.KeyProviderFromEnv()
+	assert.NoError(t, secrets.WriteFile(secretsFile, []byte("consul_gossip_key: key"), kp))
+	assert.NoError(t, secrets.WriteFile(configFile, []byte("dc_name: dev"), kp))
+
+	client := NewClient("inventory", secretsFile, "root", configFile, logging.Discard()).(*ansibleClient)
+	vars, cleanup, err := client.decryptVars()
+	assert.NoError(t, err)
+	assert.Len(t, vars, 2)
+	for i, expected := range []string{"consul_gossip_key: key", "dc_name: dev"} {
+		content, e := os.ReadFile(vars[i])
+		assert.NoError(t, e)
+		assert.Equal(t, expected, string(content))
+	}
+	cleanup()
+	for _, file := range vars {
+		assert.NoFileExists(t, file)
+	}
+
+	t.Setenv(secrets.PassphraseEnv, "")
+	_, _, err = client.decryptVars()
+	assert.ErrorIs(t, err, secrets.ErrNoKeyProvider)
+}
@@ -153,10 +153,7 @@ func GenerateInventory(config *conf.Config) (*Inventory, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		e := jsonFile.Close()
-		fmt.Println(e)
-	}()
+	defer jsonFile.Close() //nolint
 	byteValue, err := io.ReadAll(jsonFile)
 	if err != nil {
 		return nil, err
@@ -167,7 +164,12 @@ func GenerateInventory(config *conf.Config) (*Inventory, error) {
 	if err != nil {
 		return nil, err
 	}
+	return WriteInventory(config, &inventory)
+}
 
+// WriteInventory builds the ansible inventory from the hosts and volumes of a cluster and writes it to
+// the base dir.
+func WriteInventory(config *conf.Config, inventory *InventoryJson) (*Inventory, error) {
 	inv := Inventory{
 		All: All{
 			Children: Children{
@@ -189,7 +191,6 @@ func GenerateInventory(config *conf.Config) (*Inventory, error) {
 	}
 
 	for _, v := range consulHosts {
-		fmt.Println(v)
 		found := false
 		for _, vol := range inventory.ConsulVolumes.Value {
 			if fmt.Sprintf("%v", vol.ServerID) == v.ServerID {
@@ -0,0 +1,456 @@
+package internal
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/s3"
+
+	secret "github.com/OpenPaaSDev/openpaas/internal/secrets"
+)
+
+//go:embed templates/nomad/backup.hcl
+var backupJob string
+
+//go:embed templates/consul/backup-policy.hcl
+var backupPolicy string
+
+// BackupPassphraseEnv holds the passphrase backups are encrypted with. It is kept apart from the secrets
+// passphrase, as the backups outlive the secrets of a cluster that is lost.
+const BackupPassphraseEnv = "OPENPAAS_BACKUP_PASSPHRASE"
+
+const (
+	backupJobID       = "openpaas-backup"
+	backupJobVariable = "nomad/jobs/" + backupJobID
+	defaultBackupKeep = 14
+)
+
+// backupSnapshots are the snapshots a backup is made of, in the order they are restored.
+var backupSnapshots = []string{"consul", "nomad"}
+
+// backupStore keeps the backups of a datacenter at <prefix>/<dc_name>/<timestamp>/<name>.snap.enc in a
+// bucket, encrypted in the format of openssl enc and authenticated with an HMAC, which the periodic backup
+// job writes as well.
+type backupStore struct {
+	s3   s3.Client
+	dir  string
+	keep int
+	kp   secret.KeyProvider
+}
+
+// backupSettings resolves the bucket settings of config, taking the endpoint and credentials from the
+// environment and then from the secrets, as the rest of openpaas does.
+type backupSettings struct {
+	Bucket, Dir, Region, Endpoint string
+	AccessKey, SecretKey          string
+	Passphrase                    string
+	Keep                          int
+}
+
+func newBackupSettings(config *conf.Config, sec *secret.Config) (*backupSettings, error) {
+	b := config.Backup
+	if b.Bucket == "" {
+		return nil, errors.New("backup.bucket is not set in the config")
+	}
+	passphrase := os.Getenv(BackupPassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("backups are encrypted, please set %s", BackupPassphraseEnv)
+	}
+	s := &backupSettings{
+		Bucket:     b.Bucket,
+		Dir:        strings.TrimPrefix(strings.TrimSuffix(b.Prefix, "/")+"/"+config.DC+"/", "/"),
+		Region:     b.Region,
+		Endpoint:   b.Endpoint,
+		AccessKey:  os.Getenv("S3_ACCESS_KEY"),
+		SecretKey:  os.Getenv("S3_SECRET_KEY"),
+		Passphrase: passphrase,
+		Keep:       b.Keep,
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.Keep == 0 {
+		s.Keep = defaultBackupKeep
+	}
+	if s.Endpoint == "" && sec != nil {
+		s.Endpoint = sec.S3Endpoint
+	}
+	if (s.AccessKey == "" || s.SecretKey == "") && sec != nil {
+		s.AccessKey, s.SecretKey = sec.S3AccessKey, sec.S3SecretKey
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, errors.New("no credentials for the backup bucket, please set S3_ACCESS_KEY and S3_SECRET_KEY")
+	}
+	return s, nil
+}
+
+func (s *backupSettings) store() *backupStore {
+	return &backupStore{
+		s3:   s3.NewClient(s.Endpoint, s.Region, s.Bucket, s.AccessKey, s.SecretKey),
+		dir:  s.Dir,
+		keep: s.Keep,
+		kp:   secret.NewOpenSSLKeyProvider(s.Passphrase),
+	}
+}
+
+func (b *backupStore) key(stamp, name string) string {
+	return fmt.Sprintf("%s%s/%s.snap.enc", b.dir, stamp, name)
+}
+
+func (b *backupStore) save(stamp, name string, snapshot []byte) error {
+	encrypted, err := b.kp.Encrypt(snapshot)
+	if err != nil {
+		return err
+	}
+	return b.s3.Put(b.key(stamp, name), encrypted)
+}
+
+func (b *backupStore) load(stamp, name string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := b.s3.Get(b.key(stamp, name), &buf)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := b.kp.Decrypt(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s snapshot of backup %s: %w", name, stamp, err)
+	}
+	return snapshot, nil
+}
+
+// Backup is a backup in the bucket, named after the time it was taken.
+type Backup struct {
+	Timestamp string
+	// Complete reports whether the backup holds every snapshot a restore needs.
+	Complete bool
+	Size     int64
+}
+
+// list returns the backups in the bucket, newest first.
+func (b *backupStore) list() ([]Backup, error) {
+	objects, err := b.s3.List(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	byStamp := map[string]*Backup{}
+	names := map[string]map[string]bool{}
+	for _, o := range objects {
+		stamp, file, ok := strings.Cut(strings.TrimPrefix(o.Key, b.dir), "/")
+		if !ok {
+			continue
+		}
+		if byStamp[stamp] == nil {
+			byStamp[stamp] = &Backup{Timestamp: stamp}
+			names[stamp] = map[string]bool{}
+		}
+		byStamp[stamp].Size += o.Size
+		names[stamp][file] = true
+	}
+	backups := []Backup{}
+	for stamp, backup := range byStamp {
+		backup.Complete = true
+		for _, name := range backupSnapshots {
+			backup.Complete = backup.Complete && names[stamp][name+".snap.enc"]
+		}
+		backups = append(backups, *backup)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Timestamp > backups[j].Timestamp
+	})
+	return backups, nil
+}
+
+// prune deletes the backups beyond the newest keep and returns their timestamps.
+func (b *backupStore) prune() ([]string, error) {
+	backups, err := b.list()
+	if err != nil || len(backups) <= b.keep {
+		return nil, err
+	}
+	objects, err := b.s3.List(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	pruned := []string{}
+	for _, backup := range backups[b.keep:] {
+		for _, o := range objects {
+			if strings.HasPrefix(o.Key, b.dir+backup.Timestamp+"/") {
+				err = b.s3.Delete(o.Key)
+				if err != nil {
+					return pruned, err
+				}
+			}
+		}
+		pruned = append(pruned, backup.Timestamp)
+	}
+	return pruned, nil
+}
+
+// clusterClients returns the consul and nomad clients of the cluster in base_dir.
+func clusterClients(baseDir string, log *logging.Logger) (*secret.Config, hashistack.Consul, hashistack.NomadClient, error) {
+	inv, err := ansible.LoadInventory(filepath.Join(baseDir, "inventory"))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("no inventory found for the cluster: %w", err)
+	}
+	sec, err := secret.Load(baseDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	consul, err := hashistack.NewConsulAPI(inv, sec, baseDir, log)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return sec, consul, newNomadClient(baseDir, inv, log), nil
+}
+
+// BackupCluster takes consul snapshots, which hold vault, and nomad snapshots of the cluster, uploads them
+// encrypted to backup.bucket and deletes the backups beyond the newest backup.keep. It returns the
+// timestamp of the backup.
+func BackupCluster(config *conf.Config, log *logging.Logger) (string, error) {
+	log = log.With("dc", config.DC)
+	sec, consul, nomad, err := clusterClients(config.BaseDir, log)
+	if err != nil {
+		return "", err
+	}
+	settings, err := newBackupSettings(config, sec)
+	if err != nil {
+		return "", err
+	}
+	return backup(settings.store(), map[string]func(io.Writer) error{"consul": consul.Snapshot, "nomad": nomad.Snapshot}, time.Now(), log)
+}
+
+func backup(store *backupStore, snapshots map[string]func(io.Writer) error, now time.Time, log *logging.Logger) (string, error) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	// every snapshot is taken before any is uploaded, so that a failed snapshot leaves no partial backup
+	taken := map[string][]byte{}
+	for _, name := range backupSnapshots {
+		var buf bytes.Buffer
+		err := snapshots[name](&buf)
+		if err != nil {
+			return "", fmt.Errorf("%s snapshot: %w", name, err)
+		}
+		taken[name] = buf.Bytes()
+	}
+	for _, name := range backupSnapshots {
+		err := store.save(stamp, name, taken[name])
+		if err != nil {
+			return "", fmt.Errorf("uploading %s snapshot: %w", name, err)
+		}
+		log.Info("uploaded snapshot", "snapshot", name, "key", store.key(stamp, name))
+	}
+	pruned, err := store.prune()
+	if err != nil {
+		return stamp, fmt.Errorf("backup %s is complete, but deleting old backups failed: %w", stamp, err)
+	}
+	if len(pruned) > 0 {
+		log.Info("deleted old backups", "backups", strings.Join(pruned, ","))
+	}
+	return stamp, nil
+}
+
+// ListBackups returns the backups of the cluster in backup.bucket, newest first.
+func ListBackups(config *conf.Config) ([]Backup, error) {
+	var sec *secret.Config
+	if secret.Exists(config.BaseDir) {
+		var err error
+		sec, err = secret.Load(config.BaseDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	settings, err := newBackupSettings(config, sec)
+	if err != nil {
+		return nil, err
+	}
+	return settings.store().list()
+}
+
+type RestoreOptions struct {
+	// Backup is the timestamp of the backup to restore, the newest complete backup by default.
+	Backup string
+	// Confirm is the name of the datacenter to restore. It is asked for when it does not match.
+	Confirm string
+}
+
+// Restore replaces the consul state, and with it vault, and the nomad state of the cluster with a backup.
+// Both snapshots are downloaded and decrypted before either is restored.
+func Restore(config *conf.Config, opts RestoreOptions, in io.Reader, out io.Writer, log *logging.Logger) error {
+	log = log.With("dc", config.DC)
+	sec, consul, nomad, err := clusterClients(config.BaseDir, log)
+	if err != nil {
+		return err
+	}
+	settings, err := newBackupSettings(config, sec)
+	if err != nil {
+		return err
+	}
+	store := settings.store()
+	stamp := opts.Backup
+	if stamp == "" {
+		stamp, err = latestBackup(store)
+		if err != nil {
+			return err
+		}
+	}
+	warning := fmt.Sprintf("This replaces the consul, vault and nomad state of datacenter %s with backup %s.", config.DC, stamp)
+	err = confirmDatacenter(config.DC, opts.Confirm, warning, in, out)
+	if err != nil {
+		return err
+	}
+	return restore(store, stamp, map[string]func(io.Reader) error{"consul": consul.Restore, "nomad": nomad.Restore}, log)
+}
+
+func latestBackup(store *backupStore) (string, error) {
+	backups, err := store.list()
+	if err != nil {
+		return "", err
+	}
+	for _, b := range backups {
+		if b.Complete {
+			return b.Timestamp, nil
+		}
+	}
+	return "", fmt.Errorf("no complete backup found at %s", store.dir)
+}
+
+func restore(store *backupStore, stamp string, restorers map[string]func(io.Reader) error, log *logging.Logger) error {
+	snapshots := map[string][]byte{}
+	for _, name := range backupSnapshots {
+		snapshot, err := store.load(stamp, name)
+		if err != nil {
+			return err
+		}
+		snapshots[name] = snapshot
+	}
+	for _, name := range backupSnapshots {
+		err := restorers[name](bytes.NewReader(snapshots[name]))
+		if err != nil {
+			return fmt.Errorf("restoring %s snapshot of backup %s: %w", name, stamp, err)
+		}
+		log.Info("restored snapshot", "snapshot", name, "backup", stamp)
+	}
+	return nil
+}
+
+// scheduleBackups runs the periodic job backing the cluster up on backup.schedule, or stops it when no
+// schedule is set. The credentials of the job are kept in a nomad variable only it reads.
+func (c *cluster) scheduleBackups() error {
+	inv, err := c.inventory()
+	if err != nil {
+		return err
+	}
+	nomad := newNomadClient(c.config.BaseDir, inv, c.log)
+	if c.config.Backup.Schedule == "" {
+		_, err = nomad.StopJob(backupJobID)
+		if hashistack.IsNomadNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	sec, err := c.secrets()
+	if err != nil {
+		return err
+	}
+	settings, err := newBackupSettings(c.config, sec)
+	if err != nil {
+		return err
+	}
+	consul, err := c.consulClient()
+	if err != nil {
+		return err
+	}
+	err = registerBackupToken(consul, sec, c.config.BaseDir, c.config.DC)
+	if err != nil {
+		return err
+	}
+	file, err := renderBackupJob(c.config, settings, inv.All.Children.NomadServers.GetPrivateHosts())
+	if err != nil {
+		return err
+	}
+	err = nomad.PutVariable(backupJobVariable, map[string]string{
+		"consul_token":  sec.ConsulBackupToken,
+		"s3_access_key": settings.AccessKey,
+		"s3_secret_key": settings.SecretKey,
+		"passphrase":    settings.Passphrase,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = nomad.RunJob(file)
+	if err != nil {
+		return err
+	}
+	c.log.Info("scheduled backups", "schedule", c.config.Backup.Schedule, "bucket", settings.Bucket)
+	return nil
+}
+
+// registerBackupToken creates the token the backup job takes consul snapshots with, which has the backup
+// policy only, rather than handing the job the bootstrap token.
+func registerBackupToken(consul hashistack.Consul, sec *secret.Config, baseDir, dc string) error {
+	if sec.ConsulBackupToken != "" {
+		return nil
+	}
+	dir := filepath.Join(baseDir, "consul")
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, "backup-policy.hcl")
+	err = os.WriteFile(file, []byte(backupPolicy), 0600)
+	if err != nil {
+		return err
+	}
+	err = consul.RegisterPolicy("backup", file)
+	if err != nil {
+		return err
+	}
+	sec.ConsulBackupToken, err = consul.RegisterACL("backup token "+dc, "backup")
+	if err != nil {
+		return err
+	}
+	return sec.Write(baseDir)
+}
+
+// renderBackupJob writes the periodic backup job to base_dir/nomad/backup.hcl and returns its path.
+func renderBackupJob(config *conf.Config, settings *backupSettings, nomadServers []string) (string, error) {
+	tmpl, err := template.New("backup").Delims("[[", "]]").Parse(backupJob)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]interface{}{
+		"DC":           config.DC,
+		"Schedule":     config.Backup.Schedule,
+		"Bucket":       settings.Bucket,
+		"Dir":          settings.Dir,
+		"Region":       settings.Region,
+		"Endpoint":     settings.Endpoint,
+		"Keep":         settings.Keep,
+		"PruneFrom":    settings.Keep + 1,
+		"Iterations":   secret.OpenSSLIterations,
+		"MACLabel":     secret.OpenSSLMACLabel,
+		"NomadServers": nomadServers,
+	})
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(config.BaseDir, "nomad")
+	err = os.MkdirAll(dir, 0750)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, "backup.hcl")
+	return file, os.WriteFile(file, buf.Bytes(), 0600)
+}
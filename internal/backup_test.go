@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/s3"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/hashicorp/hcl2/hclparse"
+	"github.com/stretchr/testify/assert"
+
+	secret "github.com/OpenPaaSDev/openpaas/internal/secrets"
+)
+
+// memBucket is an in-memory bucket.
+type memBucket map[string][]byte
+
+func (m memBucket) Put(key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+func (m memBucket) Get(key string, w io.Writer) error {
+	data, ok := m[key]
+	if !ok {
+		return &s3.APIError{Method: "GET", Key: key, StatusCode: 404}
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func (m memBucket) List(prefix string) ([]s3.Object, error) {
+	objects := []s3.Object{}
+	for key, data := range m {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, s3.Object{Key: key, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+func (m memBucket) Delete(key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m memBucket) keys() []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func testBackupStore(bucket memBucket, keep int) *backupStore {
+	return &backupStore{s3: bucket, dir: "backups/dc1/", keep: keep, kp: secret.NewOpenSSLKeyProvider("passphrase")}
+}
+
+func snapshotOf(content string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write([]byte(content))
+		return err
+	}
+}
+
+func TestBackupUploadsEncryptedSnapshotsAndKeepsTheNewest(t *testing.T) {
+	bucket := memBucket{
+		"backups/dc1/20231001T030000Z/consul.snap.enc": []byte("old"),
+		"backups/dc1/20231001T030000Z/nomad.snap.enc":  []byte("old"),
+		"backups/dc1/20231002T030000Z/consul.snap.enc": []byte("partial"),
+		"backups/dc2/20231001T030000Z/consul.snap.enc": []byte("other datacenter"),
+	}
+	store := testBackupStore(bucket, 2)
+
+	stamp, err := backup(store, map[string]func(io.Writer) error{"consul": snapshotOf("consul state"), "nomad": snapshotOf("nomad state")},
+		time.Date(2023, 10, 3, 3, 0, 0, 0, time.UTC), logging.Discard())
+	assert.NoError(t, err)
+	assert.Equal(t, "20231003T030000Z", stamp)
+	assert.Equal(t, []string{
+		"backups/dc1/20231002T030000Z/consul.snap.enc",
+		"backups/dc1/20231003T030000Z/consul.snap.enc",
+		"backups/dc1/20231003T030000Z/nomad.snap.enc",
+		"backups/dc2/20231001T030000Z/consul.snap.enc",
+	}, bucket.keys())
+	assert.NotContains(t, string(bucket["backups/dc1/20231003T030000Z/consul.snap.enc"]), "consul state")
+	snapshot, err := store.load(stamp, "consul")
+	assert.NoError(t, err)
+	assert.Equal(t, "consul state", string(snapshot))
+
+	backups, err := store.list()
+	assert.NoError(t, err)
+	assert.Equal(t, []Backup{{Timestamp: "20231003T030000Z", Complete: true, Size: int64(len(bucket["backups/dc1/20231003T030000Z/consul.snap.enc"]) + len(bucket["backups/dc1/20231003T030000Z/nomad.snap.enc"]))},
+		{Timestamp: "20231002T030000Z", Size: 7}}, backups)
+
+	// nothing is uploaded when a snapshot fails
+	_, err = backup(store, map[string]func(io.Writer) error{"consul": snapshotOf("consul state"), "nomad": func(io.Writer) error { return errors.New("no leader") }},
+		time.Date(2023, 10, 4, 3, 0, 0, 0, time.UTC), logging.Discard())
+	assert.EqualError(t, err, "nomad snapshot: no leader")
+	assert.Len(t, bucket.keys(), 4)
+}
+
+func TestRestoreDecryptsBothSnapshotsFirst(t *testing.T) {
+	bucket := memBucket{}
+	store := testBackupStore(bucket, 14)
+	_, err := backup(store, map[string]func(io.Writer) error{"consul": snapshotOf("consul state"), "nomad": snapshotOf("nomad state")},
+		time.Date(2023, 10, 1, 3, 0, 0, 0, time.UTC), logging.Discard())
+	assert.NoError(t, err)
+	bucket["backups/dc1/20231002T030000Z/consul.snap.enc"] = []byte("partial")
+
+	stamp, err := latestBackup(store)
+	assert.NoError(t, err)
+	assert.Equal(t, "20231001T030000Z", stamp)
+
+	restored := []string{}
+	restorers := map[string]func(io.Reader) error{}
+	for _, name := range backupSnapshots {
+		restorers[name] = func(r io.Reader) error {
+			b, e := io.ReadAll(r)
+			restored = append(restored, string(b))
+			return e
+		}
+	}
+	assert.NoError(t, restore(store, stamp, restorers, logging.Discard()))
+	assert.Equal(t, []string{"consul state", "nomad state"}, restored)
+
+	// consul is not restored when the nomad snapshot can't be decrypted
+	restored = []string{}
+	bucket["backups/dc1/20231001T030000Z/nomad.snap.enc"] = []byte("nomad state")
+	err = restore(store, stamp, restorers, logging.Discard())
+	assert.ErrorIs(t, err, secret.ErrDecrypt)
+	assert.Empty(t, restored)
+
+	_, err = latestBackup(testBackupStore(memBucket{}, 14))
+	assert.EqualError(t, err, "no complete backup found at backups/dc1/")
+}
+
+func TestBackupSettings(t *testing.T) {
+	config := &conf.Config{DC: "dc1", Backup: conf.Backup{Bucket: "backups", Prefix: "openpaas/"}}
+	sec := &secret.Config{S3Endpoint: "https://minio.example.com", S3AccessKey: "sec-access", S3SecretKey: "sec-secret"}
+
+	t.Setenv(BackupPassphraseEnv, "")
+	_, err := newBackupSettings(config, sec)
+	assert.EqualError(t, err, "backups are encrypted, please set OPENPAAS_BACKUP_PASSPHRASE")
+
+	t.Setenv(BackupPassphraseEnv, "passphrase")
+	t.Setenv("S3_ACCESS_KEY", "")
+	t.Setenv("S3_SECRET_KEY", "")
+	settings, err := newBackupSettings(config, sec)
+	assert.NoError(t, err)
+	assert.Equal(t, &backupSettings{Bucket: "backups", Dir: "openpaas/dc1/", Region: "us-east-1", Endpoint: "https://minio.example.com",
+		AccessKey: "sec-access", SecretKey: "sec-secret", Passphrase: "passphrase", Keep: 14}, settings)
+
+	t.Setenv("S3_ACCESS_KEY", "env-access")
+	t.Setenv("S3_SECRET_KEY", "env-secret")
+	config.Backup = conf.Backup{Bucket: "backups", Endpoint: "https://s3.example.com", Keep: 3}
+	settings, err = newBackupSettings(config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "dc1/", settings.Dir)
+	assert.Equal(t, "https://s3.example.com", settings.Endpoint)
+	assert.Equal(t, "env-access", settings.AccessKey)
+	assert.Equal(t, 3, settings.Keep)
+
+	config.Backup.Bucket = ""
+	_, err = newBackupSettings(config, sec)
+	assert.EqualError(t, err, "backup.bucket is not set in the config")
+}
+
+func TestRenderBackupJob(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(folder))
+	}()
+	config := &conf.Config{DC: "dc1", BaseDir: folder, Backup: conf.Backup{Schedule: "0 3 * * *"}}
+	settings := &backupSettings{Bucket: "backups", Dir: "openpaas/dc1/", Region: "eu-central-1", Endpoint: "https://minio.example.com", Keep: 7}
+
+	file, err := renderBackupJob(config, settings, []string{"10.0.1.2", "10.0.1.3"})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(folder, "nomad", "backup.hcl"), file)
+	job, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	for _, expected := range []string{
+		`datacenters = ["dc1"]`,
+		`cron             = "0 3 * * *"`,
+		`dest="s3://backups/openpaas/dc1/"`,
+		`s3="aws s3 --region eu-central-1 --endpoint-url https://minio.example.com"`,
+		`for server in 10.0.1.2 10.0.1.3; do`,
+		`-pbkdf2 -iter 100000 -md sha256`,
+		`printf 'openpaas backup mac' | openssl dgst -sha256 -mac HMAC`,
+		`tail -n +8`,
+		`{{ with nomadVar "nomad/jobs/openpaas-backup" }}`,
+		`consul snapshot save consul.snap`,
+		`image      = "amazon/aws-cli:2.13.0"`,
+	} {
+		assert.Contains(t, string(job), expected)
+	}
+	// the images ship the tools, nothing is installed on every run
+	assert.NotContains(t, string(job), "apk add")
+	_, diags := hclparse.NewParser().ParseHCL(job, file)
+	assert.False(t, diags.HasErrors(), diags.Error())
+}
+
+func TestRegisterBackupToken(t *testing.T) {
+	folder := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(folder, "secrets"), 0750))
+	policies := map[string]string{}
+	consul := &MockConsul{
+		RegisterPolicyFunc: func(name, file string) error {
+			rules, err := os.ReadFile(file)
+			policies[name] = string(rules)
+			return err
+		},
+		RegisterACLFunc: func(description, policy string) (string, error) {
+			return description + ":" + policy, nil
+		},
+	}
+	sec := &secret.Config{ConsulBootstrapToken: "bootstrap"}
+
+	assert.NoError(t, registerBackupToken(consul, sec, folder, "dc1"))
+	assert.Equal(t, "backup token dc1:backup", sec.ConsulBackupToken)
+	assert.Contains(t, policies["backup"], `acl = "write"`)
+	stored, err := secret.Load(folder)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup token dc1:backup", stored.ConsulBackupToken)
+
+	assert.NoError(t, registerBackupToken(consul, sec, folder, "dc1"))
+	assert.Len(t, consul.RegisterACLCalls(), 1)
+}
@@ -2,15 +2,22 @@ package internal
 
 import (
 	"bytes"
+	"crypto/rand"
 	_ "embed"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
 	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack/vault"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
 	"github.com/OpenPaaSDev/openpaas/internal/runtime"
 	sec "github.com/OpenPaaSDev/openpaas/internal/secrets"
 )
@@ -60,9 +67,6 @@ var consulServer string
 //go:embed templates/consul/consul-client-config.hcl
 var consulClient string
 
-//go:embed templates/nomad/cfssl.json
-var cfssl string
-
 //go:embed templates/nomad/client.j2
 var nomadClient string
 
@@ -97,8 +101,19 @@ var nomadAnsible string
 var vaultAnsible string
 
 // calculate bootstrap expect from files
-func Configure(inventory *ansible.Inventory, baseDir, dcName string) error {
+func Configure(inventory *ansible.Inventory, baseDir, dcName string, seal conf.AutoUnseal, log *logging.Logger) error {
+	err := renderConfigs(inventory, baseDir, dcName, seal)
+	if err != nil {
+		return err
+	}
 
+	err = Secrets(inventory, baseDir, dcName, log)
+	return err
+}
+
+// renderConfigs writes the playbooks and service configuration for the inventory to baseDir, without
+// generating any secrets.
+func renderConfigs(inventory *ansible.Inventory, baseDir, dcName string, seal conf.AutoUnseal) error {
 	err := os.MkdirAll(filepath.Join(baseDir), 0750)
 	if err != nil {
 		return err
@@ -128,13 +143,7 @@ func Configure(inventory *ansible.Inventory, baseDir, dcName string) error {
 	if err != nil {
 		return err
 	}
-	err = makeConfigs(inventory, baseDir, dcName)
-	if err != nil {
-		return err
-	}
-
-	err = Secrets(inventory, baseDir, dcName)
-	return err
+	return makeConfigs(inventory, baseDir, dcName, seal)
 }
 
 func makeConsulPolicies(inventory *ansible.Inventory, baseDir string) error {
@@ -149,7 +158,9 @@ func makeConsulPolicies(inventory *ansible.Inventory, baseDir string) error {
 	}
 	_ = os.Remove(filepath.Join(baseDir, "consul", "consul-policies.hcl"))
 
+	// sorted so the rendered policy only changes when the hosts do
 	hosts := inventory.GetAllPrivateHosts()
+	sort.Strings(hosts)
 
 	tmpl, e := template.New("consul-policies").Parse(consulPolicies)
 	if e != nil {
@@ -189,7 +200,7 @@ func makeConsulPolicies(inventory *ansible.Inventory, baseDir string) error {
 	return nil
 }
 
-func makeConfigs(inventory *ansible.Inventory, baseDir, dcName string) error {
+func makeConfigs(inventory *ansible.Inventory, baseDir, dcName string, seal conf.AutoUnseal) error {
 	hostMap := make(map[string]string)
 	hosts := ""
 	first := true
@@ -230,6 +241,10 @@ func makeConfigs(inventory *ansible.Inventory, baseDir, dcName string) error {
 	if err != nil {
 		return err
 	}
+	sealStanza, err := vault.SealStanza(seal)
+	if err != nil {
+		return err
+	}
 	nomadServerService := strings.ReplaceAll(nomadService, "nomad_user", "nomad")
 	nomadClientService := strings.ReplaceAll(nomadService, "nomad_user", "root")
 
@@ -246,7 +261,7 @@ func makeConfigs(inventory *ansible.Inventory, baseDir, dcName string) error {
 		filepath.Join(baseDir, "nomad", "web.hcl"):         strings.Replace(nomadHealthCheck, "{DATACENTRE}", dcName, -1),
 		filepath.Join(baseDir, "consul", "consul.service"): consulService,
 		filepath.Join(baseDir, "vault", "vault.service"):   vaultService,
-		filepath.Join(baseDir, "vault", "config.hcl"):      vaultConf,
+		filepath.Join(baseDir, "vault", "config.hcl"):      vaultConf + sealStanza,
 	}
 
 	for k, v := range toWrite {
@@ -259,27 +274,49 @@ func makeConfigs(inventory *ansible.Inventory, baseDir, dcName string) error {
 	return nil
 }
 
-func Secrets(inventory *ansible.Inventory, baseDir, dcName string) error {
-	var out bytes.Buffer
-	err := runtime.Exec(&runtime.EmptyEnv{}, "consul keygen", &out)
+// plaintextSecrets are the files of base_dir/secrets that are not encrypted at rest: the private keys of
+// the CAs and certificates, which ansible copies to the servers and the consul and nomad CLIs of the env
+// file read as they are, and secrets.yml when no passphrase is set. They are listed in
+// base_dir/secrets/.gitignore, so that they are left out when base_dir is committed.
+var plaintextSecrets = []string{"secrets.yml", "*-key.pem", "tls.key"}
+
+func writeSecretsIgnore(secretsDir string) error {
+	ignore := "# written by openpaas, these secrets are kept in plaintext\n" + strings.Join(plaintextSecrets, "\n") + "\n"
+	return os.WriteFile(filepath.Join(secretsDir, ".gitignore"), []byte(ignore), 0600)
+}
+
+// gossipKey returns a key for the gossip encryption of consul or nomad, 32 random bytes in base64 as
+// consul keygen and nomad operator keygen write them.
+func gossipKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
 	if err != nil {
-		return err
+		return "", err
 	}
+	encoded := base64.StdEncoding.EncodeToString(key)
+	runtime.RegisterSecret(encoded)
+	return encoded, nil
+}
+
+func Secrets(inventory *ansible.Inventory, baseDir, dcName string, log *logging.Logger) error {
 	consulSecretDir := filepath.Join(baseDir, "secrets", "consul")
 	nomadSecretDir := filepath.Join(baseDir, "secrets", "nomad")
-	err = os.MkdirAll(consulSecretDir, 0750)
+	err := os.MkdirAll(consulSecretDir, 0750)
 	if err != nil {
 		return err
 	}
-	consulGossipKey := strings.ReplaceAll(out.String(), "\n", "")
-
-	var out2 bytes.Buffer
-	err = runtime.Exec(&runtime.EmptyEnv{}, "nomad operator keygen", &out2)
-
+	err = writeSecretsIgnore(filepath.Join(baseDir, "secrets"))
+	if err != nil {
+		return err
+	}
+	consulGossipKey, err := gossipKey()
+	if err != nil {
+		return err
+	}
+	nomadGossipKey, err := gossipKey()
 	if err != nil {
 		return err
 	}
-	nomadGossipKey := strings.ReplaceAll(out2.String(), "\n", "")
 	if os.Getenv("S3_ENDPOINT") == "" || os.Getenv("S3_SECRET_KEY") == "" || os.Getenv("S3_ACCESS_KEY") == "" {
 		return fmt.Errorf("s3 compatible env variables missing for storing state: please set S3_ENDPOINT, S3_SECRET_KEY & S3_ACCESS_KEY")
 	}
@@ -297,7 +334,7 @@ func Secrets(inventory *ansible.Inventory, baseDir, dcName string) error {
 		S3AccessKey:            os.Getenv("S3_ACCESS_KEY"),
 	}
 
-	if _, err1 := os.Stat(sec.File(baseDir)); errors.Is(err1, os.ErrNotExist) {
+	if !sec.Exists(baseDir) {
 		e := secrets.Write(baseDir)
 		if e != nil {
 			return e
@@ -312,46 +349,28 @@ func Secrets(inventory *ansible.Inventory, baseDir, dcName string) error {
 		return err
 	}
 	if _, err := os.Stat(filepath.Join(baseDir, "secrets", "consul", "consul-agent-ca.pem")); errors.Is(err, os.ErrNotExist) {
-		err = runtime.Exec(runtime.EnvWithDir(consulSecretDir), "consul tls ca create", os.Stdout)
+		err = createCA("Consul Agent CA", filepath.Join(consulSecretDir, "consul-agent-ca.pem"), filepath.Join(consulSecretDir, "consul-agent-ca-key.pem"), "consul", "localhost")
 		if err != nil {
 			return err
 		}
-		err = runtime.Exec(runtime.EnvWithDir(consulSecretDir), fmt.Sprintf("consul tls cert create -server -dc %s", dcName), os.Stdout)
+	}
+	// created on its own, as secondary datacenters of a federation share the CA of the primary
+	if _, err := os.Stat(filepath.Join(consulSecretDir, consulServerCert(dcName))); errors.Is(err, os.ErrNotExist) {
+		err = issueConsulServerCert(consulSecretDir, consulSecretDir, dcName)
 		if err != nil {
 			return err
 		}
-
 	}
 
 	if _, err := os.Stat(filepath.Join(baseDir, "secrets", "nomad", "cli.pem")); errors.Is(err, os.ErrNotExist) {
-		err = runtime.Exec(runtime.EnvWithDir(nomadSecretDir), "cfssl print-defaults csr | cfssl gencert -initca - | cfssljson -bare nomad-ca", os.Stdout)
-		if err != nil {
-			return err
-		}
-		hosts := inventory.All.Children.NomadServers.GetHosts()
-		privateHosts := inventory.All.Children.NomadServers.GetPrivateHosts()
-		hostString := fmt.Sprintf("server.global.nomad,%s,%s", strings.Join(hosts, ","), strings.Join(privateHosts, ","))
-		fmt.Println("generating cert for hosts: " + hostString)
-
-		err = os.WriteFile(filepath.Join(nomadSecretDir, "cfssl.json"), []byte(cfssl), 0600)
-		if err != nil {
-			return err
-		}
-		err = runtime.Exec(runtime.EnvWithDir(nomadSecretDir), fmt.Sprintf(`echo '{}' | cfssl gencert -ca=nomad-ca.pem -ca-key=nomad-ca-key.pem -config=cfssl.json -hostname="%s" - | cfssljson -bare server`, hostString), os.Stdout)
+		err = createCA("Nomad CA", filepath.Join(nomadSecretDir, "nomad-ca.pem"), filepath.Join(nomadSecretDir, "nomad-ca-key.pem"))
 		if err != nil {
 			return err
 		}
-
-		err = runtime.Exec(runtime.EnvWithDir(nomadSecretDir), fmt.Sprintf(`echo '{}' | cfssl gencert -ca=nomad-ca.pem -ca-key=nomad-ca-key.pem -config=cfssl.json -hostname="%s" - | cfssljson -bare client`, hostString), os.Stdout)
+		err = issueNomadCerts(inventory, nomadSecretDir, nomadSecretDir, log)
 		if err != nil {
 			return err
 		}
-
-		err = runtime.Exec(runtime.EnvWithDir(nomadSecretDir), fmt.Sprintf(`echo '{}' | cfssl gencert -ca=nomad-ca.pem -ca-key=nomad-ca-key.pem -config=cfssl.json -hostname="%s" - | cfssljson -bare cli`, hostString), os.Stdout)
-		if err != nil {
-			return err
-		}
-
 	}
 	return nil
 }
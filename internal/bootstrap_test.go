@@ -1,13 +1,19 @@
 package internal
 
 import (
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/pki"
 	sec "github.com/OpenPaaSDev/openpaas/internal/secrets"
 	"github.com/OpenPaaSDev/openpaas/internal/util"
 	"github.com/stretchr/testify/assert"
@@ -58,7 +64,7 @@ func TestMakeConsulPoliciesAndHashiConfigs(t *testing.T) {
 
 	inv, err = ansible.LoadInventory(filepath.Clean(filepath.Join("testdata", "inventory")))
 	assert.NoError(t, err)
-	assert.NoError(t, makeConfigs(inv, folder, "hetzner"))
+	assert.NoError(t, makeConfigs(inv, folder, "hetzner", conf.AutoUnseal{}))
 
 	serverBytes, err := os.ReadFile(filepath.Clean(filepath.Join(folder, "consul", "server.j2")))
 	assert.NoError(t, err)
@@ -94,11 +100,11 @@ func TestMakeSecrets(t *testing.T) {
 	}()
 	inv, err := ansible.LoadInventory(filepath.Join("testdata", "inventory"))
 	assert.NoError(t, err)
-	err = Secrets(inv, folder, "dc1")
+	err = Secrets(inv, folder, "dc1", logging.Discard())
 	assert.NoError(t, err)
 	bytes, err := os.ReadFile(filepath.Clean(filepath.Join(folder, "secrets", "secrets.yml")))
 	assert.NoError(t, err)
-	err = Secrets(inv, folder, "dc1")
+	err = Secrets(inv, folder, "dc1", logging.Discard())
 	assert.NoError(t, err)
 	bytes2, err := os.ReadFile(filepath.Clean(filepath.Join(folder, "secrets", "secrets.yml")))
 	assert.NoError(t, err)
@@ -116,9 +122,12 @@ func TestMakeSecrets(t *testing.T) {
 	var theMap map[string]interface{}
 	err = yaml.Unmarshal([]byte(bytes), &theMap)
 	assert.NoError(t, err)
-	assert.NotEmpty(t, theMap["CONSUL_GOSSIP_KEY"])
-
-	assert.NotEmpty(t, theMap["NOMAD_GOSSIP_KEY"])
+	for _, name := range []string{"CONSUL_GOSSIP_KEY", "NOMAD_GOSSIP_KEY"} {
+		key, err := base64.StdEncoding.DecodeString(theMap[name].(string))
+		assert.NoError(t, err)
+		assert.Len(t, key, 32, name)
+	}
+	assert.NotEqual(t, theMap["CONSUL_GOSSIP_KEY"], theMap["NOMAD_GOSSIP_KEY"])
 
 	_, err = os.ReadFile(filepath.Clean(filepath.Join(folder, "secrets", "consul", "consul-agent-ca-key.pem")))
 	assert.NoError(t, err)
@@ -132,17 +141,12 @@ func TestMakeSecrets(t *testing.T) {
 
 	nomadDir := filepath.Join(folder, "secrets", "nomad")
 	files := []string{
-		"cfssl.json",
-		"nomad-ca.csr",
 		"nomad-ca-key.pem",
 		"nomad-ca.pem",
-		"cli.csr",
 		"cli-key.pem",
 		"cli.pem",
-		"client.csr",
 		"client-key.pem",
 		"client.pem",
-		"server.csr",
 		"server-key.pem",
 		"server.pem",
 	}
@@ -151,12 +155,45 @@ func TestMakeSecrets(t *testing.T) {
 		assertFileExists(t, filepath.Join(nomadDir, path))
 	}
 
+	ca, err := pki.ReadCertificate(filepath.Join(nomadDir, "nomad-ca.pem"))
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	server, err := pki.ReadCertificate(filepath.Join(nomadDir, "server.pem"))
+	assert.NoError(t, err)
+	_, err = server.Verify(x509.VerifyOptions{Roots: roots, DNSName: "server.global.nomad"})
+	assert.NoError(t, err)
+	_, err = server.Verify(x509.VerifyOptions{Roots: roots, DNSName: "10.0.0.3"})
+	assert.NoError(t, err)
+
 	secrets, err := sec.Load(folder)
 	assert.NoError(t, err)
 	assert.Equal(t, "TBD", secrets.ConsulBootstrapToken)
 	assert.Equal(t, "TBD", secrets.ConsulAgentToken)
 	assert.Equal(t, "TBD", secrets.NomadClientConsulToken)
 	assert.Equal(t, "TBD", secrets.NomadServerConsulToken)
+
+	// besides the certificates, the files of secrets are either encrypted or left out of commits
+	err = filepath.WalkDir(filepath.Join(folder, "secrets"), func(path string, d fs.DirEntry, e error) error {
+		if e != nil || d.IsDir() || d.Name() == ".gitignore" || strings.HasSuffix(d.Name(), sec.EncryptedSuffix) {
+			return e
+		}
+		if _, e = pki.ReadCertificate(path); e == nil {
+			return nil
+		}
+		ignored := false
+		for _, pattern := range plaintextSecrets {
+			if match, _ := filepath.Match(pattern, d.Name()); match {
+				ignored = true
+			}
+		}
+		assert.True(t, ignored, "%s is neither encrypted nor in .gitignore", path)
+		return nil
+	})
+	assert.NoError(t, err)
+	ignore, err := os.ReadFile(filepath.Join(folder, "secrets", ".gitignore"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(ignore), "\n*-key.pem\n")
 }
 
 func assertFileExists(t *testing.T, path string) {
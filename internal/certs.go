@@ -0,0 +1,318 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509/pkix"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack/vault"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/pki"
+)
+
+//go:embed templates/ansible/certs.yml
+var certsAnsible string
+
+// CertExpiryWarning is how long before they expire certificates are reported as expiring.
+const CertExpiryWarning = 30 * 24 * time.Hour
+
+const (
+	// caValidity is how long the consul and nomad CAs are valid.
+	caValidity = 5 * 365 * 24 * time.Hour
+	// consulServerCertValidity is how long the certificate of the consul servers is valid.
+	consulServerCertValidity = 365 * 24 * time.Hour
+	// nomadCertValidity is how long the server, client and cli certificates of nomad are valid.
+	nomadCertValidity = 10 * 365 * 24 * time.Hour
+)
+
+// Certificate is a certificate sync generated in base_dir/secrets.
+type Certificate struct {
+	// File is the path of the certificate relative to base_dir/secrets.
+	File     string
+	Subject  string
+	IsCA     bool
+	DNSNames []string
+	IPs      []string
+	NotAfter time.Time
+	// Missing are the hosts of the inventory the certificate is not issued for, such as servers added
+	// by scaling. They are added by rotating the certificates.
+	Missing []string
+}
+
+// SANs are the DNS names and IP addresses the certificate is issued for.
+func (c *Certificate) SANs() []string {
+	return append(append([]string{}, c.DNSNames...), c.IPs...)
+}
+
+// Expiring reports whether the certificate expires within CertExpiryWarning of now.
+func (c *Certificate) Expiring(now time.Time) bool {
+	return c.NotAfter.Before(now.Add(CertExpiryWarning))
+}
+
+// issuedCert is a certificate sync issues, with the hosts it must be issued for.
+type issuedCert struct {
+	file  string
+	hosts func(inv *ansible.Inventory) []string
+}
+
+func issuedCerts(dc string) []issuedCert {
+	return []issuedCert{
+		{filepath.Join("consul", "consul-agent-ca.pem"), nil},
+		{filepath.Join("consul", consulServerCert(dc)), func(*ansible.Inventory) []string {
+			return []string{fmt.Sprintf("server.%s.consul", dc)}
+		}},
+		{filepath.Join("nomad", "nomad-ca.pem"), nil},
+		{filepath.Join("nomad", "server.pem"), nomadHosts},
+		{filepath.Join("nomad", "client.pem"), nomadHosts},
+		{filepath.Join("nomad", "cli.pem"), nomadHosts},
+		{filepath.Join("vault", "tls.crt"), func(inv *ansible.Inventory) []string {
+			return append([]string{"vault.service.consul", "active.vault.service.consul"}, inv.All.Children.VaultServers.GetPrivateHosts()...)
+		}},
+	}
+}
+
+// consulServerCert is the file name of the certificate of the consul servers of dc.
+func consulServerCert(dc string) string {
+	return fmt.Sprintf("%s-server-consul-0.pem", dc)
+}
+
+// createCA creates a CA named cn, which only issues certificates for DNS names in permittedDomains when
+// they are given, and writes it to certFile and keyFile.
+func createCA(cn, certFile, keyFile string, permittedDomains ...string) error {
+	ca, err := pki.NewCA(pki.Request{Subject: pkix.Name{CommonName: cn}, Validity: caValidity}, permittedDomains...)
+	if err != nil {
+		return err
+	}
+	return ca.Write(certFile, keyFile)
+}
+
+// nomadHosts are the hosts the certificates of the nomad servers, clients and cli are issued for.
+func nomadHosts(inv *ansible.Inventory) []string {
+	hosts := []string{"server.global.nomad"}
+	hosts = append(hosts, inv.All.Children.NomadServers.GetHosts()...)
+	return append(hosts, inv.All.Children.NomadServers.GetPrivateHosts()...)
+}
+
+// Certificates returns the certificates of the cluster in base_dir/secrets. When there is an inventory,
+// the hosts they are missing are reported as well.
+func Certificates(config *conf.Config) ([]Certificate, error) {
+	inv, err := ansible.LoadInventory(filepath.Join(config.BaseDir, "inventory"))
+	if err != nil {
+		// without an inventory, such as before the terraform phase, there are no hosts to check
+		inv = nil
+	}
+	return certificates(filepath.Join(config.BaseDir, "secrets"), config.DC, inv)
+}
+
+func certificates(secretsDir, dc string, inv *ansible.Inventory) ([]Certificate, error) {
+	certs := []Certificate{}
+	for _, issued := range issuedCerts(dc) {
+		cert, err := pki.ReadCertificate(filepath.Join(secretsDir, issued.file))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", issued.file, err)
+		}
+		c := Certificate{File: issued.file, Subject: cert.Subject.String(), IsCA: cert.IsCA, DNSNames: cert.DNSNames, NotAfter: cert.NotAfter}
+		names := map[string]bool{}
+		for _, name := range cert.DNSNames {
+			names[name] = true
+		}
+		for _, ip := range cert.IPAddresses {
+			c.IPs = append(c.IPs, ip.String())
+			names[ip.String()] = true
+		}
+		if inv != nil && issued.hosts != nil {
+			for _, host := range issued.hosts(inv) {
+				if !names[host] {
+					c.Missing = append(c.Missing, host)
+				}
+			}
+			sort.Strings(c.Missing)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s, run sync first", secretsDir)
+	}
+	return certs, nil
+}
+
+// issueConsulServerCert issues the certificate of the consul servers of dc to dir with the consul CA of
+// caDir.
+func issueConsulServerCert(caDir, dir, dc string) error {
+	ca, err := pki.Load(filepath.Join(caDir, "consul-agent-ca.pem"), filepath.Join(caDir, "consul-agent-ca-key.pem"))
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("server.%s.consul", dc)
+	cert, err := ca.Issue(pki.Request{Subject: pkix.Name{CommonName: name}, Hosts: []string{name, "localhost", "127.0.0.1"}, Validity: consulServerCertValidity})
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, consulServerCert(dc))
+	return cert.Write(file, strings.TrimSuffix(file, ".pem")+"-key.pem")
+}
+
+// issueNomadCerts issues the server, client and cli certificates of nomad to dir with the nomad CA of
+// caDir.
+func issueNomadCerts(inventory *ansible.Inventory, caDir, dir string, log *logging.Logger) error {
+	ca, err := pki.Load(filepath.Join(caDir, "nomad-ca.pem"), filepath.Join(caDir, "nomad-ca-key.pem"))
+	if err != nil {
+		return err
+	}
+	hosts := nomadHosts(inventory)
+	log.Info("generating nomad certificates", "hosts", strings.Join(hosts, ","))
+
+	for _, name := range []string{"server", "client", "cli"} {
+		cert, err := ca.Issue(pki.Request{Subject: pkix.Name{CommonName: name}, Hosts: hosts, Validity: nomadCertValidity})
+		if err != nil {
+			return err
+		}
+		err = cert.Write(filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reissue runs issue in a temporary folder of dir and then moves the certificates and keys it issued to
+// dir, so that the previous ones are only replaced once all of them were issued.
+func reissue(dir string, issue func(tmp string) error) error {
+	tmp, err := os.MkdirTemp(dir, "reissue-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp) //nolint
+	err = issue(tmp)
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(tmp, "*.pem"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no certificates were issued to %s", tmp)
+	}
+	for _, file := range files {
+		err = os.Rename(file, filepath.Join(dir, filepath.Base(file)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reissueCerts issues the certificates of the consul servers, of nomad and of vault again, for the hosts
+// of inv, with the CAs and the vault key that exist.
+func reissueCerts(config *conf.Config, inv *ansible.Inventory, log *logging.Logger) error {
+	consulDir := filepath.Join(config.BaseDir, "secrets", "consul")
+	err := reissue(consulDir, func(tmp string) error {
+		return issueConsulServerCert(consulDir, tmp, config.DC)
+	})
+	if err != nil {
+		return fmt.Errorf("consul server certificate: %w", err)
+	}
+	nomadDir := filepath.Join(config.BaseDir, "secrets", "nomad")
+	err = reissue(nomadDir, func(tmp string) error {
+		return issueNomadCerts(inv, nomadDir, tmp, log)
+	})
+	if err != nil {
+		return fmt.Errorf("nomad certificates: %w", err)
+	}
+	err = vault.RotateTLS(config, inv)
+	if err != nil {
+		return fmt.Errorf("vault certificate: %w", err)
+	}
+	return nil
+}
+
+// RotateCertificates reissues the certificates of the consul servers, of nomad and of vault from the CAs
+// that exist, for the hosts of the inventory, and rolls them out with ansible. The services reload their
+// certificates one host at a time, servers before clients and leaders last, and the rotation stops at
+// the first host after which the cluster is not as healthy as before.
+func RotateCertificates(ctx context.Context, config *conf.Config, log *logging.Logger) error {
+	c, err := newCluster(config, log.With("dc", config.DC))
+	if err != nil {
+		return err
+	}
+	// reloading keeps vault unsealed, so there are no unseal keys to ask for
+	u, err := c.newUpgrader(func(host string, status *vault.SealStatus) (string, error) {
+		return "", fmt.Errorf("%w: %s, run `openpaas vault unseal`", vault.ErrSealed, host)
+	})
+	if err != nil {
+		return err
+	}
+	before, err := u.health()
+	if err != nil {
+		return err
+	}
+	if problems := u.healthy().regressions(before); len(problems) > 0 {
+		return fmt.Errorf("the cluster is not healthy, fix it before rotating certificates: %s", strings.Join(problems, ", "))
+	}
+
+	err = reissueCerts(config, u.inv, c.log)
+	if err != nil {
+		return err
+	}
+	for _, service := range []string{"consul", "nomad", "vault"} {
+		playbook, err := renderCertsPlaybook(config.BaseDir, config.DC, service)
+		if err != nil {
+			return err
+		}
+		servers, err := u.servers(service)
+		if err != nil {
+			return err
+		}
+		for _, host := range servers {
+			c.log.Info("rolling out certificates", "service", service, "host", host)
+			err = u.rollout(ctx, "certificate rotation", playbook, before, host)
+			if err != nil {
+				return err
+			}
+		}
+		if service != "nomad" {
+			continue
+		}
+		clients := u.inv.All.Children.Clients.GetHosts()
+		sort.Strings(clients)
+		if len(clients) > 0 {
+			c.log.Info("rolling out certificates", "service", service, "hosts", len(clients))
+			err = u.rollout(ctx, "certificate rotation", playbook, before, clients...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderCertsPlaybook writes the playbook rolling out the certificates of service to base_dir and
+// returns its path.
+func renderCertsPlaybook(baseDir, dc, service string) (string, error) {
+	tmpl, err := template.New("certs").Delims("[[", "]]").Parse(certsAnsible)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]string{"DC": dc, "Service": service})
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(baseDir, fmt.Sprintf("certs-%s.yml", service))
+	return file, os.WriteFile(file, buf.Bytes(), 0600)
+}
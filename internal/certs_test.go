@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack/vault"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/pki"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// writeTestCert writes a certificate for names, which are IP addresses or DNS names, to file.
+func writeTestCert(t *testing.T, file, cn string, notAfter time.Time, names ...string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  len(names) == 0,
+		BasicConstraintsValid: true,
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0750))
+	assert.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+}
+
+func TestCertificates(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(folder))
+	}()
+	now := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	_, err := certificates(folder, "dc1", nil)
+	assert.EqualError(t, err, "no certificates found in "+folder+", run sync first")
+
+	writeTestCert(t, filepath.Join(folder, "consul", "consul-agent-ca.pem"), "Consul Agent CA", now.Add(5*365*24*time.Hour))
+	writeTestCert(t, filepath.Join(folder, "consul", "dc1-server-consul-0.pem"), "server.dc1.consul", now.Add(10*24*time.Hour),
+		"server.dc1.consul", "localhost", "127.0.0.1")
+	// issued before 127.0.0.3 was added
+	writeTestCert(t, filepath.Join(folder, "nomad", "server.pem"), "", now.Add(3650*24*time.Hour),
+		"server.global.nomad", "127.0.0.1", "127.0.0.2", "10.0.0.1", "10.0.0.2")
+	writeTestCert(t, filepath.Join(folder, "vault", "tls.crt"), "Vault", now.Add(-24*time.Hour),
+		"vault.service.consul", "active.vault.service.consul", "10.0.1.1", "10.0.1.2", "0.0.0.0")
+
+	certs, err := certificates(folder, "dc1", mustInventory(t))
+	assert.NoError(t, err)
+	assert.Len(t, certs, 4)
+	files, expiring := []string{}, []string{}
+	for _, c := range certs {
+		files = append(files, c.File)
+		if c.Expiring(now) {
+			expiring = append(expiring, c.File)
+		}
+	}
+	assert.Equal(t, []string{"consul/consul-agent-ca.pem", "consul/dc1-server-consul-0.pem", "nomad/server.pem", "vault/tls.crt"}, files)
+	assert.Equal(t, []string{"consul/dc1-server-consul-0.pem", "vault/tls.crt"}, expiring)
+
+	assert.True(t, certs[0].IsCA)
+	assert.Empty(t, certs[0].Missing)
+	assert.Equal(t, "CN=server.dc1.consul", certs[1].Subject)
+	assert.Equal(t, []string{"server.dc1.consul", "localhost", "127.0.0.1"}, certs[1].SANs())
+	assert.Empty(t, certs[1].Missing)
+	assert.Equal(t, []string{"10.0.0.3", "127.0.0.3"}, certs[2].Missing)
+	assert.Empty(t, certs[3].Missing)
+
+	// nothing is missing without an inventory
+	certs, err = certificates(folder, "dc1", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, certs[2].Missing)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(folder, "nomad", "client.pem"), []byte("not a certificate"), 0600))
+	_, err = certificates(folder, "dc1", nil)
+	assert.EqualError(t, err, "nomad/client.pem: no PEM encoded certificate found")
+}
+
+func TestReissueReplacesOnlyOnceIssued(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(folder))
+	}()
+	assert.NoError(t, os.MkdirAll(folder, 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(folder, "server.pem"), []byte("old"), 0600))
+
+	err := reissue(folder, func(tmp string) error {
+		assert.NoError(t, os.WriteFile(filepath.Join(tmp, "server.pem"), []byte("half"), 0600))
+		return errors.New("issuing failed")
+	})
+	assert.EqualError(t, err, "issuing failed")
+	old, err := os.ReadFile(filepath.Join(folder, "server.pem"))
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(old))
+
+	err = reissue(folder, func(tmp string) error {
+		assert.NoError(t, os.WriteFile(filepath.Join(tmp, "server.csr"), []byte("csr"), 0600))
+		assert.NoError(t, os.WriteFile(filepath.Join(tmp, "server.pem"), []byte("new"), 0600))
+		return os.WriteFile(filepath.Join(tmp, "server-key.pem"), []byte("key"), 0600)
+	})
+	assert.NoError(t, err)
+	entries, err := os.ReadDir(folder)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"server-key.pem", "server.pem"}, names)
+	renewed, err := os.ReadFile(filepath.Join(folder, "server.pem"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(renewed))
+}
+
+func TestRenderCertsPlaybook(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(folder))
+	}()
+	assert.NoError(t, os.MkdirAll(folder, 0750))
+
+	expected := map[string][]string{
+		"consul": {"consul_servers"},
+		"nomad":  {"nomad_servers", "clients"},
+		"vault":  {"vault_servers"},
+	}
+	for service, groups := range expected {
+		file, err := renderCertsPlaybook(folder, "fsn1", service)
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(folder, "certs-"+service+".yml"), file)
+		content, err := os.ReadFile(file)
+		assert.NoError(t, err)
+
+		var plays []struct {
+			Hosts string `yaml:"hosts"`
+			Tasks []map[string]interface{}
+		}
+		assert.NoError(t, yaml.Unmarshal(content, &plays))
+		hosts := []string{}
+		for _, play := range plays {
+			hosts = append(hosts, play.Hosts)
+			last := play.Tasks[len(play.Tasks)-1]["ansible.builtin.systemd"].(map[string]interface{})
+			assert.Equal(t, "reloaded", last["state"])
+			assert.Equal(t, service, last["name"])
+		}
+		assert.Equal(t, groups, hosts)
+		if service == "consul" {
+			assert.Contains(t, string(content), "src: secrets/consul/fsn1-server-consul-0.pem")
+		}
+	}
+}
+
+func TestReissueCertsForTheInventory(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(folder))
+	}()
+	config := &conf.Config{DC: "dc1", BaseDir: folder, OrgName: "openpaas"}
+	consulDir := filepath.Join(folder, "secrets", "consul")
+	nomadDir := filepath.Join(folder, "secrets", "nomad")
+	assert.NoError(t, os.MkdirAll(consulDir, 0750))
+	assert.NoError(t, os.MkdirAll(nomadDir, 0750))
+	assert.NoError(t, createCA("Consul Agent CA", filepath.Join(consulDir, "consul-agent-ca.pem"), filepath.Join(consulDir, "consul-agent-ca-key.pem"), "consul", "localhost"))
+	assert.NoError(t, createCA("Nomad CA", filepath.Join(nomadDir, "nomad-ca.pem"), filepath.Join(nomadDir, "nomad-ca-key.pem")))
+	inv := mustInventory(t)
+	assert.NoError(t, vault.GenerateTLS(config, inv))
+
+	assert.NoError(t, reissueCerts(config, inv, logging.Discard()))
+	certs, err := certificates(filepath.Join(folder, "secrets"), "dc1", inv)
+	assert.NoError(t, err)
+	assert.Len(t, certs, 7)
+	for _, c := range certs {
+		assert.Empty(t, c.Missing, c.File)
+		assert.False(t, c.Expiring(time.Now()), c.File)
+	}
+
+	ca, err := pki.ReadCertificate(filepath.Join(consulDir, "consul-agent-ca.pem"))
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	server, err := pki.ReadCertificate(filepath.Join(consulDir, "dc1-server-consul-0.pem"))
+	assert.NoError(t, err)
+	_, err = server.Verify(x509.VerifyOptions{Roots: roots, DNSName: "server.dc1.consul"})
+	assert.NoError(t, err)
+	assertFileExists(t, filepath.Join(consulDir, "dc1-server-consul-0-key.pem"))
+}
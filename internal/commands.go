@@ -5,150 +5,362 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/OpenPaaSDev/openpaas/internal/ansible"
 	"github.com/OpenPaaSDev/openpaas/internal/conf"
 	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
 	"github.com/OpenPaaSDev/openpaas/internal/hashistack/vault"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
 	"github.com/OpenPaaSDev/openpaas/internal/o11y"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
 	"github.com/OpenPaaSDev/openpaas/internal/util"
 	"github.com/foomo/htpasswd"
+	"github.com/hashicorp/terraform-exec/tfexec"
 
 	secret "github.com/OpenPaaSDev/openpaas/internal/secrets"
 )
 
-func Bootstrap(ctx context.Context, config *conf.Config, configPath string) error {
-	publicIP, err := util.GetPublicIP(ctx)
-	if err != nil {
-		return err
+// cluster holds what the phases of a sync share. Whatever an earlier phase would have produced is loaded
+// from base_dir when that phase is skipped.
+type cluster struct {
+	config     *conf.Config
+	configPath string
+	log        *logging.Logger
+	ansible    ansible.Client
+
+	inv    *ansible.Inventory
+	sec    *secret.Config
+	consul hashistack.Consul
+}
+
+func (c *cluster) inventory() (*ansible.Inventory, error) {
+	if c.inv == nil {
+		inv, err := ansible.LoadInventory(filepath.Join(c.config.BaseDir, "inventory"))
+		if err != nil {
+			return nil, fmt.Errorf("no inventory found, run the terraform phase first: %w", err)
+		}
+		c.inv = inv
 	}
-	foundIP := false
-	for _, ip := range config.CloudProviderConfig.AllowedIPs {
-		if ip == fmt.Sprintf("%s/32", publicIP) {
-			foundIP = true
-			break
+	return c.inv, nil
+}
+
+func (c *cluster) secrets() (*secret.Config, error) {
+	if c.sec == nil {
+		sec, err := secret.Load(c.config.BaseDir)
+		if err != nil {
+			return nil, err
 		}
+		c.sec = sec
 	}
-	if !foundIP {
-		config.CloudProviderConfig.AllowedIPs = append(config.CloudProviderConfig.AllowedIPs, fmt.Sprintf("%s/32", publicIP))
+	return c.sec, nil
+}
+
+func (c *cluster) consulClient() (hashistack.Consul, error) {
+	if c.consul == nil {
+		inv, err := c.inventory()
+		if err != nil {
+			return nil, err
+		}
+		sec, err := c.secrets()
+		if err != nil {
+			return nil, err
+		}
+		c.consul, err = hashistack.NewConsulAPI(inv, sec, c.config.BaseDir, c.log)
+		if err != nil {
+			return nil, err
+		}
 	}
-	inventory := filepath.Join(config.BaseDir, "inventory")
-	dcName := config.DC
-	user := config.CloudProviderConfig.User
-	baseDir := config.BaseDir
+	return c.consul, nil
+}
 
-	err = hashistack.GenerateTerraform(config)
+// resolvedConfigFile is the config as ansible reads it, with overlays merged and references resolved.
+const resolvedConfigFile = "config.resolved.yml"
+
+// Bootstrap creates the cluster or syncs it to config, phase by phase. Completed phases are recorded in
+// base_dir, so a failed sync resumes at the phase that failed.
+func Bootstrap(ctx context.Context, config *conf.Config, opts PhaseOptions, log *logging.Logger) error {
+	c, err := newCluster(config, log.With("dc", config.DC))
 	if err != nil {
 		return err
 	}
+	return runPhases(c.log, config.BaseDir, c.configPath, c.phases(ctx), opts)
+}
 
-	tf, err := hashistack.InitTf(ctx, filepath.Join(config.BaseDir, "terraform"), os.Stdout, os.Stderr)
+// newCluster writes the resolved config for the playbooks of the cluster to run with.
+func newCluster(config *conf.Config, log *logging.Logger) (*cluster, error) {
+	baseDir := config.BaseDir
+	configPath, err := writeResolvedConfig(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	os.Remove(filepath.Join(config.BaseDir, "inventory-output.json")) //nolint
+	return &cluster{
+		config:     config,
+		configPath: configPath,
+		log:        log,
+		ansible:    ansible.NewClient(filepath.Join(baseDir, "inventory"), secret.File(baseDir), config.CloudProviderConfig.User, configPath, log),
+	}, nil
+}
 
-	err = tf.Apply(ctx, conf.LoadTFExecVars())
+// writeResolvedConfig writes config to base_dir for ansible to read, which knows nothing of overlays and
+// references, and returns its path. It may hold resolved secrets, so only the owner can read it.
+func writeResolvedConfig(config *conf.Config) (string, error) {
+	resolved, err := config.Resolved()
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	f, err := os.OpenFile(filepath.Join(config.BaseDir, "inventory-output.json"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	err = os.MkdirAll(config.BaseDir, 0750)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	defer func() {
-		e := f.Close()
-		fmt.Println(e)
-	}()
-	tf, err = hashistack.InitTf(ctx, filepath.Join(config.BaseDir, "terraform"), f, os.Stderr)
+	// the config holds the secrets its references resolve to
+	file := filepath.Join(config.BaseDir, resolvedConfigFile)
+	return file, secret.WriteFile(file, resolved, secret.KeyProviderFromEnv())
+}
+
+func (c *cluster) phases(ctx context.Context) []phase {
+	baseDir := c.config.BaseDir
+	secretsFile := filepath.Join("secrets", "secrets.yml")
+	phases := []phase{
+		{"terraform", nil, func() error { return c.provision(ctx) }},
+		{"base", []string{"inventory"}, c.base},
+		{"consul", []string{"inventory", "consul.yml", "consul", secretsFile, filepath.Join("secrets", "consul")}, func() error {
+			return c.ansible.Run(filepath.Join(baseDir, "consul.yml"))
+		}},
+		{"acl", []string{"inventory", secretsFile}, c.acl},
+	}
+	if c.config.Federation.Enabled() {
+		phases = append(phases, phase{"gateways", []string{"inventory", "gateways.yml", secretsFile}, c.gateways})
+	}
+	return append(phases, []phase{
+		{"vault", []string{"inventory", "vault.yml", "vault", secretsFile}, c.vault},
+		{"nomad", []string{"inventory", "nomad.yml", "nomad", secretsFile, filepath.Join("secrets", "nomad")}, func() error {
+			return c.nomad(ctx)
+		}},
+		{"o11y", []string{"inventory", secretsFile}, c.o11y},
+		{"backup", []string{"inventory", secretsFile}, c.scheduleBackups},
+	}...)
+}
+
+func (c *cluster) provision(ctx context.Context) error {
+	hosts, err := provision(ctx, c.config)
 	if err != nil {
 		return err
 	}
-	_, err = tf.Output(ctx)
+	c.inv, err = ansible.WriteInventory(c.config, hosts)
+	return err
+}
+
+func (c *cluster) base() error {
+	inv, err := c.inventory()
 	if err != nil {
 		return err
 	}
-
-	inv, err := ansible.GenerateInventory(config)
+	err = shareConsulCA(c.config)
 	if err != nil {
 		return err
 	}
-
-	err = Configure(inv, baseDir, dcName)
+	err = Configure(inv, c.config.BaseDir, c.config.DC, c.config.ClusterConfig.AutoUnseal, c.log)
 	if err != nil {
 		return err
 	}
-
-	secrets := secret.File(baseDir)
-
-	ansibleClient := ansible.NewClient(inventory, secrets, user, configPath)
-
-	err = ansibleClient.Run(filepath.Join(baseDir, "base.yml"))
+	err = joinPrimary(c.config)
 	if err != nil {
 		return err
 	}
-	consulSetup := filepath.Join(baseDir, "consul.yml")
-	err = ansibleClient.Run(consulSetup)
+	err = renderFederation(c.config)
 	if err != nil {
 		return err
 	}
+	return c.ansible.Run(filepath.Join(c.config.BaseDir, "base.yml"))
+}
 
-	sec, err := secret.Load(baseDir)
+func (c *cluster) acl() error {
+	inv, err := c.inventory()
 	if err != nil {
 		return err
 	}
-
-	consul := hashistack.NewConsul(inv, sec, baseDir)
-	hasBootstrapped, err := BootstrapConsul(consul, inv, sec, baseDir)
+	sec, err := c.secrets()
 	if err != nil {
 		return err
 	}
+	consul, err := c.consulClient()
+	if err != nil {
+		return err
+	}
+	var hasBootstrapped bool
+	if dc := secondaryDC(c.config); dc != "" {
+		hasBootstrapped, err = bootstrapSecondaryConsul(consul, inv, sec, c.config.BaseDir, dc, c.log)
+	} else {
+		hasBootstrapped, err = BootstrapConsul(consul, inv, sec, c.config.BaseDir, c.log)
+	}
+	if err != nil {
+		return err
+	}
+	if c.config.Federation.Enabled() {
+		err = registerFederationTokens(consul, sec, c.config)
+		if err != nil {
+			return err
+		}
+	}
 	if hasBootstrapped {
-		fmt.Println("Bootstrapped Consul ACL, re-running Ansible...")
-		err = ansibleClient.Run(consulSetup)
+		c.log.Info("bootstrapped consul ACL, re-running ansible")
+		err = c.ansible.Run(filepath.Join(c.config.BaseDir, "consul.yml"))
 		if err != nil {
 			return err
 		}
 	}
+	return htpasswd.SetPassword(filepath.Join(c.config.BaseDir, "secrets", "consul.htpasswd"),
+		"consul", sec.ConsulBootstrapToken, htpasswd.HashBCrypt)
+}
 
-	err = vault.GenerateTLS(config, inv)
+func (c *cluster) vault() error {
+	inv, err := c.inventory()
 	if err != nil {
 		return err
 	}
-
-	err = htpasswd.SetPassword(filepath.Join(config.BaseDir, "secrets", "consul.htpasswd"),
-		"consul", sec.ConsulBootstrapToken, htpasswd.HashBCrypt)
+	sec, err := c.secrets()
 	if err != nil {
 		return err
 	}
+	err = vault.GenerateTLS(c.config, inv)
+	if err != nil {
+		return err
+	}
+	err = vault.PrepareSeal(c.config.ClusterConfig.AutoUnseal, sec, os.Getenv)
+	if err != nil {
+		return err
+	}
+	err = sec.Write(c.config.BaseDir)
+	if err != nil {
+		return err
+	}
+	err = c.ansible.Run(filepath.Join(c.config.BaseDir, "vault.yml"))
+	if err != nil {
+		return err
+	}
+	return vault.Init(c.config, inv, sec, c.log)
+}
 
-	err = ansibleClient.Run(filepath.Join(baseDir, "vault.yml"))
+func (c *cluster) nomad(ctx context.Context) error {
+	inv, err := c.inventory()
 	if err != nil {
 		return err
 	}
-	err = vault.Init(config, inv, sec)
+	baseDir := c.config.BaseDir
+	err = c.ansible.Run(filepath.Join(baseDir, "nomad.yml"))
 	if err != nil {
 		return err
 	}
 
-	err = ansibleClient.Run(filepath.Join(baseDir, "nomad.yml"))
+	nomadClient := newNomadClient(baseDir, inv, c.log)
+	_, err = nomadClient.RunJob(filepath.Join(baseDir, "nomad", "web.hcl"))
 	if err != nil {
 		return err
 	}
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	_, err = nomadClient.WaitForDeployment(waitCtx, "healthcheck")
+	if err != nil {
+		return fmt.Errorf("waiting for healthcheck job to become healthy: %w", err)
+	}
+	return nil
+}
 
+// newNomadClient returns a client for the first nomad server, authenticating with the certificates in base_dir.
+func newNomadClient(baseDir string, inv *ansible.Inventory, log *logging.Logger) hashistack.NomadClient {
 	nomadSecretDir := filepath.Join(baseDir, "secrets", "nomad")
-
-	nomadClient := hashistack.NewNomadClient("",
+	return hashistack.NewNomadClient(
 		fmt.Sprintf("https://%s:4646", inv.All.Children.NomadServers.GetHosts()[0]),
 		filepath.Join(nomadSecretDir, "nomad-ca.pem"),
 		filepath.Join(nomadSecretDir, "client.pem"),
 		filepath.Join(nomadSecretDir, "client-key.pem"),
+		log,
 	)
+}
 
-	err = nomadClient.RunJob(filepath.Join(baseDir, "nomad", "web.hcl"))
+func (c *cluster) o11y() error {
+	sec, err := c.secrets()
+	if err != nil {
+		return err
+	}
+	consul, err := c.consulClient()
 	if err != nil {
 		return err
 	}
+	return o11y.Init(c.config, filepath.Join(c.config.BaseDir, "inventory"), c.configPath, sec, consul, c.ansible)
+}
+
+// provision creates the infrastructure of the cluster with terraform and returns its hosts and volumes.
+// Static providers describe existing machines, so they are returned as is.
+func provision(ctx context.Context, config *conf.Config) (*ansible.InventoryJson, error) {
+	cloud, err := provider.For(config)
+	if err != nil {
+		return nil, err
+	}
+	if static, ok := cloud.(provider.Static); ok {
+		return static.Hosts(config)
+	}
+	err = allowPublicIP(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	tfVars, err := cloud.TFVars(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = hashistack.GenerateTerraform(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := hashistack.InitTf(ctx, filepath.Join(config.BaseDir, "terraform"), hashistack.BackendEnv(config), os.Stdout, os.Stderr, hashistack.BackendInitOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(filepath.Join(config.BaseDir, "inventory-output.json")) //nolint
 
-	return o11y.Init(config, inventory, configPath, sec, consul, ansibleClient)
+	applyOpts := []tfexec.ApplyOption{}
+	for _, v := range tfVars {
+		applyOpts = append(applyOpts, v)
+	}
+	err = tf.Apply(ctx, applyOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("terraform apply: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(config.BaseDir, "inventory-output.json"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint
+	tf, err = hashistack.InitTf(ctx, filepath.Join(config.BaseDir, "terraform"), hashistack.BackendEnv(config), f, os.Stderr, hashistack.BackendInitOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tf.Output(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := os.ReadFile(filepath.Join(config.BaseDir, "inventory-output.json"))
+	if err != nil {
+		return nil, err
+	}
+	return cloud.Inventory(outputs)
+}
+
+// allowPublicIP adds the public IP of the machine running openpaas to the allowed IPs of the cluster.
+func allowPublicIP(ctx context.Context, config *conf.Config) error {
+	publicIP, err := util.GetPublicIP(ctx)
+	if err != nil {
+		return err
+	}
+	for _, ip := range config.CloudProviderConfig.AllowedIPs {
+		if ip == fmt.Sprintf("%s/32", publicIP) {
+			return nil
+		}
+	}
+	config.CloudProviderConfig.AllowedIPs = append(config.CloudProviderConfig.AllowedIPs, fmt.Sprintf("%s/32", publicIP))
+	return nil
 }
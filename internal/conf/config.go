@@ -1,115 +1,314 @@
 package conf
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
-	"github.com/hashicorp/terraform-exec/tfexec"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	DC                  string              `yaml:"dc_name"`
-	BaseDir             string              `yaml:"base_dir"`
-	OrgName             string              `yaml:"org_name"`
-	CloudProviderConfig CloudProvider       `yaml:"cloud_provider_config"`
-	ClusterConfig       ClusterConfig       `yaml:"cluster_config"`
-	ObservabilityConfig ObservabilityConfig `yaml:"observability_config"`
+	DC                  string              `yaml:"dc_name" doc:"name of the consul and nomad datacenter" schema:"required,pattern=^[a-z0-9][a-z0-9-]*$"`
+	BaseDir             string              `yaml:"base_dir" doc:"folder the generated files and secrets of the cluster are kept in" schema:"required"`
+	OrgName             string              `yaml:"org_name" doc:"name of the organisation running the cluster"`
+	CloudProviderConfig CloudProvider       `yaml:"cloud_provider_config" doc:"where the machines of the cluster run" schema:"required"`
+	ClusterConfig       ClusterConfig       `yaml:"cluster_config" doc:"size and layout of the cluster" schema:"required"`
+	ObservabilityConfig ObservabilityConfig `yaml:"observability_config" doc:"metrics, logs and traces of the cluster"`
+	TerraformState      TerraformState      `yaml:"terraform_state" doc:"where terraform keeps the state of the cluster"`
+	VaultConfig         VaultConfig         `yaml:"vault_config" doc:"how the vault master key is split into unseal key shares"`
+	Federation          Federation          `yaml:"federation" doc:"joins the consul datacenter to other openpaas datacenters over the WAN"`
+	Backup              Backup              `yaml:"backup" doc:"where openpaas backup uploads encrypted consul and nomad snapshots"`
+
+	// paths, node and files are where Load read the config from, for Validate to report problems at.
+	// files holds the overlay of the nodes that did not come from the first of paths.
+	paths []string
+	node  *yaml.Node
+	files map[*yaml.Node]string
 }
 
 type ClusterConfig struct {
-	Servers               int            `yaml:"servers"`
-	Clients               int            `yaml:"clients"`
-	ConsulVolumeSize      int            `yaml:"consul_volume_size"`
-	VaultServers          int            `yaml:"vault_servers"`
-	SeparateConsulServers bool           `yaml:"separate_consul_servers"`
-	ClientVolumes         []ClientVolume `yaml:"client_volumes"`
-	Ingress               IngressConfig  `yaml:"ingress"`
+	Servers               int            `yaml:"servers" doc:"consul and nomad servers, 3 or 5 for a quorum" schema:"enum=3|5,default=3"`
+	Clients               int            `yaml:"clients" doc:"nomad clients running the workloads" schema:"minimum=1"`
+	ConsulVolumeSize      int            `yaml:"consul_volume_size" doc:"size of the consul data volume in GB" schema:"minimum=0"`
+	VaultServers          int            `yaml:"vault_servers" doc:"vault servers" schema:"minimum=1"`
+	SeparateConsulServers bool           `yaml:"separate_consul_servers" doc:"runs consul on its own servers instead of on the nomad servers" schema:"default=false"`
+	ClientVolumes         []ClientVolume `yaml:"client_volumes" doc:"volumes attached to nomad clients"`
+	Ingress               IngressConfig  `yaml:"ingress" doc:"how the management UIs are reached" schema:"required"`
+	AutoUnseal            AutoUnseal     `yaml:"auto_unseal" doc:"lets vault unseal itself with an external key management service"`
+	Versions              Versions       `yaml:"versions" doc:"pinned versions of consul, nomad and vault, openpaas upgrade rolls them out"`
+}
+
+// Versions pins the versions of the HashiCorp packages installed on the machines, such as 1.16.2. The
+// latest version of the apt repository is installed for those not pinned.
+type Versions struct {
+	Consul string `yaml:"consul" doc:"version of consul" schema:"pattern=^[0-9]+[.][0-9]+[.][0-9]+$"`
+	Nomad  string `yaml:"nomad" doc:"version of nomad" schema:"pattern=^[0-9]+[.][0-9]+[.][0-9]+$"`
+	Vault  string `yaml:"vault" doc:"version of vault" schema:"pattern=^[0-9]+[.][0-9]+[.][0-9]+$"`
+}
+
+// AutoUnseal lets vault unseal itself on start with an external key management service. Seal is the
+// type of the vault seal stanza, such as transit, awskms or gcpckms, and Settings are its parameters.
+type AutoUnseal struct {
+	Seal     string            `yaml:"seal" doc:"type of the vault seal stanza" schema:"enum=transit|awskms|gcpckms|azurekeyvault"`
+	Settings map[string]string `yaml:"settings" doc:"parameters of the seal stanza, transit takes its token from VAULT_SEAL_TOKEN"`
 }
 
 type IngressConfig struct {
-	ManagementDomain string `yaml:"management_domain"`
+	ManagementDomain string `yaml:"management_domain" doc:"domain the consul, nomad, vault and grafana UIs are served below" schema:"required"`
 }
 
 type ClientVolume struct {
-	Name   string `yaml:"name"`
-	Client string `yaml:"client"`
-	Path   string `yaml:"path"`
-	Size   int    `yaml:"size"`
+	Name   string `yaml:"name" doc:"name of the volume, unique in the cluster" schema:"required"`
+	Client string `yaml:"client" doc:"client the volume is attached to, <base_server_name>-client-<n>" schema:"required"`
+	Path   string `yaml:"path" doc:"path the volume is linked to on the client" schema:"required"`
+	Size   int    `yaml:"size" doc:"size of the volume in GB" schema:"required,minimum=1"`
 }
 
 type CloudProvider struct {
-	User             string                 `yaml:"sudo_user"`
-	Dir              string                 `yaml:"sudo_dir"`
-	NetworkInterface string                 `yaml:"internal_network_interface_name"`
-	Provider         string                 `yaml:"provider"`
-	ProviderSettings map[string]interface{} `yaml:"provider_settings"`
-	AllowedIPs       []string               `yaml:"allowed_ips"`
+	User             string                 `yaml:"sudo_user" doc:"user ansible connects to the machines as" schema:"required"`
+	Dir              string                 `yaml:"sudo_dir" doc:"home folder of sudo_user"`
+	NetworkInterface string                 `yaml:"internal_network_interface_name" doc:"network interface of the private network of the cluster" schema:"required"`
+	Provider         string                 `yaml:"provider" doc:"cloud provider the cluster runs on" schema:"required"`
+	ProviderSettings map[string]interface{} `yaml:"provider_settings" doc:"settings of the selected cloud provider"`
+	AllowedIPs       []string               `yaml:"allowed_ips" doc:"CIDRs allowed to reach the management UIs, the IP running openpaas is added"`
 }
 
 type ObservabilityConfig struct {
-	TempoBucket   string `yaml:"tempo_bucket"`
-	LokiBucket    string `yaml:"loki_bucket"`
-	MultiInstance bool   `yaml:"multi_instance"`
+	TempoBucket   string `yaml:"tempo_bucket" doc:"bucket tempo keeps traces in"`
+	LokiBucket    string `yaml:"loki_bucket" doc:"bucket loki keeps logs in"`
+	MultiInstance bool   `yaml:"multi_instance" doc:"runs prometheus, loki, tempo and grafana on 4 servers instead of 1" schema:"default=false"`
 }
 
-type HetznerResourceNames struct {
-	BaseServerName string `yaml:"base_server_name"`
-	FirewallName   string `yaml:"firewall_name"`
-	NetworkName    string `yaml:"network_name"`
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// TerraformState configures where terraform keeps the state of the cluster. The default local backend
+// keeps it in base_dir/terraform, the s3 backend in any S3 compatible store.
+type TerraformState struct {
+	Backend   string `yaml:"backend" doc:"terraform backend" schema:"enum=local|s3,default=local"`
+	Path      string `yaml:"path" doc:"state file of the local backend, relative to base_dir/terraform" schema:"default=terraform.tfstate"`
+	Bucket    string `yaml:"bucket" doc:"bucket of the s3 backend"`
+	KeyPrefix string `yaml:"key_prefix" doc:"the state is stored at <key_prefix>/<dc_name>/terraform.tfstate"`
+	Region    string `yaml:"region" doc:"region of the bucket" schema:"default=us-east-1"`
+	Endpoint  string `yaml:"endpoint" doc:"endpoint of an S3 compatible store, which uses S3_ACCESS_KEY and S3_SECRET_KEY"`
+	LockTable string `yaml:"lock_table" doc:"DynamoDB table for state locking on AWS"`
 }
 
-type HetznerSettings struct {
-	Location                  string               `yaml:"location"`
-	SSHKeys                   []string             `yaml:"ssh_keys"`
-	ServerInstanceType        string               `yaml:"server_instance_type"`
-	ClientInstanceType        string               `yaml:"client_instance_type"`
-	ObservabilityInstanceType string               `yaml:"observability_instance_type"`
-	ResourceNames             HetznerResourceNames `yaml:"resource_names"`
+// VaultConfig sets how the vault master key is split into unseal key shares. Without PGP keys, all
+// shares are kept in the secrets. With them, every share is encrypted to the holder of one key.
+type VaultConfig struct {
+	KeyShares    int      `yaml:"key_shares" doc:"number of unseal key shares" schema:"minimum=1,default=5"`
+	KeyThreshold int      `yaml:"key_threshold" doc:"number of shares needed to unseal vault" schema:"minimum=1,default=3"`
+	PGPKeys      []string `yaml:"pgp_keys" doc:"one base64 encoded public key file per share, shares are written encrypted to secrets/vault/unseal-shares"`
 }
 
-type TFVarsConfig struct {
-	ClusterConfig  ClusterConfig
-	ProviderConfig interface{}
+// Backup configures where openpaas backup keeps the consul snapshots, which hold vault, and the nomad
+// snapshots of the cluster, encrypted with OPENPAAS_BACKUP_PASSPHRASE. Backups are stored at
+// <prefix>/<dc_name>/<timestamp>/ in bucket, of which the newest keep are kept.
+type Backup struct {
+	Bucket   string `yaml:"bucket" doc:"bucket the backups are uploaded to"`
+	Prefix   string `yaml:"prefix" doc:"backups are stored at <prefix>/<dc_name>/<timestamp>/"`
+	Region   string `yaml:"region" doc:"region of the bucket" schema:"default=us-east-1"`
+	Endpoint string `yaml:"endpoint" doc:"endpoint of an S3 compatible store, s3_endpoint of the secrets by default"`
+	Keep     int    `yaml:"keep" doc:"number of backups kept, older ones are deleted after every backup" schema:"minimum=1,default=14"`
+	Schedule string `yaml:"schedule" doc:"cron expression of a nomad periodic job backing the cluster up, such as 0 3 * * *"`
 }
 
-func Load(file string) (*Config, error) {
-	bytes, err := os.ReadFile(filepath.Clean(file))
-	if err != nil {
-		return nil, err
+// Federation joins the consul datacenter to other openpaas datacenters over the WAN, through mesh gateways
+// on the consul servers. The primary datacenter holds the consul CA and the ACLs, which secondaries share
+// and replicate, so they are synced after the primary.
+type Federation struct {
+	PrimaryDatacenter string `yaml:"primary_datacenter" doc:"dc_name of the primary datacenter, the datacenter is the primary if it is its own dc_name" schema:"pattern=^[a-z0-9][a-z0-9-]*$"`
+	PrimaryBaseDir    string `yaml:"primary_base_dir" doc:"base_dir of the primary datacenter, secondaries take its consul CA, gossip key, tokens and gateways from there"`
+}
+
+// Shares returns the number of unseal key shares, 5 when key_shares is not set.
+func (v VaultConfig) Shares() int {
+	if v.KeyShares == 0 {
+		return 5
 	}
-	var config Config
-	err = yaml.Unmarshal(bytes, &config)
-	if err != nil {
-		return nil, err
+	return v.KeyShares
+}
+
+// Threshold returns the number of shares needed to unseal vault, 3 when key_threshold is not set.
+func (v VaultConfig) Threshold() int {
+	if v.KeyThreshold == 0 {
+		return 3
 	}
-	return &config, nil
+	return v.KeyThreshold
+}
+
+// Enabled reports whether the datacenter is federated with others.
+func (f Federation) Enabled() bool {
+	return f.PrimaryDatacenter != ""
+}
+
+// Secondary reports whether dc joins the primary datacenter of the federation.
+func (f Federation) Secondary(dc string) bool {
+	return f.Enabled() && f.PrimaryDatacenter != dc
+}
+
+// Load reads the config from files. Every file after the first is an overlay merged into the ones before
+// it, so that one base config can describe several environments. ${NAME} in values is replaced with the
+// environment variable NAME and ${file:path} with the content of the file at path, relative to the config
+// file it is in.
+func Load(files ...string) (*Config, error) {
+	return load(files)
 }
 
-func LoadTFVarsConfig(config Config) (*TFVarsConfig, error) {
-	var providerConfig interface{}
-	if config.CloudProviderConfig.Provider == "hetzner" {
-		var hetznerConfig HetznerSettings
-		bytes, err := yaml.Marshal(config.CloudProviderConfig.ProviderSettings)
+// override is a mapping merged into the config after its files, reported at file.
+type override struct {
+	file string
+	node *yaml.Node
+}
+
+func load(files []string, overrides ...override) (*Config, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no config file given")
+	}
+	config := Config{paths: files, files: map[*yaml.Node]string{}}
+	for _, file := range files {
+		node, err := read(file)
 		if err != nil {
 			return nil, err
 		}
+		if len(node.Content) == 0 {
+			continue
+		}
+		if config.node == nil || len(config.node.Content) == 0 {
+			config.node = node
+			if file != files[0] {
+				record(node, file, config.files)
+			}
+			continue
+		}
+		config.node.Content[0] = merge(config.node.Content[0], node.Content[0], file, config.files)
+	}
+	for _, o := range overrides {
+		if config.node == nil || len(config.node.Content) == 0 {
+			config.node = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+		}
+		config.node.Content[0] = merge(config.node.Content[0], o.node, o.file, config.files)
+	}
+	if config.node == nil || len(config.node.Content) == 0 {
+		return &config, nil
+	}
 
-		err = yaml.Unmarshal(bytes, &hetznerConfig)
-		if err != nil {
-			return nil, err
+	err := config.node.Decode(&config)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		// values of the wrong type are reported along with every other problem of the config
+		if invalid := config.Validate(); invalid != nil {
+			return nil, invalid
 		}
-		providerConfig = hetznerConfig
 	}
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// read parses file and resolves its references.
+func read(file string) (*yaml.Node, error) {
+	bytes, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	err = yaml.Unmarshal(bytes, &node)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	err = interpolate(&node, file, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Resolved returns the config as YAML, with overlays merged and references resolved, for tools that read
+// the config file themselves.
+func (c *Config) Resolved() ([]byte, error) {
+	if c.node == nil || len(c.node.Content) == 0 {
+		return yaml.Marshal(c)
+	}
+	return yaml.Marshal(c.node)
+}
+
+// Paths returns the config file and its overlays the config was loaded from.
+func (c *Config) Paths() []string {
+	return c.paths
+}
 
-	return &TFVarsConfig{
-		ClusterConfig:  config.ClusterConfig,
-		ProviderConfig: providerConfig,
-	}, nil
+// SetClients changes cluster_config.clients to n in the config file or overlay it is set in, keeping the
+// rest of the file as it is. A value taken from a reference is not changed, as the reference would
+// resolve to the old value on the next load.
+func (c *Config) SetClients(n int) error {
+	value := c.lookup("cluster_config", "clients")
+	if value == nil {
+		return errors.New("cluster_config.clients is not set in the config")
+	}
+	file, ok := c.files[value]
+	if !ok {
+		file = c.file()
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	bytes, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(bytes), "\n")
+	if value.Line < 1 || value.Line > len(lines) {
+		return fmt.Errorf("%s: cluster_config.clients not found at line %d", file, value.Line)
+	}
+	line := lines[value.Line-1]
+	start := value.Column - 1
+	end := start
+	for end < len(line) && line[end] >= '0' && line[end] <= '9' {
+		end++
+	}
+	if value.Style != 0 || line[start:end] != value.Value {
+		return fmt.Errorf("%s:%d: cluster_config.clients is not a plain number, change it to %d there", file, value.Line, n)
+	}
+	lines[value.Line-1] = line[:start] + strconv.Itoa(n) + line[end:]
+	err = os.WriteFile(file, []byte(strings.Join(lines, "")), info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	value.Value = strconv.Itoa(n)
+	c.ClusterConfig.Clients = n
+	return nil
 }
 
-func LoadTFExecVars() *tfexec.VarOption {
-	token := os.Getenv("HETZNER_TOKEN")
-	return tfexec.Var(fmt.Sprintf("hcloud_token=%s", token))
+// lookup returns the value of the nested mapping keys in the config, or nil if it is not set.
+func (c *Config) lookup(keys ...string) *yaml.Node {
+	if c.node == nil || len(c.node.Content) == 0 {
+		return nil
+	}
+	n := c.node.Content[0]
+	for _, key := range keys {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		var value *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				value = n.Content[i+1]
+			}
+		}
+		if value == nil {
+			return nil
+		}
+		n = value
+	}
+	return n
 }
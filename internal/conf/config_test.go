@@ -2,7 +2,9 @@ package conf
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -19,6 +21,7 @@ func TestLoadConfig(t *testing.T) {
 	assert.Equal(t, "ens10", conf.CloudProviderConfig.NetworkInterface)
 	assert.Equal(t, "root", conf.CloudProviderConfig.User)
 	assert.Equal(t, "hetzner", conf.CloudProviderConfig.Provider)
+	assert.Equal(t, []string{"85.4.84.201/32"}, conf.CloudProviderConfig.AllowedIPs)
 
 	assert.Equal(t, "loki", conf.ObservabilityConfig.LokiBucket)
 	assert.Equal(t, "tempo", conf.ObservabilityConfig.TempoBucket)
@@ -29,36 +32,56 @@ func TestLoadConfig(t *testing.T) {
 	assert.Equal(t, false, conf.ClusterConfig.SeparateConsulServers)
 }
 
-func TestLoadProviderConfig(t *testing.T) {
-	conf, err := Load(filepath.Join("..", "testdata", "config.yaml"))
+func TestLoadTerraformState(t *testing.T) {
+	conf, err := Load(filepath.Join("..", "testdata", "config-aws.yaml"))
 	assert.NoError(t, err)
-	assert.NotNil(t, conf)
 
-	provider, err := LoadTFVarsConfig(*conf)
-	assert.NoError(t, err)
-	assert.NotNil(t, provider)
-	hetzner := provider.ProviderConfig.(HetznerSettings)
+	assert.Equal(t, TerraformState{
+		Backend:   BackendS3,
+		Bucket:    "openpaas-state",
+		KeyPrefix: "clusters",
+		Region:    "eu-central-1",
+		LockTable: "openpaas-locks",
+	}, conf.TerraformState)
+}
 
-	expected := HetznerSettings{
-		SSHKeys:                   []string{"wille.faler@gmail.com"},
-		ServerInstanceType:        "cx21",
-		ClientInstanceType:        "cx21",
-		ObservabilityInstanceType: "cx21",
-		Location:                  "nbg1",
-		ResourceNames: HetznerResourceNames{
-			BaseServerName: "nomad-srv",
-			FirewallName:   "dev_firewall",
-			NetworkName:    "dev_network",
-		},
-	}
+func TestSetClientsWhereTheyAreSet(t *testing.T) {
+	t.Setenv("OPENPAAS_TEST_DC", "dev")
+	t.Setenv("OPENPAAS_TEST_SERVERS", "3")
+	t.Setenv("OPENPAAS_TEST_CLIENTS", "2")
+	base := writeConfig(t, baseConfig)
+	dir := filepath.Dir(base)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "domain.txt"), []byte("example.com"), 0600))
+	prod := filepath.Join(dir, "prod.yaml")
+	assert.NoError(t, os.WriteFile(prod, []byte(prodOverlay), 0600))
 
-	assert.Equal(t, expected, hetzner)
-	assert.Equal(t, []string{"85.4.84.201/32"}, conf.CloudProviderConfig.AllowedIPs)
-}
+	config, err := Load(base)
+	assert.NoError(t, err)
+	assert.NoError(t, config.SetClients(12))
+	assert.Equal(t, 12, config.ClusterConfig.Clients)
+	reloaded, err := Load(base)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, reloaded.ClusterConfig.Clients)
+	b, err := os.ReadFile(base)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Replace(baseConfig, "clients: 2", "clients: 12", 1), string(b))
 
-func TestLoadTFExecVars(t *testing.T) {
+	config, err = Load(base, prod)
+	assert.NoError(t, err)
+	assert.NoError(t, config.SetClients(3))
+	b, err = os.ReadFile(prod)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Replace(prodOverlay, "clients: 4", "clients: 3", 1), string(b))
+	resolved, err := config.Resolved()
+	assert.NoError(t, err)
+	assert.Contains(t, string(resolved), "clients: 3")
 
-	theVar := LoadTFExecVars()
+	assert.NoError(t, os.WriteFile(prod, []byte("cluster_config:\n  clients: ${OPENPAAS_TEST_CLIENTS} # from the environment\n"), 0600))
+	config, err = Load(base, prod)
+	assert.NoError(t, err)
+	assert.EqualError(t, config.SetClients(3), prod+":2: cluster_config.clients is not a plain number, change it to 3 there")
 
-	assert.NotNil(t, theVar)
+	config, err = Load(writeConfig(t, "dc_name: dev\n"))
+	assert.NoError(t, err)
+	assert.EqualError(t, config.SetClients(3), "cluster_config.clients is not set in the config")
 }
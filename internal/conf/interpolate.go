@@ -0,0 +1,109 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// reference matches ${NAME} environment and ${file:path} file references, and $${ escapes.
+var reference = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolate replaces the references in the scalars below n, which were read from file. Files are read
+// relative to file and environment variables looked up with getenv.
+func interpolate(n *yaml.Node, file string, getenv func(string) (string, bool)) error {
+	if n.Kind != yaml.ScalarNode {
+		for _, child := range n.Content {
+			err := interpolate(child, file, getenv)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !strings.Contains(n.Value, "${") {
+		return nil
+	}
+
+	var err error
+	value := reference.ReplaceAllStringFunc(n.Value, func(match string) string {
+		if match == "$${" || err != nil {
+			return "${"
+		}
+		ref := match[2 : len(match)-1]
+		if path, ok := strings.CutPrefix(ref, "file:"); ok {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(file), path)
+			}
+			content, e := os.ReadFile(filepath.Clean(path))
+			if e != nil {
+				err = fmt.Errorf("%s:%d: %s: %w", file, n.Line, match, e)
+				return ""
+			}
+			return strings.TrimRight(string(content), "\r\n")
+		}
+		if !envName.MatchString(ref) {
+			err = fmt.Errorf("%s:%d: %s is neither ${NAME} nor ${file:path}, write $${ for a literal ${", file, n.Line, match)
+			return ""
+		}
+		env, ok := getenv(ref)
+		if !ok {
+			err = fmt.Errorf("%s:%d: environment variable %s is not set", file, n.Line, ref)
+			return ""
+		}
+		return env
+	})
+	if err != nil {
+		return err
+	}
+	n.Value = value
+	if n.Style == 0 {
+		// plain scalars are typed by their value, so that ${SERVERS} can stand for a number
+		n.Tag = ""
+	}
+	return nil
+}
+
+// merge merges overlay into base. Mappings are merged key by key, anything else in overlay replaces
+// what is in base. Nodes taken from overlay are recorded in files.
+func merge(base, overlay *yaml.Node, file string, files map[*yaml.Node]string) *yaml.Node {
+	if base.Kind != yaml.MappingNode || overlay.Kind != yaml.MappingNode {
+		record(overlay, file, files)
+		return overlay
+	}
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		found := false
+		for j := 0; j+1 < len(base.Content); j += 2 {
+			if base.Content[j].Value == key.Value {
+				base.Content[j+1] = merge(base.Content[j+1], value, file, files)
+				if base.Content[j+1] == value {
+					// replaced values are reported at the key of the overlay
+					record(key, file, files)
+					base.Content[j] = key
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			record(key, file, files)
+			record(value, file, files)
+			base.Content = append(base.Content, key, value)
+		}
+	}
+	return base
+}
+
+func record(n *yaml.Node, file string, files map[*yaml.Node]string) {
+	files[n] = file
+	for _, child := range n.Content {
+		record(child, file, files)
+	}
+}
@@ -0,0 +1,124 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+const baseConfig = `dc_name: ${OPENPAAS_TEST_DC}
+base_dir: config
+org_name: "cost-$${CENTER}"
+cluster_config:
+  servers: ${OPENPAAS_TEST_SERVERS}
+  clients: 2
+  vault_servers: 1
+  ingress:
+    management_domain: ${file:domain.txt}
+  client_volumes:
+  - name: data
+    client: srv-client-1
+    path: /opt/data
+    size: 20
+cloud_provider_config:
+  sudo_user: root
+  internal_network_interface_name: eth1
+  provider: test
+  allowed_ips:
+  - 10.0.0.1/32
+  provider_settings:
+    region: eu
+    resource_names:
+      base_server_name: srv
+`
+
+const prodOverlay = `dc_name: prod
+cluster_config:
+  clients: 4
+  client_volumes:
+  - name: logs
+    client: srv-client-4
+    path: /opt/logs
+    size: 50
+cloud_provider_config:
+  allowed_ips: []
+  provider_settings:
+    region: us
+`
+
+func TestLoadInterpolatesReferences(t *testing.T) {
+	t.Setenv("OPENPAAS_TEST_DC", "dev")
+	t.Setenv("OPENPAAS_TEST_SERVERS", "5")
+	file := writeConfig(t, baseConfig)
+	assert.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(file), "domain.txt"), []byte("dev.example.com\n"), 0600))
+
+	config, err := Load(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", config.DC)
+	assert.Equal(t, 5, config.ClusterConfig.Servers)
+	assert.Equal(t, "cost-${CENTER}", config.OrgName)
+	assert.Equal(t, "dev.example.com", config.ClusterConfig.Ingress.ManagementDomain)
+	assert.NoError(t, config.Validate())
+}
+
+func TestLoadFailsOnUnresolvedReferences(t *testing.T) {
+	file := writeConfig(t, "dc_name: dc1\nbase_dir: ${OPENPAAS_TEST_UNSET}\n")
+	_, err := Load(file)
+	assert.ErrorContains(t, err, file+":2: environment variable OPENPAAS_TEST_UNSET is not set")
+
+	file = writeConfig(t, "dc_name: ${file:missing.txt}\n")
+	_, err = Load(file)
+	assert.ErrorContains(t, err, file+":1: ${file:missing.txt}")
+
+	_, err = Load(writeConfig(t, "dc_name: ${not a name}\n"))
+	assert.ErrorContains(t, err, "is neither ${NAME} nor ${file:path}")
+}
+
+func TestLoadMergesOverlays(t *testing.T) {
+	t.Setenv("OPENPAAS_TEST_DC", "dev")
+	t.Setenv("OPENPAAS_TEST_SERVERS", "3")
+	base := writeConfig(t, baseConfig)
+	assert.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(base), "domain.txt"), []byte("example.com"), 0600))
+	prod := filepath.Join(filepath.Dir(base), "prod.yaml")
+	assert.NoError(t, os.WriteFile(prod, []byte(prodOverlay), 0600))
+
+	config, err := Load(base, prod)
+	assert.NoError(t, err)
+	assert.Equal(t, "prod", config.DC)
+	assert.Equal(t, 3, config.ClusterConfig.Servers)
+	assert.Equal(t, 4, config.ClusterConfig.Clients)
+	assert.Equal(t, []ClientVolume{{Name: "logs", Client: "srv-client-4", Path: "/opt/logs", Size: 50}}, config.ClusterConfig.ClientVolumes)
+	assert.Empty(t, config.CloudProviderConfig.AllowedIPs)
+	assert.Equal(t, "us", config.CloudProviderConfig.ProviderSettings["region"])
+	assert.Equal(t, map[string]interface{}{"base_server_name": "srv"}, config.CloudProviderConfig.ProviderSettings["resource_names"])
+	assert.NoError(t, config.Validate())
+
+	resolved, err := config.Resolved()
+	assert.NoError(t, err)
+	var reloaded Config
+	assert.NoError(t, yaml.Unmarshal(resolved, &reloaded))
+	assert.Equal(t, "prod", reloaded.DC)
+	assert.Equal(t, "example.com", reloaded.ClusterConfig.Ingress.ManagementDomain)
+	assert.Equal(t, 4, reloaded.ClusterConfig.Clients)
+}
+
+func TestValidateReportsProblemsInOverlays(t *testing.T) {
+	t.Setenv("OPENPAAS_TEST_DC", "dev")
+	t.Setenv("OPENPAAS_TEST_SERVERS", "3")
+	base := writeConfig(t, baseConfig)
+	assert.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(base), "domain.txt"), []byte("example.com"), 0600))
+	overlay := filepath.Join(filepath.Dir(base), "broken.yaml")
+	assert.NoError(t, os.WriteFile(overlay, []byte("cluster_config:\n  vault_servers: 0\n  clinets: 3\n"), 0600))
+
+	config, err := Load(base, overlay)
+	assert.NoError(t, err)
+	var invalid *ValidationError
+	assert.ErrorAs(t, config.Validate(), &invalid)
+	assert.Equal(t, []Problem{
+		{Path: "cluster_config.vault_servers", File: overlay, Line: 2, Message: "must be at least 1, got 0"},
+		{Path: "cluster_config.clinets", File: overlay, Line: 3, Message: "unknown field, did you mean clients?"},
+	}, invalid.Problems)
+}
@@ -0,0 +1,175 @@
+package conf
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Schema is a JSON Schema, as far as it is needed to describe the config. It is generated from the config
+// types, their doc tags and their schema tags, which hold comma separated constraints such as
+// `schema:"required,enum=3|5,default=3,minimum=1"`.
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// AdditionalProperties is false for objects with a fixed set of properties, or the schema of their values.
+	AdditionalProperties interface{}   `json:"additionalProperties,omitempty"`
+	Items                *Schema       `json:"items,omitempty"`
+	Enum                 []interface{} `json:"enum,omitempty"`
+	Const                interface{}   `json:"const,omitempty"`
+	Default              interface{}   `json:"default,omitempty"`
+	Minimum              *int          `json:"minimum,omitempty"`
+	Pattern              string        `json:"pattern,omitempty"`
+	AllOf                []*Schema     `json:"allOf,omitempty"`
+	If                   *Schema       `json:"if,omitempty"`
+	Then                 *Schema       `json:"then,omitempty"`
+}
+
+// serverCounts are the cluster_config fields every provider but static ones needs.
+var serverCounts = []string{"servers", "clients", "vault_servers"}
+
+// JSONSchema returns the schema of the config file, with the provider_settings of every registered provider.
+func JSONSchema() *Schema {
+	root := schemaOf(reflect.TypeOf(Config{}))
+	root.Schema = "http://json-schema.org/draft-07/schema#"
+	root.Title = "openpaas config"
+	provider := root.Properties["cloud_provider_config"].Properties["provider"]
+	for _, name := range providerNames() {
+		provider.Enum = append(provider.Enum, name)
+		settings, _ := providerSettings(name)
+		then := &Schema{Properties: map[string]*Schema{
+			"cloud_provider_config": {Properties: map[string]*Schema{"provider_settings": settingsSchema(settings)}},
+		}}
+		if !settings.Static {
+			then.Properties["cluster_config"] = &Schema{Required: serverCounts}
+		}
+		root.AllOf = append(root.AllOf, &Schema{
+			If: &Schema{Properties: map[string]*Schema{
+				"cloud_provider_config": {Properties: map[string]*Schema{"provider": {Const: name}}},
+			}},
+			Then: then,
+		})
+	}
+	return root
+}
+
+// schemaFor returns the schema of configs selecting provider, which is what Validate checks configs with.
+func schemaFor(provider string) *Schema {
+	root := schemaOf(reflect.TypeOf(Config{}))
+	root.Properties["cloud_provider_config"].Properties["provider"].Enum = nil
+	settings, ok := providerSettings(provider)
+	if !ok {
+		return root
+	}
+	root.Properties["cloud_provider_config"].Properties["provider_settings"] = settingsSchema(settings)
+	if !settings.Static {
+		cluster := root.Properties["cluster_config"]
+		cluster.Required = append(cluster.Required, serverCounts...)
+	}
+	return root
+}
+
+func settingsSchema(settings ProviderSettings) *Schema {
+	s := &Schema{Type: "object"}
+	if settings.Settings != nil {
+		s = schemaOf(reflect.TypeOf(settings.Settings))
+	}
+	s.Description = "settings of the selected cloud provider"
+	return s
+}
+
+func schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			prop := schemaOf(f.Type)
+			prop.Description = f.Tag.Get("doc")
+			if applyTag(prop, f.Type, f.Tag.Get("schema")) {
+				s.Required = append(s.Required, name)
+			}
+			s.Properties[name] = prop
+		}
+		return s
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	default:
+		return &Schema{Type: jsonType(t.Kind())}
+	}
+}
+
+// applyTag sets the constraints of a schema tag on s and reports whether the field is required.
+func applyTag(s *Schema, t reflect.Type, tag string) bool {
+	required := false
+	for _, part := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "required":
+			required = true
+		case "enum":
+			for _, v := range strings.Split(value, "|") {
+				s.Enum = append(s.Enum, parseValue(t, v))
+			}
+		case "default":
+			s.Default = parseValue(t, value)
+		case "minimum":
+			min, err := strconv.Atoi(value)
+			if err != nil {
+				panic(fmt.Sprintf("schema tag %q: %v", tag, err))
+			}
+			s.Minimum = &min
+		case "pattern":
+			s.Pattern = value
+		}
+	}
+	return required
+}
+
+func parseValue(t reflect.Type, value string) interface{} {
+	switch jsonType(t.Kind()) {
+	case "integer":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			panic(fmt.Sprintf("schema tag value %q: %v", value, err))
+		}
+		return v
+	case "boolean":
+		return value == "true"
+	default:
+		return value
+	}
+}
+
+func jsonType(k reflect.Kind) string {
+	switch k {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	default:
+		return "string"
+	}
+}
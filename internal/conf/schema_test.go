@@ -0,0 +1,50 @@
+package conf
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema := JSONSchema()
+	assert.Equal(t, "object", schema.Type)
+	assert.Equal(t, false, schema.AdditionalProperties)
+	assert.Contains(t, schema.Required, "dc_name")
+
+	cluster := schema.Properties["cluster_config"]
+	assert.Equal(t, []interface{}{3, 5}, cluster.Properties["servers"].Enum)
+	assert.Equal(t, 3, cluster.Properties["servers"].Default)
+	assert.Equal(t, "integer", cluster.Properties["servers"].Type)
+	assert.Equal(t, "consul and nomad servers, 3 or 5 for a quorum", cluster.Properties["servers"].Description)
+	assert.Equal(t, "array", cluster.Properties["client_volumes"].Type)
+	assert.Equal(t, 1, *cluster.Properties["client_volumes"].Items.Properties["size"].Minimum)
+	assert.Equal(t, &Schema{Type: "string"}, cluster.Properties["auto_unseal"].Properties["settings"].AdditionalProperties)
+
+	provider := schema.Properties["cloud_provider_config"].Properties["provider"]
+	assert.Contains(t, provider.Enum, "test")
+	var variant *Schema
+	for _, v := range schema.AllOf {
+		if v.If.Properties["cloud_provider_config"].Properties["provider"].Const == "test" {
+			variant = v.Then
+		}
+	}
+	if assert.NotNil(t, variant) {
+		settings := variant.Properties["cloud_provider_config"].Properties["provider_settings"]
+		assert.Contains(t, settings.Properties, "region")
+		assert.Equal(t, []string{"servers", "clients", "vault_servers"}, variant.Properties["cluster_config"].Required)
+	}
+
+	out, err := json.Marshal(schema)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `"$schema":"http://json-schema.org/draft-07/schema#"`)
+	assert.Contains(t, string(out), `"additionalProperties":false`)
+}
+
+func TestSchemaForUnknownProviderAcceptsAnySettings(t *testing.T) {
+	schema := schemaFor("digitalocean")
+	settings := schema.Properties["cloud_provider_config"].Properties["provider_settings"]
+	assert.Equal(t, &Schema{}, settings.AdditionalProperties)
+	assert.Equal(t, []string{"ingress"}, schema.Properties["cluster_config"].Required)
+}
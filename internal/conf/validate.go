@@ -0,0 +1,439 @@
+package conf
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is something wrong with a config, at its YAML path, such as cluster_config.client_volumes[0].client,
+// and the file and line it was found on.
+type Problem struct {
+	Path    string
+	File    string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", p.File, p.Line, p.Path, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// ValidationError is returned by Validate with every problem found in a config.
+type ValidationError struct {
+	File     string
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := []string{fmt.Sprintf("%s has %d problem(s):", e.File, len(e.Problems))}
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ProviderSettings describes the provider_settings of a cloud provider to Validate.
+type ProviderSettings struct {
+	// Settings is an empty value of the typed settings provider_settings are decoded into.
+	Settings interface{}
+	// Static providers list existing hosts in their settings, so the server counts and client
+	// volumes of cluster_config do not apply to them.
+	Static bool
+	// Check returns the problems of the provider_settings of config, with paths relative to them.
+	Check func(config *Config) []Problem
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]ProviderSettings{}
+)
+
+// RegisterProvider makes the settings of a cloud provider known to Validate.
+func RegisterProvider(name string, settings ProviderSettings) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[name] = settings
+}
+
+func providerSettings(name string) (ProviderSettings, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	settings, ok := providers[name]
+	return settings, ok
+}
+
+func providerNames() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	res := []string{}
+	for name := range providers {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+const settingsPath = "cloud_provider_config.provider_settings"
+
+// Validate checks config against the schema of its provider, the one JSONSchema exports, and then for what
+// the schema cannot express. Unknown fields, values of the wrong type and missing fields are only found
+// in configs read by Load, which also know the line of every problem.
+func (c *Config) Validate() error {
+	v := &validator{config: c, positions: map[string]position{}}
+	if c.node != nil && len(c.node.Content) > 0 {
+		v.walk(c.node.Content[0], schemaFor(c.CloudProviderConfig.Provider), "")
+	}
+	v.check(c)
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	for i, p := range v.problems {
+		if p.Line == 0 {
+			pos := v.position(p.Path)
+			v.problems[i].File, v.problems[i].Line = pos.file, pos.line
+		}
+	}
+	order := map[string]int{}
+	for i, file := range c.paths {
+		order[file] = i
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.File != b.File {
+			return order[a.File] < order[b.File]
+		}
+		return a.Line < b.Line
+	})
+	return &ValidationError{File: c.file(), Problems: v.problems}
+}
+
+type validator struct {
+	config    *Config
+	positions map[string]position
+	problems  []Problem
+}
+
+// add reports a problem at path, unless one was reported there already.
+func (v *validator) add(path, format string, args ...interface{}) {
+	for _, p := range v.problems {
+		if p.Path == path {
+			return
+		}
+	}
+	v.problems = append(v.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// position is where a path of the config is, in the config file or one of its overlays.
+type position struct {
+	file string
+	line int
+}
+
+func (v *validator) record(path string, n *yaml.Node) {
+	file, ok := v.config.files[n]
+	if !ok {
+		file = v.config.file()
+	}
+	v.positions[path] = position{file: file, line: n.Line}
+}
+
+// position returns where path is, or where its closest parent is when path is not in the config.
+func (v *validator) position(path string) position {
+	for path != "" {
+		if pos, ok := v.positions[path]; ok {
+			return pos
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return position{file: v.config.file()}
+}
+
+// file is the config file, which overlays are merged into.
+func (c *Config) file() string {
+	if len(c.paths) == 0 {
+		return "config"
+	}
+	return c.paths[0]
+}
+
+// walk records the line of every path and reports where n does not match s. Mapping values are recorded
+// at the line of their key.
+func (v *validator) walk(n *yaml.Node, s *Schema, path string) {
+	if _, ok := v.positions[path]; !ok && path != "" {
+		v.record(path, n)
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.ShortTag() == "!!null" {
+		return
+	}
+
+	switch s.Type {
+	case "":
+		// anything goes, only the lines are recorded
+		v.walkAny(n, path)
+	case "object":
+		if n.Kind != yaml.MappingNode {
+			v.add(path, "must be a mapping")
+			return
+		}
+		present := map[string]bool{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			keyPath := join(path, key.Value)
+			present[key.Value] = true
+			v.record(keyPath, key)
+			if prop, ok := s.Properties[key.Value]; ok {
+				v.walk(value, prop, keyPath)
+				continue
+			}
+			if values, ok := s.AdditionalProperties.(*Schema); ok {
+				v.walk(value, values, keyPath)
+				continue
+			}
+			v.add(keyPath, unknownField(key.Value, s.Properties))
+		}
+		for _, key := range s.Required {
+			if !present[key] {
+				v.add(join(path, key), "is required")
+			}
+		}
+	case "array":
+		if n.Kind != yaml.SequenceNode {
+			v.add(path, "must be a list")
+			return
+		}
+		for i, item := range n.Content {
+			v.walk(item, s.Items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	default:
+		v.walkScalar(n, s, path)
+	}
+}
+
+func (v *validator) walkAny(n *yaml.Node, path string) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v.record(join(path, n.Content[i].Value), n.Content[i])
+			v.walk(n.Content[i+1], &Schema{}, join(path, n.Content[i].Value))
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			v.walk(item, &Schema{}, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func (v *validator) walkScalar(n *yaml.Node, s *Schema, path string) {
+	if n.Kind != yaml.ScalarNode {
+		v.add(path, "must be a %s", typeName(s.Type))
+		return
+	}
+	var value interface{}
+	var err error
+	switch s.Type {
+	case "integer":
+		var i int
+		err = n.Decode(&i)
+		value = i
+	case "number":
+		var f float64
+		err = n.Decode(&f)
+		value = f
+	case "boolean":
+		var b bool
+		err = n.Decode(&b)
+		value = b
+	default:
+		value = n.Value
+	}
+	if err != nil {
+		v.add(path, "%q is not a %s", n.Value, typeName(s.Type))
+		return
+	}
+	if len(s.Enum) > 0 {
+		allowed := []string{}
+		for _, e := range s.Enum {
+			allowed = append(allowed, fmt.Sprint(e))
+		}
+		if !contains(allowed, fmt.Sprint(value)) {
+			v.add(path, "must be one of %s, got %s", strings.Join(allowed, ", "), n.Value)
+		}
+	}
+	if i, ok := value.(int); ok && s.Minimum != nil && i < *s.Minimum {
+		v.add(path, "must be at least %d, got %d", *s.Minimum, i)
+	}
+	if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(n.Value) {
+		v.add(path, "%q does not match %s", n.Value, s.Pattern)
+	}
+}
+
+func (v *validator) check(c *Config) {
+	name := c.CloudProviderConfig.Provider
+	settings, known := providerSettings(name)
+	switch {
+	case name == "":
+		v.add("cloud_provider_config.provider", "is required, expected one of %s", strings.Join(providerNames(), ", "))
+	case !known:
+		v.add("cloud_provider_config.provider", "%q is not a supported cloud provider, expected one of %s", name, strings.Join(providerNames(), ", "))
+	}
+	if known && settings.Check != nil {
+		for _, p := range settings.Check(c) {
+			v.add(strings.TrimSuffix(join(settingsPath, p.Path), "."), "%s", p.Message)
+		}
+	}
+	if known && settings.Static && len(c.ClusterConfig.ClientVolumes) > 0 {
+		v.add("cluster_config.client_volumes", "is not used by the %s provider, list volumes with the hosts in provider_settings", name)
+	}
+	v.checkClientVolumes(c)
+	v.checkVault(c)
+	v.checkFederation(c)
+	if c.TerraformState.Backend == BackendS3 && c.TerraformState.Bucket == "" {
+		v.add("terraform_state.bucket", "is required for the s3 backend")
+	}
+	if c.Backup.Schedule != "" && c.Backup.Bucket == "" {
+		v.add("backup.bucket", "is required for scheduled backups")
+	}
+	if c.Backup.Schedule != "" && len(strings.Fields(c.Backup.Schedule)) != 5 {
+		v.add("backup.schedule", "%q is not a cron expression of 5 fields", c.Backup.Schedule)
+	}
+}
+
+// checkClientVolumes checks that volume names are unique and that volumes are attached to clients of the
+// cluster, which the generated terraform names <base_server_name>-client-<n>.
+func (v *validator) checkClientVolumes(c *Config) {
+	clients := map[string]bool{}
+	if resources, ok := c.CloudProviderConfig.ProviderSettings["resource_names"].(map[string]interface{}); ok {
+		if base, ok := resources["base_server_name"].(string); ok && base != "" {
+			for i := 1; i <= c.ClusterConfig.Clients; i++ {
+				clients[fmt.Sprintf("%s-client-%d", base, i)] = true
+			}
+		}
+	}
+	names := map[string]bool{}
+	for i, vol := range c.ClusterConfig.ClientVolumes {
+		path := fmt.Sprintf("cluster_config.client_volumes[%d]", i)
+		if names[vol.Name] {
+			v.add(path+".name", "%q is used by another volume", vol.Name)
+		}
+		names[vol.Name] = true
+		if len(clients) > 0 && !clients[vol.Client] {
+			v.add(path+".client", "%q is not a client of the cluster, expected one of %s", vol.Client, strings.Join(sortedKeys(clients), ", "))
+		}
+	}
+}
+
+func (v *validator) checkVault(c *Config) {
+	vault := c.VaultConfig
+	shares, threshold := vault.Shares(), vault.Threshold()
+	if threshold > shares {
+		v.add("vault_config.key_threshold", "must not be more than key_shares, got %d of %d", threshold, shares)
+	}
+	if len(vault.PGPKeys) > 0 && len(vault.PGPKeys) != shares {
+		v.add("vault_config.pgp_keys", "must list one key per share, got %d keys for %d shares", len(vault.PGPKeys), shares)
+	}
+}
+
+func (v *validator) checkFederation(c *Config) {
+	fed := c.Federation
+	if fed.Secondary(c.DC) && fed.PrimaryBaseDir == "" {
+		v.add("federation.primary_base_dir", "is required for secondary datacenters, to share the consul CA and tokens of %s", fed.PrimaryDatacenter)
+	}
+	if !fed.Secondary(c.DC) && fed.PrimaryBaseDir != "" {
+		v.add("federation.primary_base_dir", "is only used by secondary datacenters, %s is the primary", c.DC)
+	}
+}
+
+// unknownField names the closest known field, which is most likely what was meant.
+func unknownField(key string, fields map[string]*Schema) string {
+	best, bestDistance := "", 3
+	for name := range fields {
+		if d := distance(key, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	if best == "" {
+		return "unknown field"
+	}
+	return fmt.Sprintf("unknown field, did you mean %s?", best)
+}
+
+// distance is the Levenshtein distance of a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func typeName(jsonType string) string {
+	switch jsonType {
+	case "integer":
+		return "whole number"
+	case "object":
+		return "mapping"
+	case "array":
+		return "list"
+	default:
+		return jsonType
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m map[string]bool) []string {
+	res := []string{}
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
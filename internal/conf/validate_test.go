@@ -0,0 +1,157 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSettings struct {
+	Region        string `yaml:"region"`
+	ResourceNames struct {
+		BaseServerName string `yaml:"base_server_name"`
+	} `yaml:"resource_names"`
+}
+
+func init() {
+	RegisterProvider("test", ProviderSettings{
+		Settings: testSettings{},
+		Check: func(config *Config) []Problem {
+			if config.CloudProviderConfig.ProviderSettings["region"] == nil {
+				return []Problem{{Path: "region", Message: "is required"}}
+			}
+			return nil
+		},
+	})
+}
+
+const invalidConfig = `dc_name: dc1
+base_dir: config
+cluster_config:
+  server: 3
+  servers: 4
+  clients: 2
+  vault_servers: 0
+  consul_volume_size: ten
+  client_volumes:
+  - name: data
+    client: srv-client-3
+    path: /opt/data
+    size: 20
+cloud_provider_config:
+  sudo_user: root
+  internal_network_interface_name: eth1
+  provider: test
+  provider_settings:
+    regoin: eu
+    resource_names:
+      base_server_name: srv
+`
+
+func TestValidateReportsEveryProblemWithItsLine(t *testing.T) {
+	file := writeConfig(t, invalidConfig)
+	_, err := Load(file)
+	var invalid *ValidationError
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, file, invalid.File)
+	assert.Equal(t, []Problem{
+		{Path: "cluster_config.ingress", File: file, Line: 3, Message: "is required"},
+		{Path: "cluster_config.server", File: file, Line: 4, Message: "unknown field, did you mean servers?"},
+		{Path: "cluster_config.servers", File: file, Line: 5, Message: "must be one of 3, 5, got 4"},
+		{Path: "cluster_config.vault_servers", File: file, Line: 7, Message: "must be at least 1, got 0"},
+		{Path: "cluster_config.consul_volume_size", File: file, Line: 8, Message: `"ten" is not a whole number`},
+		{Path: "cluster_config.client_volumes[0].client", File: file, Line: 11, Message: `"srv-client-3" is not a client of the cluster, expected one of srv-client-1, srv-client-2`},
+		{Path: "cloud_provider_config.provider_settings.region", File: file, Line: 18, Message: "is required"},
+		{Path: "cloud_provider_config.provider_settings.regoin", File: file, Line: 19, Message: "unknown field, did you mean region?"},
+	}, invalid.Problems)
+}
+
+func TestValidateAcceptsValidConfig(t *testing.T) {
+	config, err := Load(writeConfig(t, `dc_name: dc1
+base_dir: config
+cluster_config:
+  servers: 5
+  clients: 2
+  vault_servers: 1
+  ingress:
+    management_domain: example.com
+  client_volumes:
+  - name: data
+    client: srv-client-2
+    path: /opt/data
+    size: 20
+cloud_provider_config:
+  sudo_user: root
+  internal_network_interface_name: eth1
+  provider: test
+  provider_settings:
+    region: eu
+    resource_names:
+      base_server_name: srv
+`))
+	assert.NoError(t, err)
+	assert.NoError(t, config.Validate())
+}
+
+func TestValidateWithoutFile(t *testing.T) {
+	config := &Config{DC: "dc1", BaseDir: "config"}
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cloud_provider_config.provider: is required, expected one of test")
+}
+
+func writeConfig(t *testing.T, content string) string {
+	folder := util.RandString(8)
+	assert.NoError(t, os.MkdirAll(folder, 0750))
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(filepath.Clean(folder)))
+	})
+	file := filepath.Join(folder, "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestValidateFederation(t *testing.T) {
+	config := &Config{DC: "dc2", BaseDir: "config", Federation: Federation{PrimaryDatacenter: "dc1"}}
+	assert.Contains(t, config.Validate().Error(), "federation.primary_base_dir: is required for secondary datacenters")
+
+	config.Federation.PrimaryBaseDir = "../dc1"
+	assert.NotContains(t, config.Validate().Error(), "federation")
+
+	config.DC = "dc1"
+	assert.Contains(t, config.Validate().Error(), "federation.primary_base_dir: is only used by secondary datacenters, dc1 is the primary")
+}
+
+func TestValidateVersions(t *testing.T) {
+	config, err := Load(writeConfig(t, "cluster_config:\n  versions:\n    consul: 1.16.2\n    nomad: \"1.6\"\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1.16.2", config.ClusterConfig.Versions.Consul)
+	err = config.Validate()
+	assert.Contains(t, err.Error(), `cluster_config.versions.nomad: "1.6" does not match`)
+	assert.NotContains(t, err.Error(), "cluster_config.versions.consul")
+}
+
+func TestValidateBackup(t *testing.T) {
+	config, err := Load(writeConfig(t, "backup:\n  keep: 0\n  schedule: \"0 3 * *\"\n"))
+	assert.NoError(t, err)
+	err = config.Validate()
+	assert.Contains(t, err.Error(), "backup.keep: must be at least 1, got 0")
+	assert.Contains(t, err.Error(), "backup.bucket: is required for scheduled backups")
+	assert.Contains(t, err.Error(), `backup.schedule: "0 3 * *" is not a cron expression of 5 fields`)
+}
+
+func TestValidateVaultSharesDefaultTo5Of3(t *testing.T) {
+	config := &Config{VaultConfig: VaultConfig{PGPKeys: []string{"1.asc", "2.asc", "3.asc", "4.asc", "5.asc"}}}
+	assert.NotContains(t, config.Validate().Error(), "vault_config")
+
+	config.VaultConfig.PGPKeys = config.VaultConfig.PGPKeys[:4]
+	assert.Contains(t, config.Validate().Error(), "vault_config.pgp_keys: must list one key per share, got 4 keys for 5 shares")
+
+	config.VaultConfig = VaultConfig{KeyThreshold: 7}
+	assert.Contains(t, config.Validate().Error(), "vault_config.key_threshold: must not be more than key_shares, got 7 of 5")
+	config.VaultConfig = VaultConfig{KeyShares: 2}
+	assert.Contains(t, config.Validate().Error(), "vault_config.key_threshold: must not be more than key_shares, got 3 of 2")
+}
@@ -0,0 +1,168 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultWorkspaceFile is the workspace file commands look for in the working directory.
+const DefaultWorkspaceFile = "openpaas.yaml"
+
+// currentFile holds the name of the cluster commands act on, in the base_dir of the workspace.
+const currentFile = "current-cluster"
+
+var clusterName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ErrNoCluster is returned when no cluster is given and none was selected with Use.
+var ErrNoCluster = errors.New("no cluster selected")
+
+// Workspace lists the clusters operated from one folder, much like the contexts of a kubeconfig. Every
+// cluster gets its own base_dir below the base_dir of the workspace, so that their generated files and
+// secrets never mix.
+type Workspace struct {
+	// BaseDir holds the base_dir of every cluster and the selected cluster. It defaults to .openpaas.
+	BaseDir  string                      `yaml:"base_dir"`
+	Clusters map[string]WorkspaceCluster `yaml:"clusters"`
+
+	path string
+}
+
+type WorkspaceCluster struct {
+	// Config are the config file and its overlays, relative to the workspace file.
+	Config []string `yaml:"config"`
+	// BaseDir replaces the base_dir of the config. It defaults to <workspace base_dir>/<cluster>.
+	BaseDir string `yaml:"base_dir"`
+}
+
+// LoadWorkspace reads the workspace file and checks that every cluster has a config and a base_dir of
+// its own.
+func LoadWorkspace(file string) (*Workspace, error) {
+	bytes, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	w := Workspace{path: file}
+	err = yaml.Unmarshal(bytes, &w)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if w.BaseDir == "" {
+		w.BaseDir = ".openpaas"
+	}
+	w.BaseDir = w.resolve(w.BaseDir)
+	if len(w.Clusters) == 0 {
+		return nil, fmt.Errorf("%s: no clusters defined", file)
+	}
+
+	baseDirs := map[string]string{}
+	for _, name := range w.Names() {
+		if !clusterName.MatchString(name) {
+			return nil, fmt.Errorf("%s: cluster %q: names consist of lower case letters, digits and dashes", file, name)
+		}
+		if len(w.Clusters[name].Config) == 0 {
+			return nil, fmt.Errorf("%s: cluster %s: no config files", file, name)
+		}
+		dir := w.ClusterBaseDir(name)
+		if other, ok := baseDirs[dir]; ok {
+			return nil, fmt.Errorf("%s: clusters %s and %s share the base_dir %s", file, other, name, dir)
+		}
+		baseDirs[dir] = name
+	}
+	return &w, nil
+}
+
+// Names returns the names of the clusters in order.
+func (w *Workspace) Names() []string {
+	names := make([]string, 0, len(w.Clusters))
+	for name := range w.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ClusterBaseDir returns the folder the generated files and secrets of cluster are kept in.
+func (w *Workspace) ClusterBaseDir(cluster string) string {
+	if dir := w.Clusters[cluster].BaseDir; dir != "" {
+		return w.resolve(dir)
+	}
+	return filepath.Join(w.BaseDir, cluster)
+}
+
+// Current returns the cluster selected with Use, or an empty name if there is none.
+func (w *Workspace) Current() (string, error) {
+	bytes, err := os.ReadFile(filepath.Join(w.BaseDir, currentFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bytes)), nil
+}
+
+// Use selects the cluster commands act on when none is given.
+func (w *Workspace) Use(cluster string) error {
+	if _, ok := w.Clusters[cluster]; !ok {
+		return w.unknown(cluster)
+	}
+	err := os.MkdirAll(w.BaseDir, 0750)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(w.BaseDir, currentFile), []byte(cluster+"\n"), 0600)
+}
+
+// Cluster returns cluster if it is set, or the selected cluster otherwise.
+func (w *Workspace) Cluster(cluster string) (string, error) {
+	if cluster == "" {
+		current, err := w.Current()
+		if err != nil {
+			return "", err
+		}
+		if current == "" {
+			return "", fmt.Errorf("%w, run openpaas use <cluster> or pass --cluster, clusters are %s", ErrNoCluster, strings.Join(w.Names(), ", "))
+		}
+		cluster = current
+	}
+	if _, ok := w.Clusters[cluster]; !ok {
+		return "", w.unknown(cluster)
+	}
+	return cluster, nil
+}
+
+// Load loads the config of cluster, with its base_dir set to the one the workspace manages for it.
+func (w *Workspace) Load(cluster string) (*Config, error) {
+	c, ok := w.Clusters[cluster]
+	if !ok {
+		return nil, w.unknown(cluster)
+	}
+	files := make([]string, 0, len(c.Config))
+	for _, file := range c.Config {
+		files = append(files, w.resolve(file))
+	}
+	baseDir := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: "base_dir"},
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: w.ClusterBaseDir(cluster)},
+	}}
+	return load(files, override{file: w.path, node: baseDir})
+}
+
+// resolve makes path relative to the folder of the workspace file.
+func (w *Workspace) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(filepath.Dir(w.path), path)
+}
+
+func (w *Workspace) unknown(cluster string) error {
+	return fmt.Errorf("%s: unknown cluster %q, clusters are %s", w.path, cluster, strings.Join(w.Names(), ", "))
+}
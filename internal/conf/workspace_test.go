@@ -0,0 +1,91 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const workspace = `clusters:
+  dev:
+    config: [config.yaml]
+  prod:
+    config: [config.yaml, prod.yaml]
+  staging:
+    config: [config.yaml]
+    base_dir: /srv/openpaas/staging
+`
+
+func writeWorkspace(t *testing.T, content string) string {
+	t.Setenv("OPENPAAS_TEST_DC", "dev")
+	t.Setenv("OPENPAAS_TEST_SERVERS", "3")
+	base := writeConfig(t, baseConfig)
+	dir := filepath.Dir(base)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "domain.txt"), []byte("example.com"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "prod.yaml"), []byte(prodOverlay), 0600))
+	file := filepath.Join(dir, DefaultWorkspaceFile)
+	assert.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestWorkspaceLoadsClustersWithTheirOwnBaseDir(t *testing.T) {
+	file := writeWorkspace(t, workspace)
+	dir := filepath.Dir(file)
+
+	ws, err := LoadWorkspace(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dev", "prod", "staging"}, ws.Names())
+	assert.Equal(t, "/srv/openpaas/staging", ws.ClusterBaseDir("staging"))
+
+	dev, err := ws.Load("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", dev.DC)
+	assert.Equal(t, filepath.Join(dir, ".openpaas", "dev"), dev.BaseDir)
+	assert.NoError(t, dev.Validate())
+
+	prod, err := ws.Load("prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "prod", prod.DC)
+	assert.Equal(t, 4, prod.ClusterConfig.Clients)
+	assert.Equal(t, filepath.Join(dir, ".openpaas", "prod"), prod.BaseDir)
+	assert.Equal(t, []string{filepath.Join(dir, "config.yaml"), filepath.Join(dir, "prod.yaml")}, prod.Paths())
+
+	_, err = ws.Load("test")
+	assert.ErrorContains(t, err, `unknown cluster "test", clusters are dev, prod, staging`)
+}
+
+func TestWorkspaceUse(t *testing.T) {
+	ws, err := LoadWorkspace(writeWorkspace(t, workspace))
+	assert.NoError(t, err)
+
+	_, err = ws.Cluster("")
+	assert.ErrorIs(t, err, ErrNoCluster)
+	name, err := ws.Cluster("prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "prod", name)
+
+	assert.Error(t, ws.Use("test"))
+	assert.NoError(t, ws.Use("staging"))
+	current, err := ws.Current()
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", current)
+	name, err = ws.Cluster("")
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", name)
+	name, err = ws.Cluster("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", name)
+}
+
+func TestWorkspaceRejectsSharedBaseDirs(t *testing.T) {
+	_, err := LoadWorkspace(writeWorkspace(t, "base_dir: clusters\nclusters:\n  dev:\n    config: [config.yaml]\n  test:\n    config: [config.yaml]\n    base_dir: clusters/dev\n"))
+	assert.ErrorContains(t, err, "clusters dev and test share the base_dir")
+
+	_, err = LoadWorkspace(writeWorkspace(t, "clusters:\n  Prod:\n    config: [config.yaml]\n"))
+	assert.ErrorContains(t, err, `cluster "Prod"`)
+
+	_, err = LoadWorkspace(writeWorkspace(t, "clusters:\n  dev: {}\n"))
+	assert.ErrorContains(t, err, "cluster dev: no config files")
+}
@@ -9,10 +9,21 @@ import (
 
 	"github.com/OpenPaaSDev/openpaas/internal/ansible"
 	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/runtime"
 	sec "github.com/OpenPaaSDev/openpaas/internal/secrets"
 )
 
 func GenerateEnvFile(config *conf.Config, targetDir string) error {
+	return writeEnvFile(config, filepath.Join(targetDir, ".envrc"))
+}
+
+// GenerateClusterEnvFile writes the env file of a cluster of a workspace to targetDir/.envrc.<cluster>, so
+// that the env files of several clusters live side by side and .envrc can source the one in use.
+func GenerateClusterEnvFile(config *conf.Config, cluster, targetDir string) error {
+	return writeEnvFile(config, filepath.Join(targetDir, ".envrc."+cluster))
+}
+
+func writeEnvFile(config *conf.Config, envrcFile string) error {
 	secrets, err := sec.Load(config.BaseDir)
 	if err != nil {
 		return err
@@ -42,16 +53,15 @@ export NOMAD_CLIENT_CERT=%s/secrets/nomad/client.pem
 export NOMAD_CLIENT_KEY=%s/secrets/nomad/client-key.pem	
 `, consulServer, secrets.ConsulBootstrapToken, config.BaseDir, config.BaseDir, vaultServer, nomadServer, config.BaseDir, config.BaseDir, config.BaseDir)
 
-	envrcFile := filepath.Join(targetDir, ".envrc")
 	bytesRead, err := os.ReadFile(filepath.Clean(envrcFile))
 	if err == nil {
 		str := string(bytesRead)
 		parts := strings.Split(str, "### GENERATED CONFIG BELOW THIS LINE, DO NOT EDIT!")
 		if len(parts) != 2 {
-			return fmt.Errorf(".envrc file exists, but is not separated by the line\n### GENERATED CONFIG BELOW THIS LINE, DO NOT EDIT! ")
+			return fmt.Errorf("%s file exists, but is not separated by the line\n### GENERATED CONFIG BELOW THIS LINE, DO NOT EDIT! ", filepath.Base(envrcFile))
 		}
 		envFile = fmt.Sprintf("%s\n### GENERATED CONFIG BELOW THIS LINE, DO NOT EDIT!\n%s", parts[0], envFile)
 	}
-	fmt.Println(envFile)
+	fmt.Println(runtime.Redact(envFile))
 	return os.WriteFile(filepath.Join(envrcFile), []byte(envFile), 0600)
 }
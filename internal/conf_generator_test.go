@@ -52,6 +52,22 @@ func TestGenEnvRCFileDoesNotExist(t *testing.T) {
 	}()
 }
 
+func TestGenClusterEnvFile(t *testing.T) {
+	config := setUpEnvRCTest(t, false)
+	defer func() {
+		e := os.RemoveAll(filepath.Join(config.BaseDir))
+		assert.NoError(t, e)
+	}()
+
+	err := GenerateClusterEnvFile(config, "prod", config.BaseDir)
+	assert.NoError(t, err)
+
+	bytesRead, err := os.ReadFile(filepath.Clean(filepath.Join(config.BaseDir, ".envrc.prod")))
+	assert.NoError(t, err)
+	assertContainsEnv(t, config, string(bytesRead))
+	assert.NoFileExists(t, filepath.Join(config.BaseDir, ".envrc"))
+}
+
 func assertContainsEnv(t *testing.T, config *conf.Config, str string) {
 	assert.True(t, containsOneOf(func(s string) string { return fmt.Sprintf("export CONSUL_HTTP_ADDR=https://%s:8501", s) }, str))
 	assert.Contains(t, str, "export CONSUL_HTTP_TOKEN=BootstrapToken")
@@ -6,24 +6,47 @@ import (
 
 	"github.com/OpenPaaSDev/openpaas/internal/ansible"
 	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
 	"github.com/OpenPaaSDev/openpaas/internal/secrets"
 )
 
-func regenerateConsulPolicies(consul hashistack.Consul, inventory *ansible.Inventory, baseDir string) error {
+func regenerateConsulPolicies(consul hashistack.Consul, inventory *ansible.Inventory, baseDir, policy string, log *logging.Logger) error {
 	err := makeConsulPolicies(inventory, baseDir)
 	if err != nil {
 		return err
 	}
-	fmt.Println("Updating consul policies")
+	log.Info("updating consul policies", "policy", policy)
 	policyConsul := filepath.Join(baseDir, "consul", "consul-policies.hcl")
 
-	return consul.UpdatePolicy("consul-policies", policyConsul)
+	return consul.UpdatePolicy(policy, policyConsul)
 }
 
-func BootstrapConsul(consul hashistack.Consul, inventory *ansible.Inventory, sec *secrets.Config, baseDir string) (bool, error) {
+// consulPolicyFiles maps the name of every ACL policy openpaas manages to its rules file in baseDir.
+func consulPolicyFiles(baseDir string) map[string]string {
+	return map[string]string{
+		"consul-policies":    filepath.Join(baseDir, "consul", "consul-policies.hcl"),
+		"nomad-client":       filepath.Join(baseDir, "consul", "nomad-client-policy.hcl"),
+		"fabio":              filepath.Join(baseDir, "consul", "fabio-policy.hcl"),
+		"nomad-server":       filepath.Join(baseDir, "consul", "nomad-server-policy.hcl"),
+		"prometheus":         filepath.Join(baseDir, "consul", "prometheus-policy.hcl"),
+		"anonymous-dns-read": filepath.Join(baseDir, "consul", "anonymous-policy.hcl"),
+		"vault":              filepath.Join(baseDir, "consul", "vault-policy.hcl"),
+	}
+}
+
+// nodePolicy returns the name of the policy that lets the agents of datacenter dc write their nodes. The
+// primary datacenter, or a cluster that is not federated, passes no dc.
+func nodePolicy(dc string) string {
+	if dc == "" {
+		return "consul-policies"
+	}
+	return "consul-policies-" + dc
+}
+
+func BootstrapConsul(consul hashistack.Consul, inventory *ansible.Inventory, sec *secrets.Config, baseDir string, log *logging.Logger) (bool, error) {
 
 	if sec.ConsulBootstrapToken != "TBD" {
-		err := regenerateConsulPolicies(consul, inventory, baseDir)
+		err := regenerateConsulPolicies(consul, inventory, baseDir, nodePolicy(""), log)
 		return false, err
 	}
 	token, err := consul.Bootstrap()
@@ -33,26 +56,33 @@ func BootstrapConsul(consul hashistack.Consul, inventory *ansible.Inventory, sec
 
 	sec.ConsulBootstrapToken = token
 
-	policies := map[string]string{
-		"consul-policies":    filepath.Join(baseDir, "consul", "consul-policies.hcl"),
-		"nomad-client":       filepath.Join(baseDir, "consul", "nomad-client-policy.hcl"),
-		"fabio":              filepath.Join(baseDir, "consul", "fabio-policy.hcl"),
-		"nomad-server":       filepath.Join(baseDir, "consul", "nomad-server-policy.hcl"),
-		"prometheus":         filepath.Join(baseDir, "consul", "prometheus-policy.hcl"),
-		"anonymous-dns-read": filepath.Join(baseDir, "consul", "anonymous-policy.hcl"),
-		"vault":              filepath.Join(baseDir, "consul", "vault-policy.hcl"),
+	err = registerConsulACL(consul, sec, baseDir, "")
+	if err != nil {
+		return false, err
 	}
 
-	for k, v := range policies {
-		err = consul.RegisterPolicy(k, v)
+	err = sec.Write(baseDir)
+
+	return true, err
+}
+
+// registerConsulACL registers the policies and tokens of the cluster and keeps the tokens in sec. Secondary
+// datacenters of a federation share the ACLs of the primary, so they pass their dc to register a node
+// policy and tokens of their own.
+func registerConsulACL(consul hashistack.Consul, sec *secrets.Config, baseDir, dc string) error {
+	for k, v := range consulPolicyFiles(baseDir) {
+		if k == nodePolicy("") {
+			k = nodePolicy(dc)
+		}
+		err := consul.RegisterPolicy(k, v)
 		if err != nil {
-			return false, err
+			return err
 		}
 	}
 
-	err = consul.UpdateACL("anonymous", "anonymous-dns-read")
+	err := consul.UpdateACL("anonymous", "anonymous-dns-read")
 	if err != nil {
-		return false, err
+		return err
 	}
 
 	acls := map[string]string{
@@ -66,9 +96,16 @@ func BootstrapConsul(consul hashistack.Consul, inventory *ansible.Inventory, sec
 	tokens := map[string]string{}
 
 	for k, v := range acls {
-		clientToken, e := consul.RegisterACL(k, v)
+		description, policy := k, v
+		if dc != "" {
+			description = fmt.Sprintf("%s %s", k, dc)
+		}
+		if policy == nodePolicy("") {
+			policy = nodePolicy(dc)
+		}
+		clientToken, e := consul.RegisterACL(description, policy)
 		if e != nil {
-			return false, e
+			return e
 		}
 		tokens[v] = clientToken
 	}
@@ -79,8 +116,5 @@ func BootstrapConsul(consul hashistack.Consul, inventory *ansible.Inventory, sec
 	sec.PrometheusConsulToken = tokens["prometheus"]
 	sec.FabioConsulToken = tokens["fabio"]
 	sec.VaultConsulToken = tokens["vault"]
-
-	err = sec.Write(baseDir)
-
-	return true, err
+	return nil
 }
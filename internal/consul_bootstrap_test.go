@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
 	"github.com/OpenPaaSDev/openpaas/internal/secrets"
 	sec "github.com/OpenPaaSDev/openpaas/internal/secrets"
 	"github.com/OpenPaaSDev/openpaas/internal/util"
@@ -38,7 +39,7 @@ func TestBootstrapConsul(t *testing.T) {
 	assert.NoError(t, err)
 	secrets, err := sec.Load(folder)
 	assert.NoError(t, err)
-	b, err := BootstrapConsul(consul, inv, secrets, folder)
+	b, err := BootstrapConsul(consul, inv, secrets, folder, logging.Discard())
 	assert.NoError(t, err)
 	assert.True(t, b)
 	assert.Equal(t, 7, len(consul.RegisterPolicyCalls()))
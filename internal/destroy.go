@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+// ErrNotConfirmed is returned by Destroy and Restore when the name of the datacenter was not confirmed.
+var ErrNotConfirmed = errors.New("not confirmed")
+
+// generatedArtifacts are the files and folders in base_dir that sync generates for a cluster. Anything else
+// in base_dir, such as snapshots, is left alone by Destroy.
+var generatedArtifacts = []string{
+	"inventory", "inventory-output.json", stateFile, resolvedConfigFile, resolvedConfigFile + secrets.EncryptedSuffix, "terraform", "secrets",
+	"consul", "nomad", "vault", "prometheus", "loki", "grafana", "intentions", "tempo",
+	"base.yml", "consul.yml", "gateways.yml", "nomad.yml", "vault.yml", "observability.yml", "leave.yml",
+	"upgrade-consul.yml", "upgrade-nomad.yml", "upgrade-vault.yml", "certs-consul.yml", "certs-nomad.yml", "certs-vault.yml",
+}
+
+type DestroyOptions struct {
+	// Confirm is the name of the datacenter to destroy. It is asked for when it does not match.
+	Confirm string
+	// Snapshot saves the consul state, which holds vault, and the nomad state to base_dir/snapshots first.
+	Snapshot bool
+	// Purge deletes the generated files of base_dir instead of archiving them to base_dir/destroyed.
+	Purge bool
+}
+
+// Destroy tears down the infrastructure of the cluster with terraform and then archives or purges what
+// sync generated in base_dir. The machines of static providers are left untouched.
+func Destroy(ctx context.Context, config *conf.Config, opts DestroyOptions, in io.Reader, out io.Writer, log *logging.Logger) error {
+	log = log.With("dc", config.DC)
+	err := confirmDestroy(config.DC, opts.Confirm, in, out)
+	if err != nil {
+		return err
+	}
+
+	if opts.Snapshot {
+		done := log.Phase("snapshot")
+		err = snapshotCluster(config.BaseDir, config.DC, log)
+		done(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	done := log.Phase("terraform")
+	err = destroyInfrastructure(ctx, config, log)
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	if opts.Purge {
+		return purgeArtifacts(config.BaseDir)
+	}
+	dir, err := archiveArtifacts(config.BaseDir, config.DC, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Info("archived generated files", "dir", dir)
+	return nil
+}
+
+// confirmDestroy asks for the name of the datacenter on in, unless confirmed already matches it.
+func confirmDestroy(dc, confirmed string, in io.Reader, out io.Writer) error {
+	return confirmDatacenter(dc, confirmed, fmt.Sprintf("This destroys every machine and all data of datacenter %s.", dc), in, out)
+}
+
+// confirmDatacenter shows warning and asks for the name of the datacenter on in, unless confirmed already
+// matches it.
+func confirmDatacenter(dc, confirmed, warning string, in io.Reader, out io.Writer) error {
+	if confirmed == "" {
+		fmt.Fprintf(out, "%s\nType the name of the datacenter to confirm: ", warning) //nolint
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		confirmed = strings.TrimSpace(line)
+	}
+	if confirmed != dc {
+		return fmt.Errorf("%w: %q does not match datacenter %s", ErrNotConfirmed, confirmed, dc)
+	}
+	return nil
+}
+
+func snapshotCluster(baseDir, dc string, log *logging.Logger) error {
+	_, consul, nomad, err := clusterClients(baseDir, log)
+	if err != nil {
+		return err
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for name, save := range map[string]func(io.Writer) error{"consul": consul.Snapshot, "nomad": nomad.Snapshot} {
+		file, err := saveSnapshot(filepath.Join(baseDir, "snapshots"), fmt.Sprintf("%s-%s-%s.snap", dc, name, stamp), save)
+		if err != nil {
+			return fmt.Errorf("%s snapshot: %w", name, err)
+		}
+		log.Info("saved snapshot", "file", file)
+	}
+	return nil
+}
+
+// saveSnapshot writes the snapshot of save to dir/name. Partial snapshots are removed.
+func saveSnapshot(dir, name string, save func(io.Writer) error) (string, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, name)
+	f, err := os.OpenFile(filepath.Clean(file), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = save(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file) //nolint
+		return "", err
+	}
+	return file, nil
+}
+
+func destroyInfrastructure(ctx context.Context, config *conf.Config, log *logging.Logger) error {
+	cloud, err := provider.For(config)
+	if err != nil {
+		return err
+	}
+	if _, ok := cloud.(provider.Static); ok {
+		log.Warn("static provider, the machines of the cluster are left untouched")
+		return nil
+	}
+	tfVars, err := cloud.TFVars(os.Getenv)
+	if err != nil {
+		return err
+	}
+	err = hashistack.GenerateTerraform(config)
+	if err != nil {
+		return err
+	}
+	tf, err := hashistack.InitTf(ctx, filepath.Join(config.BaseDir, "terraform"), hashistack.BackendEnv(config), os.Stdout, os.Stderr, hashistack.BackendInitOptions()...)
+	if err != nil {
+		return err
+	}
+	destroyOpts := []tfexec.DestroyOption{}
+	for _, v := range tfVars {
+		destroyOpts = append(destroyOpts, v)
+	}
+	err = tf.Destroy(ctx, destroyOpts...)
+	if err != nil {
+		return fmt.Errorf("terraform destroy: %w", err)
+	}
+	return nil
+}
+
+// archiveArtifacts moves the generated files of base_dir to base_dir/destroyed/<dc>-<timestamp> and
+// returns that folder.
+func archiveArtifacts(baseDir, dc string, now time.Time) (string, error) {
+	dir := filepath.Join(baseDir, "destroyed", fmt.Sprintf("%s-%s", dc, now.UTC().Format("20060102T150405Z")))
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range generatedArtifacts {
+		err = os.Rename(filepath.Join(baseDir, name), filepath.Join(dir, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func purgeArtifacts(baseDir string) error {
+	for _, name := range generatedArtifacts {
+		err := os.RemoveAll(filepath.Join(baseDir, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
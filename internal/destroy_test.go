@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirmDestroy(t *testing.T) {
+	var out strings.Builder
+	assert.NoError(t, confirmDestroy("dc1", "dc1", strings.NewReader(""), &out))
+	assert.Empty(t, out.String())
+
+	assert.NoError(t, confirmDestroy("dc1", "", strings.NewReader("dc1\n"), &out))
+	assert.Contains(t, out.String(), "datacenter dc1")
+
+	err := confirmDestroy("dc1", "", strings.NewReader("dc2\n"), &out)
+	assert.ErrorIs(t, err, ErrNotConfirmed)
+	err = confirmDestroy("dc1", "", strings.NewReader(""), &out)
+	assert.ErrorIs(t, err, ErrNotConfirmed)
+}
+
+func TestDestroyArchivesArtifacts(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		e := os.RemoveAll(filepath.Clean(folder))
+		assert.NoError(t, e)
+	}()
+	config, err := conf.Load(filepath.Join("testdata", "config-static.yaml"))
+	assert.NoError(t, err)
+	config.BaseDir = folder
+	for _, dir := range []string{filepath.Join("secrets", "vault"), "snapshots"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(folder, dir), 0750))
+	}
+	for _, file := range []string{"inventory", "consul.yml", filepath.Join("secrets", "vault", "init.txt")} {
+		assert.NoError(t, os.WriteFile(filepath.Join(folder, file), []byte(file), 0600))
+	}
+
+	err = Destroy(context.Background(), config, DestroyOptions{Confirm: "dc1"}, strings.NewReader(""), io.Discard, nil)
+	assert.ErrorIs(t, err, ErrNotConfirmed)
+	assert.FileExists(t, filepath.Join(folder, "inventory"))
+
+	err = Destroy(context.Background(), config, DestroyOptions{Confirm: "onprem"}, strings.NewReader(""), io.Discard, nil)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(folder, "inventory"))
+	assert.NoDirExists(t, filepath.Join(folder, "secrets"))
+	assert.DirExists(t, filepath.Join(folder, "snapshots"))
+	archived, err := filepath.Glob(filepath.Join(folder, "destroyed", "onprem-*", "secrets", "vault", "init.txt"))
+	assert.NoError(t, err)
+	assert.Len(t, archived, 1)
+}
+
+func TestDestroyPurgesArtifacts(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		e := os.RemoveAll(filepath.Clean(folder))
+		assert.NoError(t, e)
+	}()
+	config, err := conf.Load(filepath.Join("testdata", "config-static.yaml"))
+	assert.NoError(t, err)
+	config.BaseDir = folder
+	assert.NoError(t, os.MkdirAll(filepath.Join(folder, "secrets"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(folder, "inventory"), []byte("inventory"), 0600))
+
+	err = Destroy(context.Background(), config, DestroyOptions{Confirm: "onprem", Purge: true}, strings.NewReader(""), io.Discard, nil)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(folder, "inventory"))
+	assert.NoDirExists(t, filepath.Join(folder, "secrets"))
+	assert.NoDirExists(t, filepath.Join(folder, "destroyed"))
+}
+
+func TestSaveSnapshotRemovesPartialSnapshot(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		e := os.RemoveAll(filepath.Clean(folder))
+		assert.NoError(t, e)
+	}()
+
+	file, err := saveSnapshot(folder, "dc1-consul.snap", func(w io.Writer) error {
+		_, err := w.Write([]byte("snapshot"))
+		return err
+	})
+	assert.NoError(t, err)
+	content, err := os.ReadFile(filepath.Clean(file))
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot", string(content))
+
+	_, err = saveSnapshot(folder, "dc1-nomad.snap", func(w io.Writer) error {
+		_, _ = w.Write([]byte("snap"))
+		return errors.New("connection reset")
+	})
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(folder, "dc1-nomad.snap"))
+}
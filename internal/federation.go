@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	sec "github.com/OpenPaaSDev/openpaas/internal/secrets"
+)
+
+//go:embed templates/consul/federation.hcl
+var consulFederation string
+
+//go:embed templates/consul/replication-policy.hcl
+var replicationPolicy string
+
+//go:embed templates/consul/mesh-gateway-policy.hcl
+var meshGatewayPolicy string
+
+//go:embed templates/consul/proxy-defaults.hcl
+var proxyDefaults string
+
+//go:embed templates/consul/mesh-gateway.service
+var meshGatewayService string
+
+//go:embed templates/consul/install-envoy.sh
+var installEnvoy string
+
+//go:embed templates/ansible/gateways.yml
+var gatewaysAnsible string
+
+// meshGatewayPort is the port the mesh gateways of federated datacenters listen on.
+const meshGatewayPort = 8443
+
+// sharedConsulCA are the files of the consul CA in secrets/consul, which secondaries share with the primary.
+var sharedConsulCA = []string{"consul-agent-ca.pem", "consul-agent-ca-key.pem"}
+
+// secondaryDC returns the datacenter of config if it is a secondary of a federation, and nothing otherwise.
+func secondaryDC(config *conf.Config) string {
+	if config.Federation.Secondary(config.DC) {
+		return config.DC
+	}
+	return ""
+}
+
+// shareConsulCA copies the consul CA of the primary datacenter to a secondary, before its secrets are
+// generated, so that the agents of both trust each other.
+func shareConsulCA(config *conf.Config) error {
+	if secondaryDC(config) == "" {
+		return nil
+	}
+	consulSecretDir := filepath.Join(config.BaseDir, "secrets", "consul")
+	err := os.MkdirAll(consulSecretDir, 0750)
+	if err != nil {
+		return err
+	}
+	for _, name := range sharedConsulCA {
+		dest := filepath.Join(consulSecretDir, name)
+		if _, err := os.Stat(dest); err == nil {
+			continue
+		}
+		bytes, err := os.ReadFile(filepath.Clean(filepath.Join(config.Federation.PrimaryBaseDir, "secrets", "consul", name)))
+		if err != nil {
+			return fmt.Errorf("consul CA of primary datacenter %s not found, sync it first: %w", config.Federation.PrimaryDatacenter, err)
+		}
+		err = os.WriteFile(dest, bytes, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinPrimary takes the gossip key, the bootstrap token and the replication token of the primary datacenter
+// into the secrets of a secondary, as the consul servers of a federation gossip and replicate ACLs
+// across datacenters.
+func joinPrimary(config *conf.Config) error {
+	if secondaryDC(config) == "" {
+		return nil
+	}
+	primary, err := sec.Load(config.Federation.PrimaryBaseDir)
+	if err != nil {
+		return fmt.Errorf("secrets of primary datacenter %s: %w", config.Federation.PrimaryDatacenter, err)
+	}
+	if primary.ConsulReplicationToken == "" {
+		return fmt.Errorf("primary datacenter %s has no replication token, sync it with federation enabled first", config.Federation.PrimaryDatacenter)
+	}
+	secrets, err := sec.Load(config.BaseDir)
+	if err != nil {
+		return err
+	}
+	if secrets.ConsulGossipKey == primary.ConsulGossipKey &&
+		secrets.ConsulBootstrapToken == primary.ConsulBootstrapToken &&
+		secrets.ConsulReplicationToken == primary.ConsulReplicationToken {
+		return nil
+	}
+	secrets.ConsulGossipKey = primary.ConsulGossipKey
+	secrets.ConsulBootstrapToken = primary.ConsulBootstrapToken
+	secrets.ConsulReplicationToken = primary.ConsulReplicationToken
+	return secrets.Write(config.BaseDir)
+}
+
+// renderFederation writes the federation settings of the consul servers to consul/federation.j2, which
+// only holds a comment when the datacenter is not federated, and the playbook of the mesh gateways.
+func renderFederation(config *conf.Config) error {
+	baseDir := config.BaseDir
+	fed := config.Federation
+	gateways := []string{}
+	if fed.Secondary(config.DC) {
+		var err error
+		gateways, err = primaryGateways(fed.PrimaryBaseDir)
+		if err != nil {
+			return fmt.Errorf("gateways of primary datacenter %s: %w", fed.PrimaryDatacenter, err)
+		}
+	}
+
+	tmpl, err := template.New("federation").Delims("[[", "]]").Parse(consulFederation)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]interface{}{
+		"Primary":   fed.PrimaryDatacenter,
+		"Secondary": fed.Secondary(config.DC),
+		"Gateways":  strings.Join(gateways, ", "),
+	})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Join(baseDir, "consul"), 0750)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(baseDir, "consul", "federation.j2"), buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+
+	if !fed.Enabled() {
+		err = os.Remove(filepath.Join(baseDir, "gateways.yml"))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	toWrite := map[string]string{
+		filepath.Join(baseDir, "gateways.yml"):                      gatewaysAnsible,
+		filepath.Join(baseDir, "consul", "mesh-gateway.service"):    meshGatewayService,
+		filepath.Join(baseDir, "consul", "install-envoy.sh"):        installEnvoy,
+		filepath.Join(baseDir, "consul", "proxy-defaults.hcl"):      proxyDefaults,
+		filepath.Join(baseDir, "consul", "replication-policy.hcl"):  replicationPolicy,
+		filepath.Join(baseDir, "consul", "mesh-gateway-policy.hcl"): meshGatewayPolicy,
+	}
+	for k, v := range toWrite {
+		err = os.WriteFile(k, []byte(v), 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// primaryGateways returns the WAN addresses of the mesh gateways of the primary datacenter, which run on
+// its consul servers.
+func primaryGateways(primaryBaseDir string) ([]string, error) {
+	inv, err := ansible.LoadInventory(filepath.Join(primaryBaseDir, "inventory"))
+	if err != nil {
+		return nil, err
+	}
+	hosts := inv.All.Children.ConsulServers.GetHosts()
+	if len(hosts) == 0 {
+		return nil, errors.New("no consul servers in the inventory")
+	}
+	sort.Strings(hosts)
+	gateways := []string{}
+	for _, host := range hosts {
+		gateways = append(gateways, fmt.Sprintf(`"%s:%d"`, host, meshGatewayPort))
+	}
+	return gateways, nil
+}
+
+// bootstrapSecondaryConsul registers the policies and tokens of a secondary datacenter, which can't
+// bootstrap ACLs of its own but uses the bootstrap token of the primary it replicates them from.
+func bootstrapSecondaryConsul(consul hashistack.Consul, inventory *ansible.Inventory, secrets *sec.Config, baseDir, dc string, log *logging.Logger) (bool, error) {
+	if secrets.ConsulAgentToken != "TBD" {
+		err := regenerateConsulPolicies(consul, inventory, baseDir, nodePolicy(dc), log)
+		return false, err
+	}
+	err := registerConsulACL(consul, secrets, baseDir, dc)
+	if err != nil {
+		return false, err
+	}
+	return true, secrets.Write(baseDir)
+}
+
+// registerFederationTokens creates the replication token in the primary datacenter, which secondaries
+// take from its secrets, and the token of the mesh gateways in every datacenter.
+func registerFederationTokens(consul hashistack.Consul, secrets *sec.Config, config *conf.Config) error {
+	baseDir := config.BaseDir
+	dc := secondaryDC(config)
+	changed := false
+	if dc == "" && secrets.ConsulReplicationToken == "" {
+		err := consul.RegisterPolicy("replication", filepath.Join(baseDir, "consul", "replication-policy.hcl"))
+		if err != nil {
+			return err
+		}
+		secrets.ConsulReplicationToken, err = consul.RegisterACL("replication token", "replication")
+		if err != nil {
+			return err
+		}
+		changed = true
+	}
+	if secrets.ConsulMeshGatewayToken == "" {
+		err := consul.RegisterPolicy("mesh-gateway", filepath.Join(baseDir, "consul", "mesh-gateway-policy.hcl"))
+		if err != nil {
+			return err
+		}
+		description := "mesh gateway token"
+		if dc != "" {
+			description = fmt.Sprintf("%s %s", description, dc)
+		}
+		secrets.ConsulMeshGatewayToken, err = consul.RegisterACL(description, "mesh-gateway")
+		if err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return secrets.Write(baseDir)
+}
+
+// gateways starts the mesh gateways of a federated datacenter and routes service mesh traffic to other
+// datacenters through them.
+func (c *cluster) gateways() error {
+	consul, err := c.consulClient()
+	if err != nil {
+		return err
+	}
+	err = c.ansible.Run(filepath.Join(c.config.BaseDir, "gateways.yml"))
+	if err != nil {
+		return err
+	}
+	return consul.RegisterIntention(filepath.Join(c.config.BaseDir, "consul", "proxy-defaults.hcl"))
+}
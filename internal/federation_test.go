@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	sec "github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// setUpFederation creates the base_dirs of a synced primary datacenter dc1 and a secondary dc2.
+func setUpFederation(t *testing.T) (*conf.Config, *conf.Config) {
+	folder := util.RandString(8)
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(folder))
+	})
+	primary := &conf.Config{DC: "dc1", BaseDir: filepath.Join(folder, "dc1"), Federation: conf.Federation{PrimaryDatacenter: "dc1"}}
+	secondary := &conf.Config{DC: "dc2", BaseDir: filepath.Join(folder, "dc2"),
+		Federation: conf.Federation{PrimaryDatacenter: "dc1", PrimaryBaseDir: primary.BaseDir}}
+
+	for _, dir := range []string{primary.BaseDir, secondary.BaseDir} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "secrets", "consul"), 0750))
+		mkSecrets(t, dir)
+	}
+	copyTestFile(t, filepath.Join("testdata", "inventory"), filepath.Join(primary.BaseDir, "inventory"))
+	for _, name := range sharedConsulCA {
+		assert.NoError(t, os.WriteFile(filepath.Join(primary.BaseDir, "secrets", "consul", name), []byte(name), 0600))
+	}
+	return primary, secondary
+}
+
+func TestRenderFederation(t *testing.T) {
+	primary, secondary := setUpFederation(t)
+
+	assert.NoError(t, renderFederation(primary))
+	b, err := os.ReadFile(filepath.Join(primary.BaseDir, "consul", "federation.j2"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `primary_datacenter = "dc1"`)
+	assert.Contains(t, string(b), "enable_mesh_gateway_wan_federation = true")
+	assert.NotContains(t, string(b), "primary_gateways")
+	assert.NotContains(t, string(b), "replication")
+	assertFileExists(t, filepath.Join(primary.BaseDir, "gateways.yml"))
+
+	assert.NoError(t, renderFederation(secondary))
+	b, err = os.ReadFile(filepath.Join(secondary.BaseDir, "consul", "federation.j2"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `primary_datacenter = "dc1"`)
+	assert.Contains(t, string(b), `primary_gateways = [ "127.0.0.1:8443", "127.0.0.2:8443", "127.0.0.3:8443" ]`)
+	assert.Contains(t, string(b), `replication = "{{ CONSUL_REPLICATION_TOKEN }}"`)
+
+	primary.Federation = conf.Federation{}
+	assert.NoError(t, renderFederation(primary))
+	b, err = os.ReadFile(filepath.Join(primary.BaseDir, "consul", "federation.j2"))
+	assert.NoError(t, err)
+	assert.Equal(t, "# the datacenter is not federated\n", string(b))
+	assert.NoFileExists(t, filepath.Join(primary.BaseDir, "gateways.yml"))
+}
+
+func TestSecondaryJoinsPrimary(t *testing.T) {
+	primary, secondary := setUpFederation(t)
+
+	assert.NoError(t, shareConsulCA(secondary))
+	for _, name := range sharedConsulCA {
+		b, err := os.ReadFile(filepath.Join(secondary.BaseDir, "secrets", "consul", name))
+		assert.NoError(t, err)
+		assert.Equal(t, name, string(b))
+	}
+
+	assert.ErrorContains(t, joinPrimary(secondary), "primary datacenter dc1 has no replication token")
+
+	primarySecrets, err := sec.Load(primary.BaseDir)
+	assert.NoError(t, err)
+	primarySecrets.ConsulGossipKey = "primaryGossipKey"
+	primarySecrets.ConsulBootstrapToken = "primary-bootstrap-token"
+	primarySecrets.ConsulReplicationToken = "replication-token"
+	assert.NoError(t, primarySecrets.Write(primary.BaseDir))
+
+	assert.NoError(t, joinPrimary(secondary))
+	secrets, err := sec.Load(secondary.BaseDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "primaryGossipKey", secrets.ConsulGossipKey)
+	assert.Equal(t, "primary-bootstrap-token", secrets.ConsulBootstrapToken)
+	assert.Equal(t, "replication-token", secrets.ConsulReplicationToken)
+	assert.Equal(t, "TBD", secrets.ConsulAgentToken)
+}
+
+func TestBootstrapSecondaryConsul(t *testing.T) {
+	_, secondary := setUpFederation(t)
+	assert.NoError(t, makeConsulPolicies(mustInventory(t), secondary.BaseDir))
+	assert.NoError(t, renderFederation(secondary))
+
+	consul := &MockConsul{
+		RegisterACLFunc: func(description, policy string) (string, error) {
+			return description, nil
+		},
+	}
+	secrets, err := sec.Load(secondary.BaseDir)
+	assert.NoError(t, err)
+	b, err := bootstrapSecondaryConsul(consul, mustInventory(t), secrets, secondary.BaseDir, "dc2", logging.Discard())
+	assert.NoError(t, err)
+	assert.True(t, b)
+	assert.Empty(t, consul.BootstrapCalls())
+
+	policies := []string{}
+	for _, call := range consul.RegisterPolicyCalls() {
+		policies = append(policies, call.Name)
+	}
+	assert.Contains(t, policies, "consul-policies-dc2")
+	assert.NotContains(t, policies, "consul-policies")
+	assert.Equal(t, "agent token dc2", secrets.ConsulAgentToken)
+
+	assert.NoError(t, registerFederationTokens(consul, secrets, secondary))
+	assert.Empty(t, secrets.ConsulReplicationToken)
+	assert.Equal(t, "mesh gateway token dc2", secrets.ConsulMeshGatewayToken)
+
+	stored, err := sec.Load(secondary.BaseDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "agent token dc2", stored.ConsulAgentToken)
+	assert.Equal(t, "mesh gateway token dc2", stored.ConsulMeshGatewayToken)
+}
+
+func TestRegisterFederationTokensInPrimary(t *testing.T) {
+	primary, _ := setUpFederation(t)
+	assert.NoError(t, renderFederation(primary))
+	consul := &MockConsul{
+		RegisterACLFunc: func(description, policy string) (string, error) {
+			return policy, nil
+		},
+	}
+	secrets, err := sec.Load(primary.BaseDir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, registerFederationTokens(consul, secrets, primary))
+	assert.Equal(t, "replication", secrets.ConsulReplicationToken)
+	assert.Equal(t, "mesh-gateway", secrets.ConsulMeshGatewayToken)
+
+	assert.NoError(t, registerFederationTokens(consul, secrets, primary))
+	assert.Len(t, consul.RegisterACLCalls(), 2)
+}
+
+func mustInventory(t *testing.T) *ansible.Inventory {
+	inv, err := ansible.LoadInventory(filepath.Join("testdata", "inventory"))
+	assert.NoError(t, err)
+	return inv
+}
@@ -0,0 +1,32 @@
+package hashistack
+
+import "strings"
+
+// AutopilotHealth is the health of the raft peers of the consul or nomad servers, as autopilot reports it.
+// FailureTolerance is how many of the servers can fail without losing quorum.
+type AutopilotHealth struct {
+	Healthy          bool
+	FailureTolerance int
+	Servers          []AutopilotServer
+}
+
+type AutopilotServer struct {
+	ID      string
+	Name    string
+	Address string
+	Version string
+	Healthy bool
+	Leader  bool
+	Voter   bool
+}
+
+// LeaderIP returns the IP address of the leader, or nothing if there is none.
+func (h *AutopilotHealth) LeaderIP() string {
+	for _, server := range h.Servers {
+		if server.Leader {
+			ip, _, _ := strings.Cut(server.Address, ":")
+			return ip
+		}
+	}
+	return ""
+}
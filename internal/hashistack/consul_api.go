@@ -0,0 +1,386 @@
+package hashistack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcl/hclsyntax"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// anonymousTokenID is the well-known accessor ID of Consul's anonymous token.
+const anonymousTokenID = "00000000-0000-0000-0000-000000000002"
+
+var (
+	ErrNoConsulServers        = errors.New("no consul servers found in inventory")
+	ErrACLAlreadyBootstrapped = errors.New("consul ACL system is already bootstrapped and no bootstrap token is known")
+)
+
+type Consul interface {
+	Bootstrap() (string, error)
+	RegisterACL(description, policy string) (string, error)
+	UpdateACL(tokenID, policy string) error
+	UpdatePolicy(name, file string) error
+	RegisterPolicy(name, file string) error
+	ReadPolicy(name string) (string, error)
+	RegisterIntention(file string) error
+	RegisterService(file string) error
+	// Snapshot writes a snapshot of the cluster state to w, which includes vault, as it is stored in consul.
+	Snapshot(w io.Writer) error
+	// Restore replaces the cluster state with the snapshot read from r.
+	Restore(r io.Reader) error
+	// ForceLeave removes node from the members of the datacenter and from its catalog.
+	ForceLeave(node string) error
+	// AutopilotHealth reports the health of the raft peers of the consul servers.
+	AutopilotHealth() (*AutopilotHealth, error)
+}
+
+// ConsulAPIError is returned when the Consul HTTP API responds with a non-2xx status.
+type ConsulAPIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *ConsulAPIError) Error() string {
+	return fmt.Sprintf("consul: %s %s returned %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// IsConsulNotFound reports whether err is a 404 from the Consul API.
+func IsConsulNotFound(err error) bool {
+	var apiErr *ConsulAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+type consulAPI struct {
+	addr    string
+	client  *http.Client
+	secrets *secrets.Config
+	log     *logging.Logger
+}
+
+type aclPolicy struct {
+	ID          string `json:"ID,omitempty"`
+	Name        string `json:"Name"`
+	Description string `json:"Description,omitempty"`
+	Rules       string `json:"Rules"`
+}
+
+type aclPolicyLink struct {
+	ID   string `json:"ID,omitempty"`
+	Name string `json:"Name,omitempty"`
+}
+
+type aclToken struct {
+	AccessorID  string          `json:"AccessorID,omitempty"`
+	SecretID    string          `json:"SecretID,omitempty"`
+	Description string          `json:"Description,omitempty"`
+	Policies    []aclPolicyLink `json:"Policies,omitempty"`
+}
+
+// NewConsulAPI returns a Consul client that talks to the HTTP API of the first Consul server in the
+// inventory over mTLS, using the agent CA material in baseDir/secrets/consul.
+func NewConsulAPI(inventory *ansible.Inventory, secrets *secrets.Config, baseDir string, log *logging.Logger) (Consul, error) {
+	hosts := inventory.All.Children.ConsulServers.GetHosts()
+	if len(hosts) == 0 {
+		return nil, ErrNoConsulServers
+	}
+	consulSecretDir := filepath.Join(baseDir, "secrets", "consul")
+	client, err := util.NewTLSClient(
+		filepath.Join(consulSecretDir, "consul-agent-ca.pem"),
+		filepath.Join(consulSecretDir, "consul-agent-ca.pem"),
+		filepath.Join(consulSecretDir, "consul-agent-ca-key.pem"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	api := newConsulAPI(fmt.Sprintf("https://%s:8501", hosts[0]), client, secrets)
+	api.log = log
+	return api, nil
+}
+
+func newConsulAPI(addr string, client *http.Client, secrets *secrets.Config) *consulAPI {
+	return &consulAPI{addr: strings.TrimSuffix(addr, "/"), client: client, secrets: secrets}
+}
+
+func (c *consulAPI) Bootstrap() (string, error) {
+	var token aclToken
+	err := c.do(http.MethodPut, "/v1/acl/bootstrap", nil, &token)
+	if err != nil {
+		var apiErr *ConsulAPIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden {
+			if c.secrets.ConsulBootstrapToken != "" && c.secrets.ConsulBootstrapToken != "TBD" {
+				return c.secrets.ConsulBootstrapToken, nil
+			}
+			return "", fmt.Errorf("%w: %s", ErrACLAlreadyBootstrapped, apiErr.Message)
+		}
+		return "", err
+	}
+	c.secrets.ConsulBootstrapToken = token.SecretID
+	return token.SecretID, nil
+}
+
+func (c *consulAPI) RegisterACL(description, policy string) (string, error) {
+	var tokens []aclToken
+	err := c.do(http.MethodGet, "/v1/acl/tokens", nil, &tokens)
+	if err != nil {
+		return "", err
+	}
+	body := aclToken{Description: description, Policies: []aclPolicyLink{{Name: policy}}}
+	path := "/v1/acl/token"
+	for _, t := range tokens {
+		if t.Description == description {
+			body.AccessorID = t.AccessorID
+			path = "/v1/acl/token/" + url.PathEscape(t.AccessorID)
+			break
+		}
+	}
+	var token aclToken
+	err = c.do(http.MethodPut, path, body, &token)
+	if err != nil {
+		return "", err
+	}
+	return token.SecretID, nil
+}
+
+func (c *consulAPI) UpdateACL(tokenID, policy string) error {
+	if tokenID == "anonymous" {
+		tokenID = anonymousTokenID
+	}
+	var token aclToken
+	err := c.do(http.MethodGet, "/v1/acl/token/"+url.PathEscape(tokenID), nil, &token)
+	if err != nil {
+		return err
+	}
+	for _, p := range token.Policies {
+		if p.Name == policy {
+			return nil
+		}
+	}
+	token.SecretID = ""
+	token.Policies = append(token.Policies, aclPolicyLink{Name: policy})
+	return c.do(http.MethodPut, "/v1/acl/token/"+url.PathEscape(tokenID), token, nil)
+}
+
+func (c *consulAPI) RegisterPolicy(name, file string) error {
+	return c.upsertPolicy(name, file)
+}
+
+func (c *consulAPI) UpdatePolicy(name, file string) error {
+	return c.upsertPolicy(name, file)
+}
+
+func (c *consulAPI) ReadPolicy(name string) (string, error) {
+	var policy aclPolicy
+	err := c.do(http.MethodGet, "/v1/acl/policy/name/"+url.PathEscape(name), nil, &policy)
+	return policy.Rules, err
+}
+
+func (c *consulAPI) upsertPolicy(name, file string) error {
+	rules, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return err
+	}
+	policy := aclPolicy{Name: name, Rules: string(rules)}
+
+	var existing aclPolicy
+	err = c.do(http.MethodGet, "/v1/acl/policy/name/"+url.PathEscape(name), nil, &existing)
+	if IsConsulNotFound(err) {
+		return c.do(http.MethodPut, "/v1/acl/policy", policy, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Rules == policy.Rules {
+		return nil
+	}
+	policy.ID = existing.ID
+	policy.Description = existing.Description
+	return c.do(http.MethodPut, "/v1/acl/policy/"+url.PathEscape(existing.ID), policy, nil)
+}
+
+func (c *consulAPI) RegisterIntention(file string) error {
+	entry, err := decodeConsulFile(file)
+	if err != nil {
+		return err
+	}
+	return c.do(http.MethodPut, "/v1/config", entry, nil)
+}
+
+func (c *consulAPI) RegisterService(file string) error {
+	def, err := decodeConsulFile(file)
+	if err != nil {
+		return err
+	}
+	if service, ok := def["service"]; ok {
+		return c.do(http.MethodPut, "/v1/agent/service/register", service, nil)
+	}
+	return c.do(http.MethodPut, "/v1/agent/service/register", def, nil)
+}
+
+func (c *consulAPI) Snapshot(w io.Writer) error {
+	return c.doWith(util.NewStreamingClient(c.client, snapshotHeaderTimeout), http.MethodGet, "/v1/snapshot", nil, w)
+}
+
+func (c *consulAPI) Restore(r io.Reader) error {
+	return c.doWith(util.NewStreamingClient(c.client, snapshotHeaderTimeout), http.MethodPut, "/v1/snapshot", r, nil)
+}
+
+func (c *consulAPI) ForceLeave(node string) error {
+	return c.do(http.MethodPut, "/v1/agent/force-leave/"+url.PathEscape(node)+"?prune=true", nil, nil)
+}
+
+func (c *consulAPI) AutopilotHealth() (*AutopilotHealth, error) {
+	var health AutopilotHealth
+	err := c.do(http.MethodGet, "/v1/operator/autopilot/health", nil, &health)
+	if err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+func (c *consulAPI) do(method, path string, in, out interface{}) error {
+	return c.doWith(c.client, method, path, in, out)
+}
+
+// doWith sends in as JSON, or as is if it is an io.Reader, and decodes the response into out, or copies it
+// as is if out is an io.Writer.
+func (c *consulAPI) doWith(client *http.Client, method, path string, in, out interface{}) error {
+	body, contentType, err := requestBody(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, c.addr+path, body)
+	if err != nil {
+		return err
+	}
+	if token := c.secrets.ConsulBootstrapToken; token != "" && token != "TBD" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+	c.log.Debug("consul api request", "method", method, "path", path, "status", resp.StatusCode, "duration", time.Since(start).Round(time.Millisecond))
+
+	if w, ok := out.(io.Writer); ok && resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &ConsulAPIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(respBody)),
+		}
+	}
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// consulKeys maps the snake_case keys used in our HCL definitions to the field names the Consul
+// HTTP API expects. Single word keys are matched case-insensitively by Consul and need no mapping.
+var consulKeys = map[string]string{
+	"tagged_addresses":                  "TaggedAddresses",
+	"disable_redirects":                 "DisableRedirects",
+	"enable_tag_override":               "EnableTagOverride",
+	"tls_skip_verify":                   "TLSSkipVerify",
+	"deregister_critical_service_after": "DeregisterCriticalServiceAfter",
+}
+
+// decodeConsulFile reads a Consul service or config entry definition, in either HCL or JSON, into a
+// generic map that can be sent to the HTTP API.
+func decodeConsulFile(file string) (map[string]interface{}, error) {
+	src, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(file, ".json") {
+		res := map[string]interface{}{}
+		return res, json.Unmarshal(src, &res)
+	}
+	f, diags := hclsyntax.ParseConfig(src, file, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	return decodeHCLBody(f.Body.(*hclsyntax.Body))
+}
+
+func decodeHCLBody(body *hclsyntax.Body) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	for name, attr := range body.Attributes {
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		raw, err := json.Marshal(ctyjson.SimpleJSONValue{Value: val})
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		err = json.Unmarshal(raw, &v)
+		if err != nil {
+			return nil, err
+		}
+		res[consulKey(name)] = v
+	}
+	for _, block := range body.Blocks {
+		v, err := decodeHCLBody(block.Body)
+		if err != nil {
+			return nil, err
+		}
+		res[consulKey(block.Type)] = v
+	}
+	return translateKeys(res).(map[string]interface{}), nil
+}
+
+func translateKeys(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, inner := range val {
+			res[consulKey(k)] = translateKeys(inner)
+		}
+		return res
+	case []interface{}:
+		for i := range val {
+			val[i] = translateKeys(val[i])
+		}
+		return val
+	}
+	return v
+}
+
+func consulKey(key string) string {
+	if mapped, ok := consulKeys[key]; ok {
+		return mapped
+	}
+	return key
+}
@@ -0,0 +1,228 @@
+package hashistack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeConsul struct {
+	sync.Mutex
+	bootstrapped bool
+	policies     map[string]aclPolicy
+	tokens       map[string]aclToken
+	requests     map[string]int
+	bodies       map[string]map[string]interface{}
+	tokenHeaders []string
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		policies: map[string]aclPolicy{},
+		tokens: map[string]aclToken{
+			anonymousTokenID: {AccessorID: anonymousTokenID, Description: "Anonymous Token"},
+		},
+		requests: map[string]int{},
+		bodies:   map[string]map[string]interface{}{},
+	}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests[r.Method+" "+r.URL.Path]++
+	f.tokenHeaders = append(f.tokenHeaders, r.Header.Get("X-Consul-Token"))
+
+	writeJSON := func(v interface{}) {
+		assertNoErr(json.NewEncoder(w).Encode(v))
+	}
+
+	switch {
+	case r.URL.Path == "/v1/acl/bootstrap":
+		if f.bootstrapped {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("Permission denied: ACL bootstrap no longer allowed"))
+			return
+		}
+		f.bootstrapped = true
+		writeJSON(aclToken{AccessorID: "root", SecretID: "root-secret"})
+	case strings.HasPrefix(r.URL.Path, "/v1/acl/policy/name/"):
+		p, ok := f.policies[strings.TrimPrefix(r.URL.Path, "/v1/acl/policy/name/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(p)
+	case r.URL.Path == "/v1/acl/policy" || strings.HasPrefix(r.URL.Path, "/v1/acl/policy/"):
+		var p aclPolicy
+		assertNoErr(json.NewDecoder(r.Body).Decode(&p))
+		if p.ID == "" {
+			p.ID = "id-" + p.Name
+		}
+		f.policies[p.Name] = p
+		writeJSON(p)
+	case r.URL.Path == "/v1/acl/tokens":
+		res := []aclToken{}
+		for _, t := range f.tokens {
+			res = append(res, aclToken{AccessorID: t.AccessorID, Description: t.Description, Policies: t.Policies})
+		}
+		writeJSON(res)
+	case r.URL.Path == "/v1/acl/token" || strings.HasPrefix(r.URL.Path, "/v1/acl/token/"):
+		if r.Method == http.MethodGet {
+			t, ok := f.tokens[strings.TrimPrefix(r.URL.Path, "/v1/acl/token/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeJSON(t)
+			return
+		}
+		var t aclToken
+		assertNoErr(json.NewDecoder(r.Body).Decode(&t))
+		if t.AccessorID == "" {
+			t.AccessorID = "accessor-" + t.Description
+		}
+		t.SecretID = "secret-" + t.AccessorID
+		f.tokens[t.AccessorID] = t
+		writeJSON(t)
+	case r.URL.Path == "/v1/snapshot" && r.Method == http.MethodPut:
+		restored, _ := io.ReadAll(r.Body)
+		f.bodies[r.URL.Path] = map[string]interface{}{"snapshot": string(restored), "content-type": r.Header.Get("Content-Type")}
+	case r.URL.Path == "/v1/snapshot":
+		_, _ = w.Write([]byte("snapshot-data"))
+	case r.URL.Path == "/v1/operator/autopilot/health":
+		_, _ = w.Write([]byte(`{"Healthy":true,"FailureTolerance":1,"Servers":[{"ID":"s1","Name":"srv-consul-1","Address":"10.0.0.2:8300","Version":"1.16.2","Healthy":true,"Leader":true,"Voter":true}]}`))
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/force-leave/"):
+		f.bodies[r.URL.Path] = map[string]interface{}{"prune": r.URL.Query().Get("prune")}
+	default:
+		var body map[string]interface{}
+		assertNoErr(json.NewDecoder(r.Body).Decode(&body))
+		f.bodies[r.URL.Path] = body
+	}
+}
+
+func assertNoErr(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func newTestConsulAPI(t *testing.T, sec *secrets.Config) (*consulAPI, *fakeConsul) {
+	fake := newFakeConsul()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return newConsulAPI(server.URL, server.Client(), sec), fake
+}
+
+func TestConsulAPIBootstrap(t *testing.T) {
+	sec := &secrets.Config{ConsulBootstrapToken: "TBD"}
+	client, fake := newTestConsulAPI(t, sec)
+
+	token, err := client.Bootstrap()
+	assert.NoError(t, err)
+	assert.Equal(t, "root-secret", token)
+	assert.Equal(t, "root-secret", sec.ConsulBootstrapToken)
+	assert.Equal(t, "", fake.tokenHeaders[0])
+
+	token, err = client.Bootstrap()
+	assert.NoError(t, err)
+	assert.Equal(t, "root-secret", token)
+
+	sec.ConsulBootstrapToken = "TBD"
+	_, err = client.Bootstrap()
+	assert.True(t, errors.Is(err, ErrACLAlreadyBootstrapped))
+}
+
+func TestConsulAPIPoliciesAndTokensAreIdempotent(t *testing.T) {
+	client, fake := newTestConsulAPI(t, &secrets.Config{ConsulBootstrapToken: "root-secret"})
+	policy := filepath.Join("testdata", "policy.hcl")
+
+	assert.NoError(t, client.RegisterPolicy("nomad-client", policy))
+	assert.NoError(t, client.RegisterPolicy("nomad-client", policy))
+	assert.NoError(t, client.UpdatePolicy("nomad-client", policy))
+	assert.Equal(t, 1, fake.requests["PUT /v1/acl/policy"])
+	assert.Contains(t, fake.policies["nomad-client"].Rules, `node_prefix ""`)
+	rules, err := client.ReadPolicy("nomad-client")
+	assert.NoError(t, err)
+	assert.Equal(t, fake.policies["nomad-client"].Rules, rules)
+
+	first, err := client.RegisterACL("client token", "nomad-client")
+	assert.NoError(t, err)
+	second, err := client.RegisterACL("client token", "nomad-client")
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, fake.requests["PUT /v1/acl/token"])
+	assert.Len(t, fake.tokens, 2)
+
+	assert.NoError(t, client.UpdateACL("anonymous", "nomad-client"))
+	assert.NoError(t, client.UpdateACL("anonymous", "nomad-client"))
+	assert.Equal(t, 1, fake.requests["PUT /v1/acl/token/"+anonymousTokenID])
+	assert.Equal(t, "Anonymous Token", fake.tokens[anonymousTokenID].Description)
+
+	for _, h := range fake.tokenHeaders {
+		assert.Equal(t, "root-secret", h)
+	}
+
+	err = client.UpdateACL("missing", "y")
+	assert.True(t, IsConsulNotFound(err))
+}
+
+func TestConsulAPIServicesAndIntentions(t *testing.T) {
+	client, fake := newTestConsulAPI(t, &secrets.Config{ConsulBootstrapToken: "root-secret"})
+
+	assert.NoError(t, client.RegisterService(filepath.Join("testdata", "service.hcl")))
+	service := fake.bodies["/v1/agent/service/register"]
+	assert.Equal(t, "grafana", service["name"])
+	assert.Equal(t, float64(3000), service["port"])
+	check := service["check"].(map[string]interface{})
+	assert.Equal(t, true, check["DisableRedirects"])
+	lan := service["TaggedAddresses"].(map[string]interface{})["lan"].(map[string]interface{})
+	assert.Equal(t, "10.0.4.2", lan["address"])
+
+	assert.NoError(t, client.RegisterIntention(filepath.Join("testdata", "intention.hcl")))
+	entry := fake.bodies["/v1/config"]
+	assert.Equal(t, "service-intentions", entry["Kind"])
+	assert.Equal(t, "allow", entry["Sources"].([]interface{})[0].(map[string]interface{})["Action"])
+}
+
+func TestConsulAPISnapshot(t *testing.T) {
+	client, fake := newTestConsulAPI(t, &secrets.Config{ConsulBootstrapToken: "root-secret"})
+
+	var snap bytes.Buffer
+	assert.NoError(t, client.Snapshot(&snap))
+	assert.Equal(t, "snapshot-data", snap.String())
+	assert.Equal(t, 1, fake.requests["GET /v1/snapshot"])
+
+	assert.NoError(t, client.Restore(strings.NewReader("snapshot-data")))
+	assert.Equal(t, map[string]interface{}{"snapshot": "snapshot-data", "content-type": "application/octet-stream"}, fake.bodies["/v1/snapshot"])
+	assert.Equal(t, "root-secret", fake.tokenHeaders[len(fake.tokenHeaders)-1])
+}
+
+func TestConsulAPIForceLeave(t *testing.T) {
+	client, fake := newTestConsulAPI(t, &secrets.Config{ConsulBootstrapToken: "root-secret"})
+
+	assert.NoError(t, client.ForceLeave("openpaas-client-3"))
+	assert.Equal(t, 1, fake.requests["PUT /v1/agent/force-leave/openpaas-client-3"])
+	assert.Equal(t, "true", fake.bodies["/v1/agent/force-leave/openpaas-client-3"]["prune"])
+}
+
+func TestConsulAPIAutopilotHealth(t *testing.T) {
+	client, _ := newTestConsulAPI(t, &secrets.Config{ConsulBootstrapToken: "root-secret"})
+
+	health, err := client.AutopilotHealth()
+	assert.NoError(t, err)
+	assert.True(t, health.Healthy)
+	assert.Equal(t, 1, health.FailureTolerance)
+	assert.Equal(t, "1.16.2", health.Servers[0].Version)
+	assert.Equal(t, "10.0.0.2", health.LeaderIP())
+}
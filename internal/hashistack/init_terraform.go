@@ -13,7 +13,10 @@ import (
 	"github.com/hashicorp/terraform-exec/tfexec"
 )
 
-func InitTf(ctx context.Context, workingDir string, stdOut, stdErr io.Writer) (*tfexec.Terraform, error) {
+// InitTf installs terraform if needed and initialises workingDir, with opts such as the backend config
+// added to the init. Terraform runs with env, such as BackendEnv, or with the environment of openpaas
+// when it is nil.
+func InitTf(ctx context.Context, workingDir string, env map[string]string, stdOut, stdErr io.Writer, opts ...tfexec.InitOption) (*tfexec.Terraform, error) {
 
 	i := install.NewInstaller()
 
@@ -37,8 +40,14 @@ func InitTf(ctx context.Context, workingDir string, stdOut, stdErr io.Writer) (*
 	if err != nil {
 		return nil, err
 	}
+	if env != nil {
+		err = tf.SetEnv(env)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	err = tf.Init(ctx, tfexec.Upgrade(true))
+	err = tf.Init(ctx, append([]tfexec.InitOption{tfexec.Upgrade(true)}, opts...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -10,7 +10,7 @@ import (
 
 func Test_Init_Terraform(t *testing.T) {
 	ctx := context.Background()
-	tf, err := InitTf(ctx, ".", os.Stdin, os.Stderr)
+	tf, err := InitTf(ctx, ".", nil, os.Stdin, os.Stderr)
 	assert.NoError(t, err)
 	v, _, err := tf.Version(ctx, false)
 	assert.NoError(t, err)
@@ -1,44 +1,455 @@
 package hashistack
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/OpenPaaSDev/openpaas/internal/runtime"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
 )
 
+// NomadClient manages the lifecycle of jobs through the Nomad HTTP API.
 type NomadClient interface {
-	RunJob(jobFile string) error
+	PlanJob(jobFile string) (*JobPlan, error)
+	RunJob(jobFile string) (*JobRegistration, error)
+	RunJobWithCheckIndex(jobFile string, checkIndex uint64) (*JobRegistration, error)
+	StopJob(jobID string) (string, error)
+	PurgeJob(jobID string) (string, error)
+	JobStatus(jobID string) (*JobStatus, error)
+	Allocations(jobID string) ([]Allocation, error)
+	WaitForDeployment(ctx context.Context, jobID string) (*Deployment, error)
+	// Snapshot writes a snapshot of the state of the nomad servers to w.
+	Snapshot(w io.Writer) error
+	// Restore replaces the state of the nomad servers with the snapshot read from r.
+	Restore(r io.Reader) error
+	// Nodes lists the clients registered with the nomad servers.
+	Nodes() ([]Node, error)
+	// MarkIneligible keeps the scheduler from placing new allocations on the client nodeID.
+	MarkIneligible(nodeID string) error
+	// DrainNode drains the client nodeID and waits until its allocations have migrated to other clients.
+	// Allocations still running after deadline are stopped.
+	DrainNode(ctx context.Context, nodeID string, deadline time.Duration) error
+	// PurgeNode removes the client nodeID from the nomad servers.
+	PurgeNode(nodeID string) error
+	// Leader returns the address of the leader of the nomad servers, which is empty without one.
+	Leader() (string, error)
+	// AutopilotHealth reports the health of the raft peers of the nomad servers.
+	AutopilotHealth() (*AutopilotHealth, error)
+	// PutVariable writes items to the nomad variable at path, which jobs read in their templates.
+	PutVariable(path string, items map[string]string) error
 }
 
+var ErrCheckIndexConflict = errors.New("job modify index does not match the check index")
+
+// NomadAPIError is returned when the Nomad HTTP API responds with a non-2xx status.
+type NomadAPIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *NomadAPIError) Error() string {
+	return fmt.Sprintf("nomad: %s %s returned %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// IsNomadNotFound reports whether err is a 404 from the Nomad API.
+func IsNomadNotFound(err error) bool {
+	var apiErr *NomadAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// DeploymentError is returned by WaitForDeployment when a deployment ends unsuccessfully.
+type DeploymentError struct {
+	Deployment Deployment
+}
+
+func (e *DeploymentError) Error() string {
+	return fmt.Sprintf("deployment %s of job version %d %s: %s", e.Deployment.ID, e.Deployment.JobVersion, e.Deployment.Status, e.Deployment.StatusDescription)
+}
+
+type JobPlan struct {
+	JobModifyIndex uint64
+	Diff           struct {
+		Type string
+	}
+	FailedTGAllocs map[string]interface{}
+	Warnings       string
+}
+
+// HasChanges reports whether running the planned job would change the cluster.
+func (p *JobPlan) HasChanges() bool {
+	return p.Diff.Type != "" && p.Diff.Type != "None"
+}
+
+type JobRegistration struct {
+	EvalID         string
+	JobModifyIndex uint64
+	Warnings       string
+}
+
+type JobStatus struct {
+	ID             string
+	Name           string
+	Type           string
+	Status         string
+	Stop           bool
+	Version        uint64
+	JobModifyIndex uint64
+}
+
+type Allocation struct {
+	ID            string
+	Name          string
+	NodeID        string
+	NodeName      string
+	TaskGroup     string
+	JobVersion    uint64
+	ClientStatus  string
+	DesiredStatus string
+}
+
+type Node struct {
+	ID                    string
+	Name                  string
+	Status                string
+	SchedulingEligibility string
+	Drain                 bool
+}
+
+type Deployment struct {
+	ID                string
+	JobID             string
+	JobVersion        uint64
+	Status            string
+	StatusDescription string
+}
+
+const (
+	DeploymentRunning    = "running"
+	DeploymentSuccessful = "successful"
+	DeploymentFailed     = "failed"
+	DeploymentCancelled  = "cancelled"
+)
+
 type nomadCli struct {
-	baseDir, nomadAddr, caCert, clientCert, clientKey string
+	nomadAddr, caCert, clientCert, clientKey string
+
+	pollInterval time.Duration
+	log          *logging.Logger
+	clientOnce   sync.Once
+	client       *http.Client
+	clientErr    error
 }
 
-func NewNomadClient(baseDir, nomadAddr, caCert, clientCert, clientKey string) NomadClient {
+func NewNomadClient(nomadAddr, caCert, clientCert, clientKey string, log *logging.Logger) NomadClient {
 	return &nomadCli{
-		baseDir:    baseDir,
-		nomadAddr:  nomadAddr,
-		caCert:     caCert,
-		clientCert: clientCert,
-		clientKey:  clientKey,
+		log:          log,
+		nomadAddr:    strings.TrimSuffix(nomadAddr, "/"),
+		caCert:       caCert,
+		clientCert:   clientCert,
+		clientKey:    clientKey,
+		pollInterval: 5 * time.Second,
+	}
+}
+
+func (nomad *nomadCli) PlanJob(jobFile string) (*JobPlan, error) {
+	job, id, err := nomad.parseJob(jobFile)
+	if err != nil {
+		return nil, err
+	}
+	var plan JobPlan
+	err = nomad.do(http.MethodPost, "/v1/job/"+url.PathEscape(id)+"/plan", map[string]interface{}{"Job": job, "Diff": true}, &plan)
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (nomad *nomadCli) RunJob(jobFile string) (*JobRegistration, error) {
+	return nomad.register(jobFile, map[string]interface{}{})
+}
+
+func (nomad *nomadCli) RunJobWithCheckIndex(jobFile string, checkIndex uint64) (*JobRegistration, error) {
+	return nomad.register(jobFile, map[string]interface{}{"EnforceIndex": true, "JobModifyIndex": checkIndex})
+}
+
+func (nomad *nomadCli) register(jobFile string, req map[string]interface{}) (*JobRegistration, error) {
+	job, _, err := nomad.parseJob(jobFile)
+	if err != nil {
+		return nil, err
+	}
+	req["Job"] = job
+	var reg JobRegistration
+	err = nomad.do(http.MethodPost, "/v1/jobs", req, &reg)
+	var apiErr *NomadAPIError
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Message, "conflicting job modify index") {
+		return nil, fmt.Errorf("%w: %s", ErrCheckIndexConflict, apiErr.Message)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reg, nil
+}
+
+func (nomad *nomadCli) StopJob(jobID string) (string, error) {
+	return nomad.deregister(jobID, false)
+}
+
+func (nomad *nomadCli) PurgeJob(jobID string) (string, error) {
+	return nomad.deregister(jobID, true)
+}
+
+func (nomad *nomadCli) deregister(jobID string, purge bool) (string, error) {
+	var res struct {
+		EvalID string
+	}
+	err := nomad.do(http.MethodDelete, fmt.Sprintf("/v1/job/%s?purge=%t", url.PathEscape(jobID), purge), nil, &res)
+	return res.EvalID, err
+}
+
+func (nomad *nomadCli) JobStatus(jobID string) (*JobStatus, error) {
+	var status JobStatus
+	err := nomad.do(http.MethodGet, "/v1/job/"+url.PathEscape(jobID), nil, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (nomad *nomadCli) Allocations(jobID string) ([]Allocation, error) {
+	allocs := []Allocation{}
+	err := nomad.do(http.MethodGet, "/v1/job/"+url.PathEscape(jobID)+"/allocations", nil, &allocs)
+	return allocs, err
+}
+
+// WaitForDeployment polls the deployment of the current version of the job until it is no longer
+// running, returning a DeploymentError if it did not succeed.
+func (nomad *nomadCli) WaitForDeployment(ctx context.Context, jobID string) (*Deployment, error) {
+	for {
+		status, err := nomad.JobStatus(jobID)
+		if err != nil {
+			return nil, err
+		}
+		var deployment *Deployment
+		err = nomad.do(http.MethodGet, "/v1/job/"+url.PathEscape(jobID)+"/deployment", nil, &deployment)
+		if err != nil {
+			return nil, err
+		}
+		if deployment != nil && deployment.JobVersion == status.Version && deployment.Status != DeploymentRunning {
+			if deployment.Status != DeploymentSuccessful {
+				return deployment, &DeploymentError{Deployment: *deployment}
+			}
+			return deployment, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return deployment, ctx.Err()
+		case <-time.After(nomad.pollInterval):
+		}
+	}
+}
+
+func (nomad *nomadCli) Nodes() ([]Node, error) {
+	nodes := []Node{}
+	err := nomad.do(http.MethodGet, "/v1/nodes", nil, &nodes)
+	return nodes, err
+}
+
+func (nomad *nomadCli) MarkIneligible(nodeID string) error {
+	return nomad.do(http.MethodPost, "/v1/node/"+url.PathEscape(nodeID)+"/eligibility", map[string]string{"Eligibility": "ineligible"}, nil)
+}
+
+// DrainNode polls the node until its drain is complete and none of its allocations run anymore.
+func (nomad *nomadCli) DrainNode(ctx context.Context, nodeID string, deadline time.Duration) error {
+	path := "/v1/node/" + url.PathEscape(nodeID)
+	err := nomad.do(http.MethodPost, path+"/drain", map[string]interface{}{
+		"DrainSpec": map[string]interface{}{"Deadline": deadline.Nanoseconds()},
+		"Meta":      map[string]string{"message": "removed by openpaas scale"},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		var node struct {
+			DrainStrategy interface{}
+		}
+		err = nomad.do(http.MethodGet, path, nil, &node)
+		if err != nil {
+			return err
+		}
+		if node.DrainStrategy == nil {
+			allocs := []Allocation{}
+			err = nomad.do(http.MethodGet, path+"/allocations", nil, &allocs)
+			if err != nil {
+				return err
+			}
+			running := 0
+			for _, alloc := range allocs {
+				if alloc.ClientStatus == "running" || alloc.ClientStatus == "pending" {
+					running++
+				}
+			}
+			if running == 0 {
+				return nil
+			}
+			nomad.log.Debug("waiting for allocations to stop", "node", nodeID, "running", running)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(nomad.pollInterval):
+		}
 	}
 }
 
-func (nomad *nomadCli) Get() map[string]string {
-	return map[string]string{
-		"NOMAD_ADDR":        nomad.nomadAddr,
-		"NOMAD_CACERT":      nomad.caCert,
-		"NOMAD_CLIENT_CERT": nomad.clientCert,
-		"NOMAD_CLIENT_KEY":  nomad.clientKey,
+func (nomad *nomadCli) PurgeNode(nodeID string) error {
+	return nomad.do(http.MethodPost, "/v1/node/"+url.PathEscape(nodeID)+"/purge", nil, nil)
+}
+
+func (nomad *nomadCli) Leader() (string, error) {
+	var leader string
+	err := nomad.do(http.MethodGet, "/v1/status/leader", nil, &leader)
+	return leader, err
+}
+
+func (nomad *nomadCli) AutopilotHealth() (*AutopilotHealth, error) {
+	var health AutopilotHealth
+	err := nomad.do(http.MethodGet, "/v1/operator/autopilot/health", nil, &health)
+	if err != nil {
+		return nil, err
 	}
+	return &health, nil
 }
 
-func (nomad *nomadCli) WorkingDir() string {
-	return nomad.baseDir
+func (nomad *nomadCli) PutVariable(path string, items map[string]string) error {
+	return nomad.do(http.MethodPut, "/v1/var/"+path, map[string]interface{}{"Path": path, "Items": items}, nil)
 }
 
-func (nomad *nomadCli) RunJob(jobFile string) error {
-	cmd := fmt.Sprintf("nomad job run %s", jobFile)
-	return runtime.Exec(nomad, cmd, os.Stdout)
+func (nomad *nomadCli) parseJob(jobFile string) (map[string]interface{}, string, error) {
+	hcl, err := os.ReadFile(filepath.Clean(jobFile))
+	if err != nil {
+		return nil, "", err
+	}
+	var job map[string]interface{}
+	err = nomad.do(http.MethodPost, "/v1/jobs/parse", map[string]interface{}{"JobHCL": string(hcl), "Canonicalize": true}, &job)
+	if err != nil {
+		return nil, "", err
+	}
+	id, _ := job["ID"].(string)
+	if id == "" {
+		return nil, "", fmt.Errorf("job in %s has no ID", jobFile)
+	}
+	return job, id, nil
+}
+
+func (nomad *nomadCli) httpClient() (*http.Client, error) {
+	nomad.clientOnce.Do(func() {
+		if nomad.client == nil {
+			nomad.client, nomad.clientErr = util.NewTLSClient(nomad.caCert, nomad.clientCert, nomad.clientKey)
+		}
+	})
+	return nomad.client, nomad.clientErr
+}
+
+// snapshotHeaderTimeout bounds the wait for the servers to respond to a snapshot or restore request, whose
+// transfer itself takes as long as the size of the cluster state needs.
+const snapshotHeaderTimeout = 10 * time.Minute
+
+// requestBody returns the body of a request sending in and its content type. Readers, such as snapshots,
+// are sent as is and anything else as JSON.
+func requestBody(in interface{}) (io.Reader, string, error) {
+	switch in := in.(type) {
+	case nil:
+		return nil, "", nil
+	case io.Reader:
+		return in, "application/octet-stream", nil
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes.NewReader(b), "application/json", nil
+}
+
+func (nomad *nomadCli) Snapshot(w io.Writer) error {
+	client, err := nomad.httpClient()
+	if err != nil {
+		return err
+	}
+	return nomad.doWith(util.NewStreamingClient(client, snapshotHeaderTimeout), http.MethodGet, "/v1/operator/snapshot", nil, w)
+}
+
+func (nomad *nomadCli) Restore(r io.Reader) error {
+	client, err := nomad.httpClient()
+	if err != nil {
+		return err
+	}
+	return nomad.doWith(util.NewStreamingClient(client, snapshotHeaderTimeout), http.MethodPut, "/v1/operator/snapshot", r, nil)
+}
+
+func (nomad *nomadCli) do(method, path string, in, out interface{}) error {
+	client, err := nomad.httpClient()
+	if err != nil {
+		return err
+	}
+	return nomad.doWith(client, method, path, in, out)
+}
+
+// doWith sends in as JSON, or as is if it is an io.Reader, and decodes the response into out, or copies it
+// as is if out is an io.Writer.
+func (nomad *nomadCli) doWith(client *http.Client, method, path string, in, out interface{}) error {
+	body, contentType, err := requestBody(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, nomad.nomadAddr+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+	nomad.log.Debug("nomad api request", "method", method, "path", path, "status", resp.StatusCode, "duration", time.Since(start).Round(time.Millisecond))
+
+	if w, ok := out.(io.Writer); ok && resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &NomadAPIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(respBody)),
+		}
+	}
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
 }
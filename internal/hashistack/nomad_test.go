@@ -1,16 +1,289 @@
 package hashistack
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestNomad(t *testing.T) {
-	nomad := NewNomadClient(".", "127.0.0.1", "cacert.pem", "cacert.crt", "cacert.key")
+	nomad := NewNomadClient("https://127.0.0.1:4646/", "cacert.pem", "cacert.crt", "cacert.key", logging.Discard())
 	assert.NotNil(t, nomad)
 	cli := nomad.(*nomadCli)
-	assert.Len(t, cli.Get(), 4)
-	assert.Equal(t, cli.WorkingDir(), ".")
+	assert.Equal(t, "https://127.0.0.1:4646", cli.nomadAddr)
 
 }
+
+func newTestNomad(t *testing.T, handler http.HandlerFunc) *nomadCli {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	cli := NewNomadClient(server.URL, "", "", "", logging.Discard()).(*nomadCli)
+	cli.client = server.Client()
+	cli.pollInterval = time.Millisecond
+	return cli
+}
+
+func parseHandler(t *testing.T, w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path != "/v1/jobs/parse" {
+		return false
+	}
+	var req map[string]interface{}
+	assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+	assert.Contains(t, req["JobHCL"], `job "healthcheck"`)
+	assert.Equal(t, true, req["Canonicalize"])
+	_, _ = w.Write([]byte(`{"ID":"healthcheck","Type":"service"}`))
+	return true
+}
+
+func TestNomadPlanAndRun(t *testing.T) {
+	var registered map[string]interface{}
+	nomad := newTestNomad(t, func(w http.ResponseWriter, r *http.Request) {
+		if parseHandler(t, w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/job/healthcheck/plan":
+			var req map[string]interface{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, true, req["Diff"])
+			_, _ = w.Write([]byte(`{"JobModifyIndex":42,"Diff":{"Type":"Edited"}}`))
+		case "/v1/jobs":
+			registered = nil
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&registered))
+			if registered["EnforceIndex"] == true && registered["JobModifyIndex"] != float64(42) {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`Enforcing job modify index 41: job exists with conflicting job modify index: 42`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"EvalID":"eval-1","JobModifyIndex":43}`))
+		}
+	})
+	jobFile := filepath.Join("..", "templates", "nomad", "web.hcl")
+
+	plan, err := nomad.PlanJob(jobFile)
+	assert.NoError(t, err)
+	assert.True(t, plan.HasChanges())
+	assert.Equal(t, uint64(42), plan.JobModifyIndex)
+
+	reg, err := nomad.RunJobWithCheckIndex(jobFile, plan.JobModifyIndex)
+	assert.NoError(t, err)
+	assert.Equal(t, "eval-1", reg.EvalID)
+	assert.Equal(t, "healthcheck", registered["Job"].(map[string]interface{})["ID"])
+
+	_, err = nomad.RunJobWithCheckIndex(jobFile, 41)
+	assert.True(t, errors.Is(err, ErrCheckIndexConflict))
+
+	_, err = nomad.RunJob(jobFile)
+	assert.NoError(t, err)
+	assert.Nil(t, registered["EnforceIndex"])
+}
+
+func TestNomadStopPurgeAndStatus(t *testing.T) {
+	nomad := newTestNomad(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete && r.URL.Path == "/v1/job/healthcheck":
+			_, _ = w.Write([]byte(`{"EvalID":"eval-purge-` + r.URL.Query().Get("purge") + `"}`))
+		case r.URL.Path == "/v1/job/healthcheck":
+			_, _ = w.Write([]byte(`{"ID":"healthcheck","Status":"running","Version":3}`))
+		case r.URL.Path == "/v1/job/healthcheck/allocations":
+			_, _ = w.Write([]byte(`[{"ID":"a1","NodeID":"n1","ClientStatus":"running","DesiredStatus":"run"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`job not found`))
+		}
+	})
+
+	eval, err := nomad.StopJob("healthcheck")
+	assert.NoError(t, err)
+	assert.Equal(t, "eval-purge-false", eval)
+	eval, err = nomad.PurgeJob("healthcheck")
+	assert.NoError(t, err)
+	assert.Equal(t, "eval-purge-true", eval)
+
+	status, err := nomad.JobStatus("healthcheck")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), status.Version)
+
+	allocs, err := nomad.Allocations("healthcheck")
+	assert.NoError(t, err)
+	assert.Len(t, allocs, 1)
+	assert.Equal(t, "running", allocs[0].ClientStatus)
+
+	_, err = nomad.JobStatus("missing")
+	assert.True(t, IsNomadNotFound(err))
+}
+
+func TestNomadWaitForDeployment(t *testing.T) {
+	polls := 0
+	final := DeploymentSuccessful
+	nomad := newTestNomad(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/job/healthcheck":
+			_, _ = w.Write([]byte(`{"ID":"healthcheck","Version":2}`))
+		case "/v1/job/healthcheck/deployment":
+			polls++
+			switch polls {
+			case 1:
+				_, _ = w.Write([]byte(`null`))
+			case 2:
+				_, _ = w.Write([]byte(`{"ID":"d1","JobVersion":1,"Status":"successful"}`))
+			case 3:
+				_, _ = w.Write([]byte(`{"ID":"d2","JobVersion":2,"Status":"running"}`))
+			default:
+				_, _ = w.Write([]byte(`{"ID":"d2","JobVersion":2,"Status":"` + final + `","StatusDescription":"done"}`))
+			}
+		}
+	})
+
+	deployment, err := nomad.WaitForDeployment(context.Background(), "healthcheck")
+	assert.NoError(t, err)
+	assert.Equal(t, "d2", deployment.ID)
+	assert.Equal(t, 4, polls)
+
+	final = DeploymentFailed
+	_, err = nomad.WaitForDeployment(context.Background(), "healthcheck")
+	var deploymentErr *DeploymentError
+	assert.True(t, errors.As(err, &deploymentErr))
+
+	final = DeploymentRunning
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = nomad.WaitForDeployment(ctx, "healthcheck")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestNomadDrainNode(t *testing.T) {
+	polls := 0
+	var drain, eligibility map[string]interface{}
+	nomad := newTestNomad(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/nodes":
+			_, _ = w.Write([]byte(`[{"ID":"n1","Name":"openpaas-client-1","Status":"ready","SchedulingEligibility":"eligible"}]`))
+		case "/v1/node/n1/eligibility":
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&eligibility))
+		case "/v1/node/n1/drain":
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&drain))
+		case "/v1/node/n1":
+			polls++
+			if polls == 1 {
+				_, _ = w.Write([]byte(`{"ID":"n1","DrainStrategy":{"Deadline":3600000000000}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"ID":"n1","DrainStrategy":null}`))
+		case "/v1/node/n1/allocations":
+			if polls == 2 {
+				_, _ = w.Write([]byte(`[{"ID":"a1","ClientStatus":"running"},{"ID":"a2","ClientStatus":"complete"}]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"ID":"a1","ClientStatus":"complete"},{"ID":"a2","ClientStatus":"complete"}]`))
+		case "/v1/node/n1/purge":
+			assert.Equal(t, http.MethodPost, r.Method)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	nodes, err := nomad.Nodes()
+	assert.NoError(t, err)
+	assert.Equal(t, []Node{{ID: "n1", Name: "openpaas-client-1", Status: "ready", SchedulingEligibility: "eligible"}}, nodes)
+
+	assert.NoError(t, nomad.MarkIneligible("n1"))
+	assert.Equal(t, "ineligible", eligibility["Eligibility"])
+	assert.NoError(t, nomad.DrainNode(context.Background(), "n1", time.Hour))
+	assert.Equal(t, float64(time.Hour.Nanoseconds()), drain["DrainSpec"].(map[string]interface{})["Deadline"])
+	assert.Equal(t, 3, polls)
+	assert.NoError(t, nomad.PurgeNode("n1"))
+
+	polls = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	nomad.pollInterval = time.Hour
+	assert.ErrorIs(t, nomad.DrainNode(ctx, "n1", time.Hour), context.DeadlineExceeded)
+	assert.True(t, IsNomadNotFound(nomad.PurgeNode("n2")))
+}
+
+func TestNomadLeaderAndAutopilotHealth(t *testing.T) {
+	nomad := newTestNomad(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/status/leader":
+			_, _ = w.Write([]byte(`"10.0.1.3:4647"`))
+		case "/v1/operator/autopilot/health":
+			_, _ = w.Write([]byte(`{"Healthy":false,"FailureTolerance":0,"Servers":[{"Name":"srv-nomad-server-1.global","Address":"10.0.1.2:4647","Healthy":false},{"Name":"srv-nomad-server-2.global","Address":"10.0.1.3:4647","Healthy":true,"Leader":true}]}`))
+		}
+	})
+
+	leader, err := nomad.Leader()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.1.3:4647", leader)
+	health, err := nomad.AutopilotHealth()
+	assert.NoError(t, err)
+	assert.False(t, health.Healthy)
+	assert.Len(t, health.Servers, 2)
+	assert.Equal(t, "10.0.1.3", health.LeaderIP())
+}
+
+func TestNomadSnapshotAndRestore(t *testing.T) {
+	var restored string
+	nomad := newTestNomad(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/operator/snapshot", r.URL.Path)
+		if r.Method == http.MethodPut {
+			assert.Equal(t, "application/octet-stream", r.Header.Get("Content-Type"))
+			body, _ := io.ReadAll(r.Body)
+			restored = string(body)
+			return
+		}
+		_, _ = w.Write([]byte("nomad-state"))
+	})
+
+	var snap bytes.Buffer
+	assert.NoError(t, nomad.Snapshot(&snap))
+	assert.Equal(t, "nomad-state", snap.String())
+	assert.NoError(t, nomad.Restore(&snap))
+	assert.Equal(t, "nomad-state", restored)
+}
+
+func TestNomadSnapshotOutlastsTheClientTimeout(t *testing.T) {
+	nomad := newTestNomad(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/nodes" {
+			time.Sleep(100 * time.Millisecond)
+			return
+		}
+		_, _ = w.Write([]byte("nomad-"))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("state"))
+	})
+	nomad.client.Timeout = 50 * time.Millisecond
+
+	var snap bytes.Buffer
+	assert.NoError(t, nomad.Snapshot(&snap))
+	assert.Equal(t, "nomad-state", snap.String())
+	_, err := nomad.Nodes()
+	assert.ErrorContains(t, err, "Client.Timeout exceeded", "other requests keep the timeout")
+}
+
+func TestNomadPutVariable(t *testing.T) {
+	var body map[string]interface{}
+	nomad := newTestNomad(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/v1/var/nomad/jobs/openpaas-backup", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		_, _ = w.Write([]byte(`{"Path":"nomad/jobs/openpaas-backup"}`))
+	})
+
+	assert.NoError(t, nomad.PutVariable("nomad/jobs/openpaas-backup", map[string]string{"passphrase": "secret"}))
+	assert.Equal(t, "nomad/jobs/openpaas-backup", body["Path"])
+	assert.Equal(t, map[string]interface{}{"passphrase": "secret"}, body["Items"])
+}
@@ -1,65 +1,109 @@
 package hashistack
 
 import (
-	"bytes"
-	_ "embed"
 	"fmt"
-	"html/template"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/OpenPaaSDev/openpaas/internal/conf"
-)
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+	"github.com/hashicorp/terraform-exec/tfexec"
 
-//go:embed templates/terraform/hetzner/main.tf
-var hetznerMain string
+	// built-in providers, registered by their init functions
+	_ "github.com/OpenPaaSDev/openpaas/internal/provider/aws"
+	_ "github.com/OpenPaaSDev/openpaas/internal/provider/hetzner"
+	_ "github.com/OpenPaaSDev/openpaas/internal/provider/static"
+)
 
-//go:embed templates/terraform/hetzner/vars.tf
-var hetznerVars string
+// backendConfigFile holds the backend settings, so they are not passed on the command line of terraform.
+// The credentials are not part of them, see BackendEnv.
+const backendConfigFile = "backend.hcl"
 
 func GenerateTerraform(config *conf.Config) error {
-	settings := map[string]struct {
-		Main string
-		Vars string
-	}{
-		"hetzner": {
-			Main: hetznerMain,
-			Vars: hetznerVars,
-		},
-	}
-
-	tfSettings, ok := settings[config.CloudProviderConfig.Provider]
-	if !ok {
-		return fmt.Errorf("%s is not a supported cloud provider", config.CloudProviderConfig.Provider)
-	}
-
-	tmpl, e := template.New("tf-vars").Parse(tfSettings.Vars)
-	if e != nil {
-		return e
-	}
-	var buf bytes.Buffer
-
-	allowedIps := []string{}
-
-	config.CloudProviderConfig.ProviderSettings["https_allowed_ips"] = allowedIps
-
-	err := tmpl.Execute(&buf, config)
+	p, err := provider.For(config)
 	if err != nil {
 		return err
 	}
-	err = os.MkdirAll(filepath.Clean(filepath.Join(config.BaseDir, "terraform")), 0750)
+	folder := filepath.Join(config.BaseDir, "terraform")
+	err = p.RenderTerraform(config, folder)
 	if err != nil {
 		return err
 	}
-	folder := filepath.Join(config.BaseDir, "terraform")
+	return writeBackend(config, folder)
+}
 
-	err = os.WriteFile(filepath.Clean(filepath.Join(folder, "vars.tf")), buf.Bytes(), 0600)
-	if err != nil {
-		return err
+// BackendInitOptions returns the options InitTf needs to initialise the state backend written by
+// GenerateTerraform. Existing state is copied over when the backend changes.
+func BackendInitOptions() []tfexec.InitOption {
+	return []tfexec.InitOption{tfexec.BackendConfig(backendConfigFile), tfexec.ForceCopy(true)}
+}
+
+// BackendEnv returns the environment terraform runs with for config. With an s3 backend and the
+// credentials in S3_ACCESS_KEY and S3_SECRET_KEY, they are passed as AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, which the backend reads, instead of being written to base_dir. It is nil when
+// terraform can run with the environment of openpaas as it is.
+func BackendEnv(config *conf.Config) map[string]string {
+	accessKey, secretKey := os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY")
+	if config.TerraformState.Backend != conf.BackendS3 || accessKey == "" || secretKey == "" {
+		return nil
+	}
+	env := map[string]string{}
+	for _, v := range os.Environ() {
+		if key, value, ok := strings.Cut(v, "="); ok {
+			env[key] = value
+		}
 	}
-	err = os.WriteFile(filepath.Clean(filepath.Join(folder, "main.tf")), []byte(hetznerMain), 0600)
+	// the variables terraform-exec sets itself
+	for _, key := range tfexec.ProhibitedEnv(env) {
+		delete(env, key)
+	}
+	env["AWS_ACCESS_KEY_ID"] = accessKey
+	env["AWS_SECRET_ACCESS_KEY"] = secretKey
+	return env
+}
+
+func writeBackend(config *conf.Config, folder string) error {
+	state := config.TerraformState
+	var settings strings.Builder
+	set := func(key, value string) {
+		settings.WriteString(fmt.Sprintf("%s = %q\n", key, value))
+	}
+	switch state.Backend {
+	case "", conf.BackendLocal:
+		state.Backend = conf.BackendLocal
+		if state.Path == "" {
+			state.Path = "terraform.tfstate"
+		}
+		set("path", state.Path)
+	case conf.BackendS3:
+		if state.Bucket == "" {
+			return fmt.Errorf("terraform_state: the s3 backend needs a bucket")
+		}
+		if state.Region == "" {
+			state.Region = "us-east-1"
+		}
+		set("bucket", state.Bucket)
+		set("key", path.Join(state.KeyPrefix, config.DC, "terraform.tfstate"))
+		set("region", state.Region)
+		if state.LockTable != "" {
+			set("dynamodb_table", state.LockTable)
+		}
+		if state.Endpoint != "" {
+			// S3 compatible stores have their own regions and no AWS account to validate credentials against
+			set("endpoint", state.Endpoint)
+			settings.WriteString("force_path_style = true\nskip_region_validation = true\n")
+			settings.WriteString("skip_credentials_validation = true\nskip_metadata_api_check = true\n")
+		}
+	default:
+		return fmt.Errorf("terraform_state: %s is not a supported backend, use %s or %s", state.Backend, conf.BackendLocal, conf.BackendS3)
+	}
+
+	backend := fmt.Sprintf("terraform {\n  backend %q {}\n}\n", state.Backend)
+	err := os.WriteFile(filepath.Clean(filepath.Join(folder, "backend.tf")), []byte(backend), 0600)
 	if err != nil {
 		return err
 	}
-	return nil
+	return os.WriteFile(filepath.Clean(filepath.Join(folder, backendConfigFile)), []byte(settings.String()), 0600)
 }
@@ -90,3 +90,141 @@ func TestGenerateTerraform(t *testing.T) {
 
 	assert.Equal(t, len(expectedMap), len(vars))
 }
+
+func TestGenerateTerraformAWS(t *testing.T) {
+	config, err := conf.Load("../testdata/config-aws.yaml")
+	assert.NoError(t, err)
+
+	folder := util.RandString(8)
+	config.BaseDir = folder
+	defer func() {
+		e := os.RemoveAll(filepath.Clean(folder))
+		assert.NoError(t, e)
+	}()
+
+	err = GenerateTerraform(config)
+	assert.NoError(t, err)
+
+	parser := hclparse.NewParser()
+	f, parseDiags := parser.ParseHCLFile(filepath.Clean(filepath.Join(folder, "terraform", "vars.tf")))
+	assert.False(t, parseDiags.HasErrors())
+
+	mainFile, parseDiags := parser.ParseHCLFile(filepath.Clean(filepath.Join(folder, "terraform", "main.tf")))
+	assert.False(t, parseDiags.HasErrors())
+
+	// the outputs are what ansible.GenerateInventory reads, so they must match the other providers
+	content, _ := mainFile.Body.Content(&hcl.BodySchema{Blocks: []hcl.BlockHeaderSchema{
+		{Type: "terraform"}, {Type: "provider", LabelNames: []string{"name"}},
+		{Type: "data", LabelNames: []string{"type", "name"}}, {Type: "locals"},
+		{Type: "resource", LabelNames: []string{"type", "name"}}, {Type: "output", LabelNames: []string{"name"}},
+	}})
+	outputs := []string{}
+	for _, block := range content.Blocks.OfType("output") {
+		outputs = append(outputs, block.Labels[0])
+	}
+	assert.ElementsMatch(t, []string{"consul_servers", "nomad_servers", "vault_servers", "client_servers", "o11y_servers", "consul_volumes", "client_volumes"}, outputs)
+
+	var conf tfConfig
+	decodeDiags := gohcl.DecodeBody(f.Body, nil, &conf)
+	assert.False(t, decodeDiags.HasErrors())
+
+	vars := map[string]cty.Value{
+		"region":        cty.StringVal("eu-central-1"),
+		"vpc_cidr":      cty.StringVal("10.0.0.0/16"),
+		"key_pair_name": cty.StringVal("openpaas"),
+		"subnets": cty.ObjectVal(map[string]cty.Value{
+			"consul":        cty.StringVal("10.0.0.0/24"),
+			"nomad_servers": cty.StringVal("10.0.1.0/24"),
+			"vault":         cty.StringVal("10.0.2.0/24"),
+			"clients":       cty.StringVal("10.0.3.0/24"),
+			"observability": cty.StringVal("10.0.4.0/24"),
+		}),
+		"server_instance_type": cty.StringVal("t3.small"),
+		"client_instance_type": cty.StringVal("t3.medium"),
+		"base_server_name":     cty.StringVal("nomad-srv"),
+		"allow_ips":            cty.TupleVal([]cty.Value{cty.StringVal("85.4.84.201/32")}),
+	}
+	found := 0
+	for _, v := range conf.Variable {
+		assert.NotEqual(t, "hcloud_token", v.Name)
+		if expected, ok := vars[v.Name]; ok {
+			found++
+			assert.Equal(t, expected, *v.Default)
+		}
+	}
+	assert.Equal(t, len(vars), found)
+}
+
+func TestGenerateTerraformBackend(t *testing.T) {
+	config, err := conf.Load("../testdata/config.yaml")
+	assert.NoError(t, err)
+
+	folder := util.RandString(8)
+	config.BaseDir = folder
+	defer func() {
+		e := os.RemoveAll(filepath.Clean(folder))
+		assert.NoError(t, e)
+	}()
+
+	backendAttrs := func() (string, map[string]cty.Value) {
+		parser := hclparse.NewParser()
+		f, diags := parser.ParseHCLFile(filepath.Join(folder, "terraform", "backend.tf"))
+		assert.False(t, diags.HasErrors())
+		content, _ := f.Body.Content(&hcl.BodySchema{Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}}})
+		inner, _ := content.Blocks[0].Body.Content(&hcl.BodySchema{Blocks: []hcl.BlockHeaderSchema{{Type: "backend", LabelNames: []string{"type"}}}})
+
+		cfg, diags := parser.ParseHCLFile(filepath.Join(folder, "terraform", "backend.hcl"))
+		assert.False(t, diags.HasErrors())
+		attrs, _ := cfg.Body.JustAttributes()
+		values := map[string]cty.Value{}
+		for name, attr := range attrs {
+			values[name], _ = attr.Expr.Value(nil)
+		}
+		return inner.Blocks[0].Labels[0], values
+	}
+
+	err = GenerateTerraform(config)
+	assert.NoError(t, err)
+	backend, values := backendAttrs()
+	assert.Equal(t, "local", backend)
+	assert.Equal(t, map[string]cty.Value{"path": cty.StringVal("terraform.tfstate")}, values)
+	assert.Nil(t, BackendEnv(config))
+
+	t.Setenv("S3_ACCESS_KEY", "access")
+	t.Setenv("S3_SECRET_KEY", "secret")
+	config.TerraformState = conf.TerraformState{
+		Backend:   "s3",
+		Bucket:    "state",
+		KeyPrefix: "openpaas",
+		Endpoint:  "https://account.r2.cloudflarestorage.com",
+		LockTable: "locks",
+	}
+	err = GenerateTerraform(config)
+	assert.NoError(t, err)
+	backend, values = backendAttrs()
+	assert.Equal(t, "s3", backend)
+	assert.Equal(t, cty.StringVal("state"), values["bucket"])
+	assert.Equal(t, cty.StringVal("openpaas/hetzner/terraform.tfstate"), values["key"])
+	assert.Equal(t, cty.StringVal("us-east-1"), values["region"])
+	assert.Equal(t, cty.StringVal("locks"), values["dynamodb_table"])
+	assert.Equal(t, cty.True, values["force_path_style"])
+	assert.NotContains(t, values, "access_key")
+	assert.NotContains(t, values, "secret_key")
+	b, err := os.ReadFile(filepath.Join(folder, "terraform", "backend.hcl"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "secret")
+
+	env := BackendEnv(config)
+	assert.Equal(t, "access", env["AWS_ACCESS_KEY_ID"])
+	assert.Equal(t, "secret", env["AWS_SECRET_ACCESS_KEY"])
+	assert.Equal(t, os.Getenv("PATH"), env["PATH"])
+	t.Setenv("TF_LOG", "debug")
+	assert.NotContains(t, BackendEnv(config), "TF_LOG")
+	t.Setenv("S3_SECRET_KEY", "")
+	assert.Nil(t, BackendEnv(config))
+
+	config.TerraformState = conf.TerraformState{Backend: "s3"}
+	assert.Error(t, GenerateTerraform(config))
+	config.TerraformState = conf.TerraformState{Backend: "consul"}
+	assert.Error(t, GenerateTerraform(config))
+}
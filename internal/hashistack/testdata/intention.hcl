@@ -0,0 +1,8 @@
+Kind = "service-intentions"
+Name = "grafana"
+Sources = [
+  {
+    Name   = "*"
+    Action = "allow"
+  }
+]
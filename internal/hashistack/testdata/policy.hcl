@@ -0,0 +1,3 @@
+node_prefix "" {
+  policy = "write"
+}
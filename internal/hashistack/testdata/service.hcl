@@ -0,0 +1,20 @@
+service {
+  name = "grafana"
+  address = "10.0.4.2"
+  port = 3000
+  check = {
+    id = "grafana"
+    http = "http://10.0.4.2:3000/login"
+    method = "GET"
+    disable_redirects = true
+    interval = "20s"
+    timeout = "1s"
+  }
+  tagged_addresses {
+    lan = {
+      address = "10.0.4.2"
+      port = 3000
+    }
+  }
+  tags = ["urlprefix-grafana.example.com/"]
+}
@@ -0,0 +1,232 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+)
+
+// Client is the subset of the Vault HTTP API used to initialise and configure a cluster.
+// Seal operations take the host to talk to, as each Vault server has to be unsealed individually,
+// while configuration calls go to the host the client was created for.
+type Client interface {
+	Status(host string) (*SealStatus, error)
+	Init(host string, req InitRequest) (*InitResponse, error)
+	Unseal(host, key string) (*SealStatus, error)
+	EnableSecretsEngine(path, engineType string, options map[string]string) error
+	WritePolicy(name, rules string) error
+	CreateOrphanToken(req TokenRequest) (string, error)
+	LookupToken(token string) error
+	WriteTokenRole(name string, role []byte) error
+	// Health reports whether host is unsealed and whether it is the active server or a standby.
+	Health(host string) (*Health, error)
+}
+
+type SealStatus struct {
+	Type        string `json:"type"`
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Threshold   int    `json:"t"`
+	Shares      int    `json:"n"`
+	Progress    int    `json:"progress"`
+	Version     string `json:"version"`
+}
+
+type Health struct {
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Standby     bool   `json:"standby"`
+	Version     string `json:"version"`
+}
+
+// InitRequest initializes vault with unseal key shares, or with recovery key shares when it is
+// auto-unsealed.
+type InitRequest struct {
+	SecretShares      int      `json:"secret_shares,omitempty"`
+	SecretThreshold   int      `json:"secret_threshold,omitempty"`
+	PGPKeys           []string `json:"pgp_keys,omitempty"`
+	RecoveryShares    int      `json:"recovery_shares,omitempty"`
+	RecoveryThreshold int      `json:"recovery_threshold,omitempty"`
+	RecoveryPGPKeys   []string `json:"recovery_pgp_keys,omitempty"`
+}
+
+type InitResponse struct {
+	Keys               []string `json:"keys"`
+	KeysBase64         []string `json:"keys_base64"`
+	RecoveryKeysBase64 []string `json:"recovery_keys_base64"`
+	RootToken          string   `json:"root_token"`
+}
+
+type TokenRequest struct {
+	Policies []string `json:"policies"`
+	Period   string   `json:"period,omitempty"`
+}
+
+// APIError is returned when the Vault HTTP API responds with a non-2xx status.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Errors     []string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("vault: %s %s returned %d: %s", e.Method, e.Path, e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// IsAlreadyMounted reports whether err is Vault rejecting a secrets engine mount because the path is in use.
+func IsAlreadyMounted(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	for _, e := range apiErr.Errors {
+		if strings.Contains(e, "path is already in use") {
+			return true
+		}
+	}
+	return false
+}
+
+type apiClient struct {
+	host       string
+	addrFormat string
+	client     *http.Client
+	secrets    *secrets.Config
+	log        *logging.Logger
+}
+
+// NewClient returns a Vault client for host, trusting the self-signed certificate in
+// baseDir/secrets/vault and authenticating with the root token held in sec.
+func NewClient(baseDir, host string, sec *secrets.Config, log *logging.Logger) (Client, error) {
+	client, err := util.NewTLSClient(filepath.Join(baseDir, "secrets", "vault", "tls.crt"), "", "")
+	if err != nil {
+		return nil, err
+	}
+	return &apiClient{host: host, addrFormat: "https://%s:8200", client: client, secrets: sec, log: log}, nil
+}
+
+func (c *apiClient) Status(host string) (*SealStatus, error) {
+	var status SealStatus
+	err := c.do(host, http.MethodGet, "/v1/sys/seal-status", nil, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Health asks vault to answer with 200 in every state, which it otherwise reports in the status code.
+func (c *apiClient) Health(host string) (*Health, error) {
+	var health Health
+	err := c.do(host, http.MethodGet, "/v1/sys/health?standbyok=true&perfstandbyok=true&sealedcode=200&uninitcode=200", nil, &health)
+	if err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+func (c *apiClient) Init(host string, req InitRequest) (*InitResponse, error) {
+	var res InitResponse
+	err := c.do(host, http.MethodPut, "/v1/sys/init", req, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *apiClient) Unseal(host, key string) (*SealStatus, error) {
+	var status SealStatus
+	err := c.do(host, http.MethodPut, "/v1/sys/unseal", map[string]string{"key": key}, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *apiClient) EnableSecretsEngine(path, engineType string, options map[string]string) error {
+	body := map[string]interface{}{"type": engineType, "options": options}
+	return c.do(c.host, http.MethodPost, "/v1/sys/mounts/"+strings.Trim(path, "/"), body, nil)
+}
+
+func (c *apiClient) WritePolicy(name, rules string) error {
+	return c.do(c.host, http.MethodPut, "/v1/sys/policies/acl/"+name, map[string]string{"policy": rules}, nil)
+}
+
+func (c *apiClient) CreateOrphanToken(req TokenRequest) (string, error) {
+	var res struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	err := c.do(c.host, http.MethodPost, "/v1/auth/token/create-orphan", req, &res)
+	if err != nil {
+		return "", err
+	}
+	return res.Auth.ClientToken, nil
+}
+
+func (c *apiClient) LookupToken(token string) error {
+	return c.do(c.host, http.MethodPost, "/v1/auth/token/lookup", map[string]string{"token": token}, nil)
+}
+
+func (c *apiClient) WriteTokenRole(name string, role []byte) error {
+	return c.do(c.host, http.MethodPost, "/v1/auth/token/roles/"+name, json.RawMessage(role), nil)
+}
+
+func (c *apiClient) do(host, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf(c.addrFormat, host)+path, body)
+	if err != nil {
+		return err
+	}
+	if c.secrets != nil && c.secrets.VaultConfig.RootToken != "" {
+		req.Header.Set("X-Vault-Token", c.secrets.VaultConfig.RootToken)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+	c.log.Debug("vault api request", "host", host, "method", method, "path", path, "status", resp.StatusCode, "duration", time.Since(start).Round(time.Millisecond))
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		var errBody struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(respBody, &errBody) == nil {
+			apiErr.Errors = errBody.Errors
+		}
+		return apiErr
+	}
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
@@ -0,0 +1,109 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (Client, string) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+	sec := &secrets.Config{VaultConfig: secrets.VaultSecrets{RootToken: "root"}}
+	return &apiClient{host: host, addrFormat: "http://%s", client: server.Client(), secrets: sec}, host
+}
+
+func TestClientSealLifecycle(t *testing.T) {
+	var unsealKeys []string
+	client, host := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/seal-status":
+			_, _ = w.Write([]byte(`{"type":"shamir","initialized":true,"sealed":true,"t":3,"n":5,"progress":0}`))
+		case "/v1/sys/init":
+			var req InitRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, InitRequest{SecretShares: 5, SecretThreshold: 3}, req)
+			_, _ = w.Write([]byte(`{"keys":["a"],"keys_base64":["b"],"root_token":"s.root"}`))
+		case "/v1/sys/unseal":
+			var req map[string]string
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			unsealKeys = append(unsealKeys, req["key"])
+			_, _ = w.Write([]byte(`{"sealed":false,"t":3,"n":5,"progress":0}`))
+		}
+	})
+
+	status, err := client.Status(host)
+	assert.NoError(t, err)
+	assert.True(t, status.Initialized)
+	assert.True(t, status.Sealed)
+	assert.Equal(t, 3, status.Threshold)
+
+	res, err := client.Init(host, InitRequest{SecretShares: 5, SecretThreshold: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, "s.root", res.RootToken)
+	assert.Equal(t, []string{"b"}, res.KeysBase64)
+
+	status, err = client.Unseal(host, "key-1")
+	assert.NoError(t, err)
+	assert.False(t, status.Sealed)
+	assert.Equal(t, []string{"key-1"}, unsealKeys)
+}
+
+func TestClientConfiguration(t *testing.T) {
+	requests := map[string]map[string]interface{}{}
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "root", r.Header.Get("X-Vault-Token"))
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests[r.URL.Path] = body
+		switch r.URL.Path {
+		case "/v1/sys/mounts/secret":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["path is already in use at secret/"]}`))
+		case "/v1/auth/token/create-orphan":
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"s.nomad"}}`))
+		case "/v1/auth/token/lookup":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		}
+	})
+
+	err := client.EnableSecretsEngine("secret/", "kv", map[string]string{"version": "2"})
+	assert.True(t, IsAlreadyMounted(err))
+
+	assert.NoError(t, client.WritePolicy("nomad-server", "path \"x\" {}"))
+	assert.Equal(t, "path \"x\" {}", requests["/v1/sys/policies/acl/nomad-server"]["policy"])
+
+	token, err := client.CreateOrphanToken(TokenRequest{Policies: []string{"nomad-server"}, Period: "72h"})
+	assert.NoError(t, err)
+	assert.Equal(t, "s.nomad", token)
+	assert.Equal(t, "72h", requests["/v1/auth/token/create-orphan"]["period"])
+
+	err = client.LookupToken("s.nomad")
+	assert.Error(t, err)
+	apiErr, ok := err.(*APIError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+
+	assert.NoError(t, client.WriteTokenRole("nomad-cluster", []byte(vaultTokenRole)))
+	assert.Equal(t, "nomad-server", requests["/v1/auth/token/roles/nomad-cluster"]["disallowed_policies"])
+}
+
+func TestClientHealth(t *testing.T) {
+	client, host := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/sys/health", r.URL.Path)
+		assert.Equal(t, "200", r.URL.Query().Get("sealedcode"))
+		assert.Equal(t, "true", r.URL.Query().Get("standbyok"))
+		_, _ = w.Write([]byte(`{"initialized":true,"sealed":false,"standby":true,"version":"1.15.2"}`))
+	})
+
+	health, err := client.Health(host)
+	assert.NoError(t, err)
+	assert.Equal(t, &Health{Initialized: true, Standby: true, Version: "1.15.2"}, health)
+}
@@ -0,0 +1,79 @@
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+)
+
+// SealTokenEnv holds the token an auto-unsealing vault authenticates against the transit seal with.
+const SealTokenEnv = "VAULT_SEAL_TOKEN"
+
+// sealSettings are the settings every supported seal needs. Credentials of the KMS seals come from the
+// environment or instance identity of the vault servers.
+var sealSettings = map[string][]string{
+	"transit":       {"address", "key_name", "mount_path"},
+	"awskms":        {"region", "kms_key_id"},
+	"gcpckms":       {"project", "region", "key_ring", "crypto_key"},
+	"azurekeyvault": {"tenant_id", "vault_name", "key_name"},
+}
+
+// SealStanza renders the seal stanza of the vault config for seal, or nothing if vault is unsealed
+// with unseal keys. The transit token is left to ansible to fill in from the secrets.
+func SealStanza(seal conf.AutoUnseal) (string, error) {
+	if seal.Seal == "" {
+		return "", nil
+	}
+	required, ok := sealSettings[seal.Seal]
+	if !ok {
+		types := []string{}
+		for t := range sealSettings {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return "", fmt.Errorf("auto_unseal: %s is not a supported seal, expected one of %s", seal.Seal, strings.Join(types, ", "))
+	}
+	missing := []string{}
+	for _, key := range required {
+		if seal.Settings[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("auto_unseal: the %s seal is missing required settings: %s", seal.Seal, strings.Join(missing, ", "))
+	}
+
+	keys := []string{}
+	for key := range seal.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var stanza strings.Builder
+	stanza.WriteString(fmt.Sprintf("\nseal %q {\n", seal.Seal))
+	for _, key := range keys {
+		stanza.WriteString(fmt.Sprintf("  %s = %q\n", key, seal.Settings[key]))
+	}
+	if seal.Seal == "transit" {
+		stanza.WriteString("  token = \"{{ vault.seal_token }}\"\n")
+	}
+	stanza.WriteString("}\n")
+	return stanza.String(), nil
+}
+
+// PrepareSeal stores the transit token from the environment in the secrets, for ansible to render
+// into the vault config. A token stored by an earlier sync is kept if none is set.
+func PrepareSeal(seal conf.AutoUnseal, sec *secrets.Config, getenv func(string) string) error {
+	if seal.Seal != "transit" {
+		return nil
+	}
+	if token := getenv(SealTokenEnv); token != "" {
+		sec.VaultConfig.SealToken = token
+	}
+	if sec.VaultConfig.SealToken == "" {
+		return fmt.Errorf("auto_unseal: the transit seal needs a token, please set %s", SealTokenEnv)
+	}
+	return nil
+}
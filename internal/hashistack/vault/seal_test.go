@@ -0,0 +1,62 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealStanza(t *testing.T) {
+	stanza, err := SealStanza(conf.AutoUnseal{})
+	assert.NoError(t, err)
+	assert.Empty(t, stanza)
+
+	stanza, err = SealStanza(conf.AutoUnseal{Seal: "transit", Settings: map[string]string{
+		"address":    "https://vault.example.com:8200",
+		"key_name":   "autounseal",
+		"mount_path": "transit/",
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, `
+seal "transit" {
+  address = "https://vault.example.com:8200"
+  key_name = "autounseal"
+  mount_path = "transit/"
+  token = "{{ vault.seal_token }}"
+}
+`, stanza)
+
+	stanza, err = SealStanza(conf.AutoUnseal{Seal: "awskms", Settings: map[string]string{"region": "eu-west-1", "kms_key_id": "alias/vault"}})
+	assert.NoError(t, err)
+	assert.Contains(t, stanza, `kms_key_id = "alias/vault"`)
+	assert.NotContains(t, stanza, "token")
+
+	_, err = SealStanza(conf.AutoUnseal{Seal: "gcpckms", Settings: map[string]string{"project": "p"}})
+	assert.EqualError(t, err, "auto_unseal: the gcpckms seal is missing required settings: region, key_ring, crypto_key")
+	_, err = SealStanza(conf.AutoUnseal{Seal: "pkcs11"})
+	assert.Error(t, err)
+}
+
+func TestPrepareSeal(t *testing.T) {
+	transit := conf.AutoUnseal{Seal: "transit"}
+	sec := &secrets.Config{}
+	noEnv := func(string) string { return "" }
+
+	assert.NoError(t, PrepareSeal(conf.AutoUnseal{Seal: "awskms"}, sec, noEnv))
+	assert.Error(t, PrepareSeal(transit, sec, noEnv))
+
+	assert.NoError(t, PrepareSeal(transit, sec, func(key string) string { return map[string]string{SealTokenEnv: "s.token"}[key] }))
+	assert.Equal(t, "s.token", sec.VaultConfig.SealToken)
+	assert.NoError(t, PrepareSeal(transit, sec, noEnv), "the stored token must be kept")
+}
+
+func TestSealSchemaListsSupportedSeals(t *testing.T) {
+	seals := []interface{}{}
+	for seal := range sealSettings {
+		seals = append(seals, seal)
+	}
+	enum := conf.JSONSchema().Properties["cluster_config"].Properties["auto_unseal"].Properties["seal"].Enum
+	assert.ElementsMatch(t, seals, enum)
+}
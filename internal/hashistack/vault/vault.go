@@ -1,19 +1,21 @@
 package vault
 
 import (
-	"bytes"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	_ "embed"
 
 	"github.com/OpenPaaSDev/openpaas/internal/ansible"
 	"github.com/OpenPaaSDev/openpaas/internal/conf"
-	"github.com/OpenPaaSDev/openpaas/internal/runtime"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/pki"
 	"github.com/OpenPaaSDev/openpaas/internal/secrets"
 )
 
@@ -23,6 +25,16 @@ var vaultServerPolicy string
 //go:embed templates/vault/token-role.json
 var vaultTokenRole string
 
+var ErrSealed = errors.New("vault is sealed")
+
+// ShareSource returns the next unseal key share for host. It is asked for shares only until vault
+// reports the host unsealed, and the shares it returned are reused for the other hosts.
+type ShareSource func(host string, status *SealStatus) (string, error)
+
+// TLSValidity is how long the certificate of the vault servers is valid.
+const TLSValidity = 365 * 24 * time.Hour
+
+// GenerateTLS creates the self-signed certificate of the vault servers, unless it exists.
 func GenerateTLS(config *conf.Config, inventory *ansible.Inventory) error {
 	outputDir := filepath.Join(config.BaseDir, "secrets", "vault")
 	if _, err := os.Stat(filepath.Join(outputDir, "tls.key")); errors.Is(err, os.ErrNotExist) {
@@ -30,164 +42,299 @@ func GenerateTLS(config *conf.Config, inventory *ansible.Inventory) error {
 		if err != nil {
 			return err
 		}
-
-		hosts := inventory.All.Children.VaultServers.GetPrivateHosts()
-		dnsEntries := []string{}
-		for _, host := range hosts {
-			dnsEntries = append(dnsEntries, fmt.Sprintf("DNS:%s", host))
+		subject := pkix.Name{CommonName: "Vault"}
+		if config.OrgName != "" {
+			subject.Organization = []string{config.OrgName}
 		}
-
-		keyFile := filepath.Join(outputDir, "tls.key")
-		crtFile := filepath.Join(outputDir, "tls.crt")
-		dns := strings.Join(dnsEntries, ",")
-
-		cmd := fmt.Sprintf(`openssl req -out %s -new -keyout %s -newkey rsa:4096 -nodes -sha256 -x509 -subj "/O=%s/CN=Vault" -addext "subjectAltName = IP:0.0.0.0,DNS:vault.service.consul,DNS:active.vault.service.consul,%s"`, crtFile, keyFile, config.OrgName, dns)
-		fmt.Println(dns)
-		fmt.Println(cmd)
-		return runtime.Exec(&runtime.EmptyEnv{}, cmd, os.Stdout)
+		cert, err := pki.SelfSign(pki.Request{Subject: subject, Hosts: tlsHosts(inventory), Validity: TLSValidity})
+		if err != nil {
+			return err
+		}
+		return cert.Write(filepath.Join(outputDir, "tls.crt"), filepath.Join(outputDir, "tls.key"))
 	}
 	return nil
 }
 
-func Init(config *conf.Config, inventory *ansible.Inventory, sec *secrets.Config) error {
+// RotateTLS reissues the certificate of the vault servers for the vault servers of the inventory. Its
+// key and subject are kept, so the previous certificate and the new one trust each other while the new
+// one is rolled out.
+func RotateTLS(config *conf.Config, inventory *ansible.Inventory) error {
 	outputDir := filepath.Join(config.BaseDir, "secrets", "vault")
-	initFile := filepath.Join(outputDir, "init.txt")
+	previous, err := pki.Load(filepath.Join(outputDir, "tls.crt"), filepath.Join(outputDir, "tls.key"))
+	if err != nil {
+		return err
+	}
+	cert, err := previous.Renew(pki.Request{Hosts: tlsHosts(inventory), Validity: TLSValidity})
+	if err != nil {
+		return err
+	}
+	return cert.Write(filepath.Join(outputDir, "tls.crt"), filepath.Join(outputDir, "tls.key"))
+}
+
+// tlsHosts are the names and addresses the certificate of the vault servers is issued for.
+func tlsHosts(inventory *ansible.Inventory) []string {
+	return append([]string{"0.0.0.0", "vault.service.consul", "active.vault.service.consul"}, inventory.All.Children.VaultServers.GetPrivateHosts()...)
+}
+
+func Init(config *conf.Config, inventory *ansible.Inventory, sec *secrets.Config, log *logging.Logger) error {
+	client, vaultHosts, err := newClient(config, inventory, sec, log)
+	if err != nil {
+		return err
+	}
+	return initVault(client, config.BaseDir, vaultHosts, sec, config.VaultConfig, config.ClusterConfig.AutoUnseal.Seal != "")
+}
+
+// Unseal unseals every vault server with the shares returned by next.
+func Unseal(config *conf.Config, inventory *ansible.Inventory, sec *secrets.Config, next ShareSource, log *logging.Logger) error {
+	if seal := config.ClusterConfig.AutoUnseal.Seal; seal != "" {
+		return fmt.Errorf("vault unseals itself with its %s seal, recovery keys cannot unseal it", seal)
+	}
+	client, vaultHosts, err := newClient(config, inventory, sec, log)
+	if err != nil {
+		return err
+	}
+	return UnsealWith(client, vaultHosts, next)
+}
+
+func newClient(config *conf.Config, inventory *ansible.Inventory, sec *secrets.Config, log *logging.Logger) (Client, []string, error) {
 	vaultHosts := inventory.All.Children.VaultServers.GetHosts()
+	if len(vaultHosts) == 0 {
+		return nil, nil, fmt.Errorf("no vault servers found in inventory")
+	}
+	sort.Strings(vaultHosts)
+	client, err := NewClient(config.BaseDir, vaultHosts[0], sec, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, vaultHosts, nil
+}
+
+// initVault initializes, unseals and configures vault. Auto-unsealing vaults are initialized with
+// recovery keys instead of unseal keys, and unseal themselves.
+func initVault(client Client, baseDir string, vaultHosts []string, sec *secrets.Config, vaultConfig conf.VaultConfig, autoUnseal bool) error {
+	status, err := client.Status(vaultHosts[0])
+	if err != nil {
+		return err
+	}
 
-	toCopy := map[string]string{
-		filepath.Join(config.BaseDir, "vault", "nomad-server-policy.hcl"): vaultServerPolicy,
-		filepath.Join(config.BaseDir, "vault", "token-role.json"):         vaultTokenRole,
+	unsealAll := func() error {
+		return unseal(client, vaultHosts, sec)
 	}
-	var err error
-	for k, v := range toCopy {
-		err = os.WriteFile(k, []byte(v), 0600)
-		if err != nil {
-			return err
+	if autoUnseal {
+		unsealAll = func() error {
+			return waitUnsealed(client, vaultHosts)
 		}
 	}
 
-	if _, e := os.Stat(filepath.Clean(initFile)); errors.Is(e, os.ErrNotExist) {
-
-		sec, err = initVault(config.BaseDir, initFile, vaultHosts, sec)
+	if !status.Initialized {
+		req, holders, e := initRequest(vaultConfig, autoUnseal)
+		if e != nil {
+			return e
+		}
+		res, e := client.Init(vaultHosts[0], req)
+		if e != nil {
+			return e
+		}
+		keys := res.KeysBase64
+		sec.VaultConfig = secrets.VaultSecrets{
+			UnsealKeys: keys,
+			RootToken:  res.RootToken,
+			SealToken:  sec.VaultConfig.SealToken,
+		}
+		sharesDir := "unseal-shares"
+		if autoUnseal {
+			keys = res.RecoveryKeysBase64
+			sec.VaultConfig.UnsealKeys = []string{}
+			sec.VaultConfig.RecoveryKeys = keys
+			sharesDir = "recovery-shares"
+		}
+		if len(holders) > 0 {
+			// each share is encrypted to its holder, openpaas keeps none of them
+			sec.VaultConfig.UnsealKeys = []string{}
+			sec.VaultConfig.RecoveryKeys = nil
+			e = writeShares(filepath.Join(baseDir, "secrets", "vault", sharesDir), holders, keys)
+			if e != nil {
+				return e
+			}
+		}
+		// persist the keys before doing anything else, they cannot be retrieved again
+		e = sec.Write(baseDir)
+		if e != nil {
+			return e
+		}
+	} else if sec.VaultConfig.RootToken == "" {
+		initFile := filepath.Join(baseDir, "secrets", "vault", "init.txt")
+		if _, e := os.Stat(filepath.Clean(initFile)); e != nil {
+			return fmt.Errorf("vault is initialized, but no root token is present in secrets")
+		}
+		sec, err = parseVaultInit(initFile, sec)
 		if err != nil {
 			return err
 		}
-	}
-	if sec.VaultConfig.RootToken == "" {
-		sec, err = parseVaultInit(initFile, sec)
+		// the keys now live in the secrets, which can be encrypted, so the plaintext init output can go
+		err = sec.Write(baseDir)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(filepath.Clean(initFile))
 		if err != nil {
 			return err
 		}
 	}
 
-	err = unseal(config.BaseDir, vaultHosts, sec, false)
+	err = unsealAll()
 	if err != nil {
 		return err
 	}
-	return sec.Write(config.BaseDir)
-}
-
-func initVault(baseDir, initFile string, vaultHosts []string, secrets *secrets.Config) (*secrets.Config, error) {
-	envVars := fmt.Sprintf("export VAULT_SKIP_VERIFY=true && export VAULT_ADDR=https://%s:8200 && ", vaultHosts[0])
-	err := runtime.Exec(&runtime.EmptyEnv{}, fmt.Sprintf("%s vault operator init > %s", envVars, initFile), os.Stdout)
-	if err != nil {
-		return nil, err
-	}
-	secrets, err = parseVaultInit(initFile, secrets)
-	if err != nil {
-		return nil, err
-	}
-	err = unseal(baseDir, vaultHosts, secrets, true)
-	if err != nil {
-		return nil, err
+	// on every sync, as vault may not have been unsealed by the sync that initialized it
+	err = client.EnableSecretsEngine("secret", "kv", map[string]string{"version": "2"})
+	if err != nil && !IsAlreadyMounted(err) {
+		return err
 	}
-	err = enableSecrets(vaultHosts, secrets)
+	err = configure(client, sec)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return sec.Write(baseDir)
+}
 
-	return secrets, nil
+// unseal unseals every vault server with the unseal keys kept in the secrets.
+func unseal(client Client, vaultHosts []string, sec *secrets.Config) error {
+	keys := sec.VaultConfig.UnsealKeys
+	return UnsealWith(client, vaultHosts, func(host string, status *SealStatus) (string, error) {
+		if len(keys) == 0 {
+			return "", fmt.Errorf("%w: %s is still sealed after applying all unseal keys (progress %d/%d), run `openpaas vault unseal`", ErrSealed, host, status.Progress, status.Threshold)
+		}
+		key := keys[0]
+		keys = keys[1:]
+		return key, nil
+	})
 }
 
-func enableSecrets(vaultHosts []string, secrets *secrets.Config) error {
-	envVars := fmt.Sprintf("export VAULT_SKIP_VERIFY=true && export VAULT_ADDR=https://%s:8200 && ", vaultHosts[0])
-	err := runtime.Exec(&runtime.EmptyEnv{}, fmt.Sprintf("%s vault login %s && vault secrets enable -path=secret/ kv-v2", envVars, secrets.VaultConfig.RootToken), os.Stdout)
-	if err != nil {
-		fmt.Println("Error enabling secrets: " + fmt.Sprintf(" %v", err))
+// autoUnsealTimeout is how long auto-unsealing servers get to unseal themselves.
+var autoUnsealTimeout = time.Minute
+
+func waitUnsealed(client Client, vaultHosts []string) error {
+	deadline := time.Now().Add(autoUnsealTimeout)
+	for _, host := range vaultHosts {
+		for {
+			status, err := client.Status(host)
+			if err != nil {
+				return err
+			}
+			if !status.Sealed {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%w: %s did not unseal itself with its %s seal", ErrSealed, host, status.Type)
+			}
+			time.Sleep(2 * time.Second)
+		}
 	}
-	return err
+	return nil
 }
 
-func unseal(baseDir string, vaultHosts []string, secrets *secrets.Config, init bool) error {
+// UnsealWith unseals the sealed servers of vaultHosts, asking next for shares until they are unsealed.
+// Every share is used on every server.
+func UnsealWith(client Client, vaultHosts []string, next ShareSource) error {
+	shares := []string{}
 	for _, host := range vaultHosts {
-
-		var out bytes.Buffer
-		envVars := fmt.Sprintf("export VAULT_SKIP_VERIFY=true && export VAULT_ADDR=https://%s:8200 && ", host)
-		err := runtime.Exec(&runtime.EmptyEnv{}, fmt.Sprintf("%s vault status", envVars), &out)
+		status, err := client.Status(host)
 		if err != nil {
-			fmt.Println("vault not unsealed")
-		}
-		if !isVaultUnsealed(out.Bytes()) {
-			for _, key := range secrets.VaultConfig.UnsealKeys {
-				envVars := fmt.Sprintf("export VAULT_SKIP_VERIFY=true && export VAULT_ADDR=https://%s:8200 && ", host)
-				err = runtime.Exec(&runtime.EmptyEnv{}, fmt.Sprintf("%s vault operator unseal %s", envVars, key), os.Stdout)
-				if err != nil {
-					return err
+			return err
+		}
+		for used := 0; status.Sealed; used++ {
+			if used == len(shares) {
+				share, e := next(host, status)
+				if e != nil {
+					return e
 				}
+				shares = append(shares, share)
+			}
+			status, err = client.Unseal(host, shares[used])
+			if err != nil {
+				return err
 			}
 		}
 	}
+	return nil
+}
+
+// initRequest builds the init request for the configured shares, which are recovery key shares when vault
+// is auto-unsealed. With PGP keys configured, vault encrypts every share to one key holder, named after
+// the file holding their key.
+func initRequest(vaultConfig conf.VaultConfig, autoUnseal bool) (InitRequest, []string, error) {
+	req, holders, err := shareRequest(vaultConfig)
+	if err != nil || !autoUnseal {
+		return req, holders, err
+	}
+	return InitRequest{
+		RecoveryShares:    req.SecretShares,
+		RecoveryThreshold: req.SecretThreshold,
+		RecoveryPGPKeys:   req.PGPKeys,
+	}, holders, nil
+}
 
-	if !init {
-		envVars := fmt.Sprintf("export VAULT_SKIP_VERIFY=true && export VAULT_ADDR=https://%s:8200 && ", vaultHosts[0])
-		err := runtime.Exec(&runtime.EmptyEnv{}, fmt.Sprintf("%s vault policy write nomad-server %s/vault/nomad-server-policy.hcl", envVars, baseDir), os.Stdout)
-		if err != nil {
-			return err
-		}
-		fmt.Println("gen token")
-		err = runtime.Exec(&runtime.EmptyEnv{}, fmt.Sprintf("%s vault login %s && vault token create -policy nomad-server -period 72h -orphan > %s/secrets/vault/nomad-token.txt", envVars, secrets.VaultConfig.RootToken, baseDir), os.Stdout)
+func shareRequest(vaultConfig conf.VaultConfig) (InitRequest, []string, error) {
+	req := InitRequest{SecretShares: vaultConfig.Shares(), SecretThreshold: vaultConfig.Threshold()}
+	if req.SecretThreshold > req.SecretShares {
+		return req, nil, fmt.Errorf("vault key_threshold %d is larger than key_shares %d", req.SecretThreshold, req.SecretShares)
+	}
+	if len(vaultConfig.PGPKeys) == 0 {
+		return req, nil, nil
+	}
+	if len(vaultConfig.PGPKeys) != req.SecretShares {
+		return req, nil, fmt.Errorf("vault needs one pgp key per share, got %d keys for %d shares", len(vaultConfig.PGPKeys), req.SecretShares)
+	}
+	holders := []string{}
+	for _, file := range vaultConfig.PGPKeys {
+		key, err := os.ReadFile(filepath.Clean(file))
 		if err != nil {
-			return err
+			return req, nil, err
 		}
-		bytes, err := os.ReadFile(filepath.Clean(filepath.Join(baseDir, "secrets", "vault", "nomad-token.txt")))
+		req.PGPKeys = append(req.PGPKeys, strings.TrimSpace(string(key)))
+		holders = append(holders, strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	}
+	return req, holders, nil
+}
+
+// writeShares writes the encrypted share of every holder to dir, to be handed out.
+func writeShares(dir string, holders, shares []string) error {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return err
+	}
+	for i, holder := range holders {
+		err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d-%s.b64", i+1, holder)), []byte(shares[i]+"\n"), 0600)
 		if err != nil {
 			return err
 		}
-		secrets.VaultConfig.NomadRootToken = getVaultToken(bytes)
-
-		fmt.Println("gen token-role")
-		return runtime.Exec(&runtime.EmptyEnv{}, fmt.Sprintf("%s vault login %s && vault write /auth/token/roles/nomad-cluster @%s/vault/token-role.json", envVars, secrets.VaultConfig.RootToken, baseDir), os.Stdout)
 	}
 	return nil
-
 }
 
-func getVaultToken(bytes []byte) string {
-	text := string(bytes)
-	temp := strings.Split(text, "\n")
-	for _, line := range temp {
-		if strings.HasPrefix(line, "token ") {
-			return strings.Trim(strings.SplitAfter(line, "token ")[1], " ")
-		}
+func configure(client Client, sec *secrets.Config) error {
+	err := client.WritePolicy("nomad-server", vaultServerPolicy)
+	if err != nil {
+		return err
 	}
-	return ""
-}
 
-func isVaultUnsealed(bytes []byte) bool {
-	text := string(bytes)
-	temp := strings.Split(text, "\n")
-	for _, line := range temp {
-		if strings.HasPrefix(line, "Sealed") && strings.Contains(line, "true") {
-			return false
+	if sec.VaultConfig.NomadRootToken == "" || client.LookupToken(sec.VaultConfig.NomadRootToken) != nil {
+		token, e := client.CreateOrphanToken(TokenRequest{Policies: []string{"nomad-server"}, Period: "72h"})
+		if e != nil {
+			return e
 		}
+		sec.VaultConfig.NomadRootToken = token
 	}
-	return true
+
+	return client.WriteTokenRole("nomad-cluster", []byte(vaultTokenRole))
 }
 
-func parseVaultInit(initFile string, secretConfig *secrets.Config) (*secrets.Config, error) { //nolint
+// parseVaultInit reads the output of `vault operator init` written by earlier versions of openpaas.
+func parseVaultInit(initFile string, secretConfig *secrets.Config) (*secrets.Config, error) {
 	content, err := os.ReadFile(filepath.Clean(initFile))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	text := string(content)
@@ -1,10 +1,16 @@
 package vault
 
 import (
+	"crypto/x509"
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/pki"
 	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,3 +30,221 @@ func TestParseInit(t *testing.T) {
 		"ew4QyHy+YNGxWWjNIiN5RzfZ8+k92goN5D9+MmKX8y9k",
 	}, secret.VaultConfig.UnsealKeys)
 }
+
+func TestInitVaultInitializesAndConfigures(t *testing.T) {
+	folder := util.RandString(8)
+	assert.NoError(t, os.MkdirAll(filepath.Join(folder, "secrets"), 0750))
+	defer func() {
+		assert.NoError(t, os.RemoveAll(folder))
+	}()
+
+	sealed := map[string]bool{"vault-1": true, "vault-2": true}
+	progress := map[string]int{}
+	client := &MockClient{
+		StatusFunc: func(host string) (*SealStatus, error) {
+			return &SealStatus{Initialized: len(progress) > 0 || !sealed["vault-1"], Sealed: sealed[host], Threshold: 3}, nil
+		},
+		InitFunc: func(host string, req InitRequest) (*InitResponse, error) {
+			return &InitResponse{KeysBase64: []string{"k1", "k2", "k3", "k4", "k5"}, RootToken: "root"}, nil
+		},
+		UnsealFunc: func(host, key string) (*SealStatus, error) {
+			progress[host]++
+			if progress[host] == 3 {
+				sealed[host] = false
+			}
+			return &SealStatus{Initialized: true, Sealed: sealed[host], Threshold: 3, Progress: progress[host]}, nil
+		},
+		CreateOrphanTokenFunc: func(req TokenRequest) (string, error) {
+			return "nomad-token", nil
+		},
+	}
+	sec := &secrets.Config{}
+
+	err := initVault(client, folder, []string{"vault-1", "vault-2"}, sec, conf.VaultConfig{}, false)
+	assert.NoError(t, err)
+
+	assert.Len(t, client.InitCalls(), 1)
+	assert.Equal(t, 6, len(client.UnsealCalls()))
+	assert.Len(t, client.EnableSecretsEngineCalls(), 1)
+	assert.Equal(t, "nomad-server", client.WritePolicyCalls()[0].Name)
+	assert.Equal(t, "nomad-cluster", client.WriteTokenRoleCalls()[0].Name)
+
+	stored, err := secrets.Load(folder)
+	assert.NoError(t, err)
+	assert.Equal(t, "root", stored.VaultConfig.RootToken)
+	assert.Equal(t, "nomad-token", stored.VaultConfig.NomadRootToken)
+	assert.Len(t, stored.VaultConfig.UnsealKeys, 5)
+}
+
+func TestUnsealFailsWhenKeysAreInsufficient(t *testing.T) {
+	client := &MockClient{
+		StatusFunc: func(host string) (*SealStatus, error) {
+			return &SealStatus{Initialized: true, Sealed: true, Threshold: 3}, nil
+		},
+		UnsealFunc: func(host, key string) (*SealStatus, error) {
+			return &SealStatus{Initialized: true, Sealed: true, Threshold: 3, Progress: 1}, nil
+		},
+	}
+	sec := &secrets.Config{VaultConfig: secrets.VaultSecrets{UnsealKeys: []string{"k1"}}}
+	err := unseal(client, []string{"vault-1"}, sec)
+	assert.Error(t, err)
+}
+
+func TestInitVaultWritesSharesToHolders(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		e := os.RemoveAll(filepath.Clean(folder))
+		assert.NoError(t, e)
+	}()
+	assert.NoError(t, os.MkdirAll(filepath.Join(folder, "keys"), 0750))
+	keys := []string{}
+	for _, holder := range []string{"alice", "bob", "carol"} {
+		file := filepath.Join(folder, "keys", holder+".asc")
+		assert.NoError(t, os.WriteFile(file, []byte(holder+"-pgp\n"), 0600))
+		keys = append(keys, file)
+	}
+
+	client := &MockClient{
+		StatusFunc: func(host string) (*SealStatus, error) {
+			return &SealStatus{Initialized: false, Sealed: true, Threshold: 2}, nil
+		},
+		InitFunc: func(host string, req InitRequest) (*InitResponse, error) {
+			return &InitResponse{KeysBase64: []string{"enc-1", "enc-2", "enc-3"}, RootToken: "root"}, nil
+		},
+	}
+	sec := &secrets.Config{}
+
+	err := initVault(client, folder, []string{"vault-1"}, sec, conf.VaultConfig{KeyShares: 3, KeyThreshold: 2, PGPKeys: keys}, false)
+	assert.ErrorIs(t, err, ErrSealed)
+	assert.Equal(t, InitRequest{SecretShares: 3, SecretThreshold: 2, PGPKeys: []string{"alice-pgp", "bob-pgp", "carol-pgp"}}, client.InitCalls()[0].Req)
+	assert.Empty(t, client.UnsealCalls())
+
+	share, err := os.ReadFile(filepath.Join(folder, "secrets", "vault", "unseal-shares", "2-bob.b64"))
+	assert.NoError(t, err)
+	assert.Equal(t, "enc-2\n", string(share))
+
+	stored, err := secrets.Load(folder)
+	assert.NoError(t, err)
+	assert.Equal(t, "root", stored.VaultConfig.RootToken)
+	assert.Empty(t, stored.VaultConfig.UnsealKeys)
+	assert.Empty(t, client.EnableSecretsEngineCalls())
+
+	// the next sync, once the holders unsealed vault, mounts the kv engine and keeps it mounted
+	client.StatusFunc = func(host string) (*SealStatus, error) {
+		return &SealStatus{Initialized: true, Sealed: false, Threshold: 2}, nil
+	}
+	client.CreateOrphanTokenFunc = func(req TokenRequest) (string, error) {
+		return "nomad-token", nil
+	}
+	assert.NoError(t, initVault(client, folder, []string{"vault-1"}, stored, conf.VaultConfig{KeyShares: 3, KeyThreshold: 2, PGPKeys: keys}, false))
+	assert.Len(t, client.EnableSecretsEngineCalls(), 1)
+	assert.Equal(t, "secret", client.EnableSecretsEngineCalls()[0].Path)
+
+	client.EnableSecretsEngineFunc = func(path, engineType string, options map[string]string) error {
+		return &APIError{StatusCode: 400, Errors: []string{"path is already in use at secret/"}}
+	}
+	assert.NoError(t, initVault(client, folder, []string{"vault-1"}, stored, conf.VaultConfig{KeyShares: 3, KeyThreshold: 2, PGPKeys: keys}, false))
+	assert.Len(t, client.EnableSecretsEngineCalls(), 2)
+}
+
+func TestInitVaultRejectsInvalidShares(t *testing.T) {
+	client := &MockClient{
+		StatusFunc: func(host string) (*SealStatus, error) {
+			return &SealStatus{Initialized: false, Sealed: true}, nil
+		},
+	}
+	err := initVault(client, "", []string{"vault-1"}, &secrets.Config{}, conf.VaultConfig{KeyShares: 2, KeyThreshold: 3}, false)
+	assert.Error(t, err)
+	err = initVault(client, "", []string{"vault-1"}, &secrets.Config{}, conf.VaultConfig{KeyShares: 3, KeyThreshold: 2, PGPKeys: []string{"a.asc"}}, false)
+	assert.Error(t, err)
+	assert.Empty(t, client.InitCalls())
+}
+
+func TestUnsealWithStopsAtThreshold(t *testing.T) {
+	progress := map[string]int{}
+	client := &MockClient{
+		StatusFunc: func(host string) (*SealStatus, error) {
+			return &SealStatus{Initialized: true, Sealed: true, Threshold: 2}, nil
+		},
+		UnsealFunc: func(host, key string) (*SealStatus, error) {
+			progress[host]++
+			return &SealStatus{Initialized: true, Sealed: progress[host] < 2, Threshold: 2, Progress: progress[host]}, nil
+		},
+	}
+	asked := 0
+	err := UnsealWith(client, []string{"vault-1", "vault-2"}, func(host string, status *SealStatus) (string, error) {
+		asked++
+		return "share", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, asked, "shares must be reused for every host")
+	assert.Len(t, client.UnsealCalls(), 4)
+}
+
+func TestInitVaultWithAutoUnseal(t *testing.T) {
+	folder := util.RandString(8)
+	assert.NoError(t, os.MkdirAll(filepath.Join(folder, "secrets"), 0750))
+	defer func() {
+		e := os.RemoveAll(filepath.Clean(folder))
+		assert.NoError(t, e)
+	}()
+	initialized := false
+	client := &MockClient{
+		StatusFunc: func(host string) (*SealStatus, error) {
+			return &SealStatus{Type: "transit", Initialized: initialized, Sealed: !initialized}, nil
+		},
+		InitFunc: func(host string, req InitRequest) (*InitResponse, error) {
+			initialized = true
+			return &InitResponse{RecoveryKeysBase64: []string{"r1", "r2", "r3", "r4", "r5"}, RootToken: "root"}, nil
+		},
+		CreateOrphanTokenFunc: func(req TokenRequest) (string, error) {
+			return "nomad-token", nil
+		},
+	}
+	sec := &secrets.Config{VaultConfig: secrets.VaultSecrets{SealToken: "s.token"}}
+
+	err := initVault(client, folder, []string{"vault-1", "vault-2"}, sec, conf.VaultConfig{}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, InitRequest{RecoveryShares: 5, RecoveryThreshold: 3}, client.InitCalls()[0].Req)
+	assert.Empty(t, client.UnsealCalls())
+
+	stored, err := secrets.Load(folder)
+	assert.NoError(t, err)
+	assert.Empty(t, stored.VaultConfig.UnsealKeys)
+	assert.Len(t, stored.VaultConfig.RecoveryKeys, 5)
+	assert.Equal(t, "s.token", stored.VaultConfig.SealToken)
+}
+
+func TestGenerateAndRotateTLS(t *testing.T) {
+	folder := util.RandString(8)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(folder))
+	}()
+	config := &conf.Config{BaseDir: folder, OrgName: "openpaas"}
+	inventory := &ansible.Inventory{}
+	inventory.All.Children.VaultServers.Hosts = map[string]ansible.AnsibleHost{"127.0.0.6": {PrivateIP: "10.0.1.1"}}
+	crtFile := filepath.Join(folder, "secrets", "vault", "tls.crt")
+
+	assert.NoError(t, GenerateTLS(config, inventory))
+	first, err := pki.ReadCertificate(crtFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "CN=Vault,O=openpaas", first.Subject.String())
+	assert.Equal(t, []string{"vault.service.consul", "active.vault.service.consul"}, first.DNSNames)
+	assert.Equal(t, "10.0.1.1", first.IPAddresses[1].String())
+
+	// existing certificates are kept
+	assert.NoError(t, GenerateTLS(config, inventory))
+	kept, err := pki.ReadCertificate(crtFile)
+	assert.NoError(t, err)
+	assert.Equal(t, first.Raw, kept.Raw)
+
+	inventory.All.Children.VaultServers.Hosts["127.0.0.7"] = ansible.AnsibleHost{PrivateIP: "10.0.1.2"}
+	assert.NoError(t, RotateTLS(config, inventory))
+	rotated, err := pki.ReadCertificate(crtFile)
+	assert.NoError(t, err)
+	assert.Len(t, rotated.IPAddresses, 3)
+	roots := x509.NewCertPool()
+	roots.AddCert(first)
+	_, err = rotated.Verify(x509.VerifyOptions{Roots: roots, DNSName: "10.0.1.2"})
+	assert.NoError(t, err, "clients trusting the previous certificate trust the rotated one")
+}
@@ -0,0 +1,206 @@
+// Package logging is the levelled logger of openpaas. It writes logfmt style text or JSON lines, and
+// times the phases of long running operations such as a sync.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses the value of --log-level.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("%s is not a log level, expected one of %s", s, strings.Join(levelNames, ", "))
+}
+
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// ParseFormat parses the value of --log-format.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case FormatText, FormatJSON:
+		return Format(s), nil
+	}
+	return FormatText, fmt.Errorf("%s is not a log format, expected %s or %s", s, FormatText, FormatJSON)
+}
+
+// Logger writes a line per message at or above its level. Fields are given as alternating keys and values.
+// A nil Logger discards everything.
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	format Format
+	fields []interface{}
+	now    func() time.Time
+}
+
+func New(out io.Writer, level Level, format Format) *Logger {
+	return &Logger{mu: &sync.Mutex{}, out: out, level: level, format: format, now: time.Now}
+}
+
+// Discard returns a logger that writes nothing, for tests.
+func Discard() *Logger {
+	return New(io.Discard, LevelError+1, FormatText)
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = New(io.Discard, LevelInfo, FormatText)
+)
+
+// SetDefault sets the logger used by code that is not handed one, such as the runtime package.
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// With returns a logger adding fields to every message.
+func (l *Logger) With(fields ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.fields = append(append([]interface{}{}, l.fields...), fields...)
+	return &c
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+func (l *Logger) Debug(msg string, fields ...interface{}) {
+	l.log(LevelDebug, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...interface{}) {
+	l.log(LevelInfo, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...interface{}) {
+	l.log(LevelWarn, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...interface{}) {
+	l.log(LevelError, msg, fields)
+}
+
+// Phase reports the start of a phase and returns the function reporting its end, with its duration and
+// the error it failed with, if any.
+func (l *Logger) Phase(name string) func(err error) {
+	if l == nil {
+		return func(error) {}
+	}
+	start := l.now()
+	l.Info("phase started", "phase", name)
+	return func(err error) {
+		duration := l.now().Sub(start).Round(time.Millisecond)
+		if err != nil {
+			l.Error("phase failed", "phase", name, "duration", duration, "error", err)
+			return
+		}
+		l.Info("phase finished", "phase", name, "duration", duration)
+	}
+}
+
+func (l *Logger) log(level Level, msg string, fields []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	all := append(append([]interface{}{"time", l.now().UTC().Format(time.RFC3339), "level", level.String(), "msg", msg}, l.fields...), fields...)
+	var line bytes.Buffer
+	if l.format == FormatJSON {
+		line.WriteByte('{')
+	}
+	for i := 0; i < len(all); i += 2 {
+		key := fmt.Sprint(all[i])
+		var value interface{} = "!MISSING"
+		if i+1 < len(all) {
+			value = all[i+1]
+		}
+		if l.format == FormatJSON {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(strconv.Quote(key))
+			line.WriteByte(':')
+			line.Write(jsonValue(value))
+		} else {
+			if i > 0 {
+				line.WriteByte(' ')
+			}
+			line.WriteString(key)
+			line.WriteByte('=')
+			line.WriteString(textValue(value))
+		}
+	}
+	if l.format == FormatJSON {
+		line.WriteByte('}')
+	}
+	line.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line.Bytes()) //nolint
+}
+
+func jsonValue(v interface{}) []byte {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case time.Duration:
+		v = t.String()
+	case fmt.Stringer:
+		v = t.String()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return b
+}
+
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
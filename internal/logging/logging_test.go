@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fixedClock(l *Logger) *Logger {
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.now = func() time.Time {
+		now = now.Add(1500 * time.Millisecond)
+		return now
+	}
+	return l
+}
+
+func TestTextFormat(t *testing.T) {
+	var out bytes.Buffer
+	l := fixedClock(New(&out, LevelInfo, FormatText)).With("dc", "dc1")
+	l.Debug("hidden")
+	l.Info("running playbook", "playbook", "base.yml", "hosts", 3)
+	l.Warn("odd", "value", "two words")
+	assert.Equal(t, `time=2023-01-02T03:04:06Z level=info msg="running playbook" dc=dc1 playbook=base.yml hosts=3
+time=2023-01-02T03:04:08Z level=warn msg=odd dc=dc1 value="two words"
+`, out.String())
+}
+
+func TestJSONFormatAndPhases(t *testing.T) {
+	var out bytes.Buffer
+	l := fixedClock(New(&out, LevelDebug, FormatJSON))
+	l.Phase("consul")(nil)
+	l.Phase("vault")(errors.New("sealed"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 4)
+	entries := []map[string]interface{}{}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, "phase started", entries[0]["msg"])
+	assert.Equal(t, "consul", entries[1]["phase"])
+	assert.Equal(t, "3s", entries[1]["duration"])
+	assert.Equal(t, "error", entries[3]["level"])
+	assert.Equal(t, "sealed", entries[3]["error"])
+}
+
+func TestParse(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, LevelWarn, level)
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err)
+
+	format, err := ParseFormat("json")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJSON, format)
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
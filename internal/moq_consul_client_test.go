@@ -5,6 +5,7 @@ package internal
 
 import (
 	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"io"
 	"sync"
 )
 
@@ -18,9 +19,18 @@ var _ hashistack.Consul = &MockConsul{}
 //
 // 		// make and configure a mocked hashistack.Consul
 // 		mockedConsul := &MockConsul{
+// 			AutopilotHealthFunc: func() (*hashistack.AutopilotHealth, error) {
+// 				panic("mock out the AutopilotHealth method")
+// 			},
 // 			BootstrapFunc: func() (string, error) {
 // 				panic("mock out the Bootstrap method")
 // 			},
+// 			ForceLeaveFunc: func(node string) error {
+// 				panic("mock out the ForceLeave method")
+// 			},
+// 			ReadPolicyFunc: func(name string) (string, error) {
+// 				panic("mock out the ReadPolicy method")
+// 			},
 // 			RegisterACLFunc: func(description string, policy string) (string, error) {
 // 				panic("mock out the RegisterACL method")
 // 			},
@@ -33,6 +43,12 @@ var _ hashistack.Consul = &MockConsul{}
 // 			RegisterServiceFunc: func(file string) error {
 // 				panic("mock out the RegisterService method")
 // 			},
+// 			RestoreFunc: func(r io.Reader) error {
+// 				panic("mock out the Restore method")
+// 			},
+// 			SnapshotFunc: func(w io.Writer) error {
+// 				panic("mock out the Snapshot method")
+// 			},
 // 			UpdateACLFunc: func(tokenID string, policy string) error {
 // 				panic("mock out the UpdateACL method")
 // 			},
@@ -46,9 +62,18 @@ var _ hashistack.Consul = &MockConsul{}
 //
 // 	}
 type MockConsul struct {
+	// AutopilotHealthFunc mocks the AutopilotHealth method.
+	AutopilotHealthFunc func() (*hashistack.AutopilotHealth, error)
+
 	// BootstrapFunc mocks the Bootstrap method.
 	BootstrapFunc func() (string, error)
 
+	// ForceLeaveFunc mocks the ForceLeave method.
+	ForceLeaveFunc func(node string) error
+
+	// ReadPolicyFunc mocks the ReadPolicy method.
+	ReadPolicyFunc func(name string) (string, error)
+
 	// RegisterACLFunc mocks the RegisterACL method.
 	RegisterACLFunc func(description string, policy string) (string, error)
 
@@ -61,6 +86,12 @@ type MockConsul struct {
 	// RegisterServiceFunc mocks the RegisterService method.
 	RegisterServiceFunc func(file string) error
 
+	// RestoreFunc mocks the Restore method.
+	RestoreFunc func(r io.Reader) error
+
+	// SnapshotFunc mocks the Snapshot method.
+	SnapshotFunc func(w io.Writer) error
+
 	// UpdateACLFunc mocks the UpdateACL method.
 	UpdateACLFunc func(tokenID string, policy string) error
 
@@ -69,9 +100,22 @@ type MockConsul struct {
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// AutopilotHealth holds details about calls to the AutopilotHealth method.
+		AutopilotHealth []struct {
+		}
 		// Bootstrap holds details about calls to the Bootstrap method.
 		Bootstrap []struct {
 		}
+		// ForceLeave holds details about calls to the ForceLeave method.
+		ForceLeave []struct {
+			// Node is the node argument value.
+			Node string
+		}
+		// ReadPolicy holds details about calls to the ReadPolicy method.
+		ReadPolicy []struct {
+			// Name is the name argument value.
+			Name string
+		}
 		// RegisterACL holds details about calls to the RegisterACL method.
 		RegisterACL []struct {
 			// Description is the description argument value.
@@ -96,6 +140,16 @@ type MockConsul struct {
 			// File is the file argument value.
 			File string
 		}
+		// Restore holds details about calls to the Restore method.
+		Restore []struct {
+			// R is the r argument value.
+			R io.Reader
+		}
+		// Snapshot holds details about calls to the Snapshot method.
+		Snapshot []struct {
+			// W is the w argument value.
+			W io.Writer
+		}
 		// UpdateACL holds details about calls to the UpdateACL method.
 		UpdateACL []struct {
 			// TokenID is the tokenID argument value.
@@ -111,15 +165,50 @@ type MockConsul struct {
 			File string
 		}
 	}
+	lockAutopilotHealth   sync.RWMutex
 	lockBootstrap         sync.RWMutex
+	lockForceLeave        sync.RWMutex
+	lockReadPolicy        sync.RWMutex
 	lockRegisterACL       sync.RWMutex
 	lockRegisterIntention sync.RWMutex
 	lockRegisterPolicy    sync.RWMutex
 	lockRegisterService   sync.RWMutex
+	lockRestore           sync.RWMutex
+	lockSnapshot          sync.RWMutex
 	lockUpdateACL         sync.RWMutex
 	lockUpdatePolicy      sync.RWMutex
 }
 
+// AutopilotHealth calls AutopilotHealthFunc.
+func (mock *MockConsul) AutopilotHealth() (*hashistack.AutopilotHealth, error) {
+	callInfo := struct {
+	}{}
+	mock.lockAutopilotHealth.Lock()
+	mock.calls.AutopilotHealth = append(mock.calls.AutopilotHealth, callInfo)
+	mock.lockAutopilotHealth.Unlock()
+	if mock.AutopilotHealthFunc == nil {
+		var (
+			autopilotHealthOut *hashistack.AutopilotHealth
+			errOut             error
+		)
+		return autopilotHealthOut, errOut
+	}
+	return mock.AutopilotHealthFunc()
+}
+
+// AutopilotHealthCalls gets all the calls that were made to AutopilotHealth.
+// Check the length with:
+//     len(mockedConsul.AutopilotHealthCalls())
+func (mock *MockConsul) AutopilotHealthCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockAutopilotHealth.RLock()
+	calls = mock.calls.AutopilotHealth
+	mock.lockAutopilotHealth.RUnlock()
+	return calls
+}
+
 // Bootstrap calls BootstrapFunc.
 func (mock *MockConsul) Bootstrap() (string, error) {
 	callInfo := struct {
@@ -150,6 +239,75 @@ func (mock *MockConsul) BootstrapCalls() []struct {
 	return calls
 }
 
+// ForceLeave calls ForceLeaveFunc.
+func (mock *MockConsul) ForceLeave(node string) error {
+	callInfo := struct {
+		Node string
+	}{
+		Node: node,
+	}
+	mock.lockForceLeave.Lock()
+	mock.calls.ForceLeave = append(mock.calls.ForceLeave, callInfo)
+	mock.lockForceLeave.Unlock()
+	if mock.ForceLeaveFunc == nil {
+		var (
+			errOut error
+		)
+		return errOut
+	}
+	return mock.ForceLeaveFunc(node)
+}
+
+// ForceLeaveCalls gets all the calls that were made to ForceLeave.
+// Check the length with:
+//     len(mockedConsul.ForceLeaveCalls())
+func (mock *MockConsul) ForceLeaveCalls() []struct {
+	Node string
+} {
+	var calls []struct {
+		Node string
+	}
+	mock.lockForceLeave.RLock()
+	calls = mock.calls.ForceLeave
+	mock.lockForceLeave.RUnlock()
+	return calls
+}
+
+// ReadPolicy calls ReadPolicyFunc.
+func (mock *MockConsul) ReadPolicy(name string) (string, error) {
+	callInfo := struct {
+		Name string
+	}{
+		Name: name,
+	}
+	mock.lockReadPolicy.Lock()
+	mock.calls.ReadPolicy = append(mock.calls.ReadPolicy, callInfo)
+	mock.lockReadPolicy.Unlock()
+	if mock.ReadPolicyFunc == nil {
+		var (
+			sOut   string
+			errOut error
+		)
+		return sOut, errOut
+	}
+	return mock.ReadPolicyFunc(name)
+}
+
+// ReadPolicyCalls gets all the calls that were made to ReadPolicy.
+// Check the length with:
+//     len(mockedConsul.ReadPolicyCalls())
+func (mock *MockConsul) ReadPolicyCalls() []struct {
+	Name string
+} {
+	var calls []struct {
+		Name string
+	}
+	mock.lockReadPolicy.RLock()
+	calls = mock.calls.ReadPolicy
+	mock.lockReadPolicy.RUnlock()
+	return calls
+}
+
 // RegisterACL calls RegisterACLFunc.
 func (mock *MockConsul) RegisterACL(description string, policy string) (string, error) {
 	callInfo := struct {
@@ -295,6 +453,74 @@ func (mock *MockConsul) RegisterServiceCalls() []struct {
 	return calls
 }
 
+// Restore calls RestoreFunc.
+func (mock *MockConsul) Restore(r io.Reader) error {
+	callInfo := struct {
+		R io.Reader
+	}{
+		R: r,
+	}
+	mock.lockRestore.Lock()
+	mock.calls.Restore = append(mock.calls.Restore, callInfo)
+	mock.lockRestore.Unlock()
+	if mock.RestoreFunc == nil {
+		var (
+			errOut error
+		)
+		return errOut
+	}
+	return mock.RestoreFunc(r)
+}
+
+// RestoreCalls gets all the calls that were made to Restore.
+// Check the length with:
+//     len(mockedConsul.RestoreCalls())
+func (mock *MockConsul) RestoreCalls() []struct {
+	R io.Reader
+} {
+	var calls []struct {
+		R io.Reader
+	}
+	mock.lockRestore.RLock()
+	calls = mock.calls.Restore
+	mock.lockRestore.RUnlock()
+	return calls
+}
+
+// Snapshot calls SnapshotFunc.
+func (mock *MockConsul) Snapshot(w io.Writer) error {
+	callInfo := struct {
+		W io.Writer
+	}{
+		W: w,
+	}
+	mock.lockSnapshot.Lock()
+	mock.calls.Snapshot = append(mock.calls.Snapshot, callInfo)
+	mock.lockSnapshot.Unlock()
+	if mock.SnapshotFunc == nil {
+		var (
+			errOut error
+		)
+		return errOut
+	}
+	return mock.SnapshotFunc(w)
+}
+
+// SnapshotCalls gets all the calls that were made to Snapshot.
+// Check the length with:
+//     len(mockedConsul.SnapshotCalls())
+func (mock *MockConsul) SnapshotCalls() []struct {
+	W io.Writer
+} {
+	var calls []struct {
+		W io.Writer
+	}
+	mock.lockSnapshot.RLock()
+	calls = mock.calls.Snapshot
+	mock.lockSnapshot.RUnlock()
+	return calls
+}
+
 // UpdateACL calls UpdateACLFunc.
 func (mock *MockConsul) UpdateACL(tokenID string, policy string) error {
 	callInfo := struct {
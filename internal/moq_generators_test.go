@@ -2,3 +2,6 @@ package internal
 
 //go:generate moq -pkg o11y -stub -out ./o11y/moq_consul_client_test.go ./hashistack Consul:MockConsul
 //go:generate moq -pkg internal -stub -out ./moq_consul_client_test.go ./hashistack Consul:MockConsul
+//go:generate moq -pkg internal -stub -out ./moq_nomad_client_test.go ./hashistack NomadClient:MockNomadClient
+//go:generate moq -pkg internal -stub -out ./moq_vault_client_test.go ./hashistack/vault Client:MockVaultClient
+//go:generate moq -pkg vault -stub -out ./hashistack/vault/moq_client_test.go ./hashistack/vault Client:MockClient
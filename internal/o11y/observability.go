@@ -227,7 +227,6 @@ func mkObservabilityConfigs(consul hashistack.Consul, config *conf.Config, inv *
 
 	for _, service := range consulServices {
 		servers := service.getPrivateIPs(inv)
-		fmt.Println(servers)
 		template := strings.ReplaceAll(service.template, "HOST", servers[0])
 		template = strings.ReplaceAll(template, "ROOTDOMAIN", config.ClusterConfig.Ingress.ManagementDomain)
 		err = os.WriteFile(filepath.Clean(service.file), []byte(template), 0600)
@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+)
+
+// stateFile is the journal of the phases completed by earlier syncs, kept in base_dir.
+const stateFile = "sync-state.json"
+
+// configInput is the name the config file is recorded under in the inputs of a phase.
+const configInput = "config"
+
+// buildInput is the name the version of openpaas and its templates are recorded under in the inputs of a
+// phase, as the phases render the files of base_dir from them.
+const buildInput = "openpaas"
+
+//go:embed templates o11y/templates provider/aws/templates provider/hetzner/templates hashistack/vault/templates
+var embeddedTemplates embed.FS
+
+// phase is a step of the sync of a cluster, reported with its duration.
+type phase struct {
+	name string
+	// inputs are the files and directories in base_dir the phase depends on, besides the config and openpaas.
+	// A phase without inputs depends on more than base_dir and runs on every sync, like terraform, which
+	// finds the changes to the machines and the public IP of the operator itself.
+	inputs []string
+	run    func() error
+}
+
+// PhaseOptions select the phases a sync runs. By default every phase runs whose inputs changed since it
+// last completed, and every phase without inputs. From runs the given phase and all after it, Only runs just the given phase.
+type PhaseOptions struct {
+	From string
+	Only string
+}
+
+type phaseRecord struct {
+	Inputs      map[string]string `json:"inputs"`
+	CompletedAt time.Time         `json:"completed_at"`
+}
+
+type journal struct {
+	Phases map[string]phaseRecord `json:"phases"`
+}
+
+func loadJournal(baseDir string) (*journal, error) {
+	j := &journal{Phases: map[string]phaseRecord{}}
+	bytes, err := os.ReadFile(filepath.Clean(filepath.Join(baseDir, stateFile)))
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, j)
+	if err != nil {
+		return nil, fmt.Errorf("%s is corrupt, remove it to run all phases: %w", stateFile, err)
+	}
+	if j.Phases == nil {
+		j.Phases = map[string]phaseRecord{}
+	}
+	return j, nil
+}
+
+func (j *journal) write(baseDir string) error {
+	bytes, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(baseDir, stateFile), bytes, 0600)
+}
+
+func runPhases(log *logging.Logger, baseDir, configPath string, phases []phase, opts PhaseOptions) error {
+	names := []string{}
+	for _, p := range phases {
+		names = append(names, p.name)
+	}
+	for _, name := range []string{opts.From, opts.Only} {
+		if name != "" && !containsPhase(names, name) {
+			return fmt.Errorf("%s is not a phase, expected one of %s", name, strings.Join(names, ", "))
+		}
+	}
+	j, err := loadJournal(baseDir)
+	if err != nil {
+		return err
+	}
+
+	started := opts.From == ""
+	for _, p := range phases {
+		started = started || p.name == opts.From
+		if !started || (opts.Only != "" && p.name != opts.Only) {
+			continue
+		}
+		forced := opts.From != "" || opts.Only != "" || p.inputs == nil
+		if !forced {
+			inputs, e := fingerprint(baseDir, configPath, p.inputs)
+			if e != nil {
+				return e
+			}
+			if record, ok := j.Phases[p.name]; ok && reflect.DeepEqual(record.Inputs, inputs) {
+				log.Info("phase skipped, inputs unchanged", "phase", p.name, "completed_at", record.CompletedAt.Format(time.RFC3339))
+				continue
+			}
+		}
+
+		done := log.Phase(p.name)
+		err = p.run()
+		done(err)
+		if err != nil {
+			delete(j.Phases, p.name)
+			if e := j.write(baseDir); e != nil {
+				log.Warn("could not update the sync state", "error", e)
+			}
+			return err
+		}
+		// recorded as they are after the phase ran, as phases such as vault add to the secrets they read
+		inputs, err := fingerprint(baseDir, configPath, p.inputs)
+		if err != nil {
+			return err
+		}
+		j.Phases[p.name] = phaseRecord{Inputs: inputs, CompletedAt: time.Now().UTC()}
+		err = j.write(baseDir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fingerprint hashes the config and every input of a phase. Secrets are hashed decrypted, as their
+// ciphertext changes on every write.
+func fingerprint(baseDir, configPath string, inputs []string) (map[string]string, error) {
+	hashes := map[string]string{}
+	kp := secrets.KeyProviderFromEnv()
+	config, err := secrets.ReadFile(configPath, kp)
+	if err != nil {
+		return nil, err
+	}
+	hashes[configInput] = hash(config)
+	hashes[buildInput], err = buildFingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, input := range inputs {
+		root := filepath.Join(baseDir, input)
+		if _, e := os.Stat(root + secrets.EncryptedSuffix); e == nil {
+			root += secrets.EncryptedSuffix
+		}
+		files := []string{}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, e error) error {
+			if e != nil {
+				return e
+			}
+			if !d.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			hashes[input] = "absent"
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		h := sha256.New()
+		for _, file := range files {
+			file = strings.TrimSuffix(file, secrets.EncryptedSuffix)
+			content, e := secrets.ReadFile(file, kp)
+			if e != nil {
+				return nil, e
+			}
+			rel, _ := filepath.Rel(strings.TrimSuffix(root, secrets.EncryptedSuffix), file)
+			fmt.Fprintf(h, "%s\x00%s\x00", rel, hash(content)) //nolint
+		}
+		hashes[input] = hex.EncodeToString(h.Sum(nil))
+	}
+	return hashes, nil
+}
+
+// buildFingerprint hashes the version openpaas was built from and the templates embedded in it, which
+// change without the version in development builds.
+func buildFingerprint() (string, error) {
+	h := sha256.New()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(h, "%s\x00", info.Main.Version) //nolint
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" || setting.Key == "vcs.modified" {
+				fmt.Fprintf(h, "%s=%s\x00", setting.Key, setting.Value) //nolint
+			}
+		}
+	}
+	err := fs.WalkDir(embeddedTemplates, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := embeddedTemplates.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", path, hash(content)) //nolint
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func containsPhase(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+type phaseRecorder struct {
+	ran  []string
+	fail map[string]error
+}
+
+func (r *phaseRecorder) phases() []phase {
+	p := func(name string, inputs ...string) phase {
+		return phase{name, inputs, func() error {
+			r.ran = append(r.ran, name)
+			return r.fail[name]
+		}}
+	}
+	return []phase{p("terraform"), p("base", "inventory"), p("consul", "inventory", "consul")}
+}
+
+func (r *phaseRecorder) run(folder, configFile string, opts PhaseOptions) error {
+	r.ran = []string{}
+	return runPhases(logging.Discard(), folder, configFile, r.phases(), opts)
+}
+
+func phaseFolder(t *testing.T) (string, string) {
+	folder := util.RandString(8)
+	assert.NoError(t, os.MkdirAll(filepath.Join(folder, "consul"), 0750))
+	t.Cleanup(func() {
+		assert.NoError(t, os.RemoveAll(folder))
+	})
+	configFile := filepath.Join(folder, "config.yaml")
+	assert.NoError(t, os.WriteFile(configFile, []byte("dc_name: dc1\n"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(folder, "inventory"), []byte("all: {}\n"), 0600))
+	return folder, configFile
+}
+
+func TestRunPhasesStopsAtFailure(t *testing.T) {
+	folder, configFile := phaseFolder(t)
+	var out bytes.Buffer
+	r := &phaseRecorder{fail: map[string]error{"base": errors.New("unreachable host")}}
+	err := runPhases(logging.New(&out, logging.LevelInfo, logging.FormatText), folder, configFile, r.phases(), PhaseOptions{})
+	assert.EqualError(t, err, "unreachable host")
+	assert.Equal(t, []string{"terraform", "base"}, r.ran)
+
+	log := out.String()
+	assert.Equal(t, 2, strings.Count(log, `msg="phase started"`))
+	assert.Contains(t, log, `msg="phase finished" phase=terraform duration=`)
+	assert.Contains(t, log, `msg="phase failed" phase=base`)
+	assert.Contains(t, log, `error="unreachable host"`)
+
+	// the next sync resumes at the failed phase, after terraform, which runs on every sync
+	r.fail = nil
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{}))
+	assert.Equal(t, []string{"terraform", "base", "consul"}, r.ran)
+}
+
+func TestRunPhasesSkipsUnchangedInputs(t *testing.T) {
+	folder, configFile := phaseFolder(t)
+	r := &phaseRecorder{}
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{}))
+	assert.Equal(t, []string{"terraform", "base", "consul"}, r.ran)
+
+	// terraform fixes drift and allows the public IP of the operator, which are not in base_dir
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{}))
+	assert.Equal(t, []string{"terraform"}, r.ran)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(folder, "consul", "server.j2"), []byte("changed"), 0600))
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{}))
+	assert.Equal(t, []string{"terraform", "consul"}, r.ran)
+
+	assert.NoError(t, os.WriteFile(configFile, []byte("dc_name: dc2\n"), 0600))
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{}))
+	assert.Equal(t, []string{"terraform", "base", "consul"}, r.ran, "a config change reruns every phase")
+
+	j, err := loadJournal(folder)
+	assert.NoError(t, err)
+	assert.Len(t, j.Phases, 3)
+	assert.Contains(t, j.Phases["consul"].Inputs, "consul")
+
+	// as if the phases completed with another version of openpaas, which renders other files
+	for name, record := range j.Phases {
+		record.Inputs[buildInput] = "previous"
+		j.Phases[name] = record
+	}
+	assert.NoError(t, j.write(folder))
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{}))
+	assert.Equal(t, []string{"terraform", "base", "consul"}, r.ran, "an upgrade of openpaas reruns every phase")
+}
+
+func TestBuildFingerprint(t *testing.T) {
+	first, err := buildFingerprint()
+	assert.NoError(t, err)
+	second, err := buildFingerprint()
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	content, err := embeddedTemplates.ReadFile("templates/ansible/base.yml")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, content)
+	_, err = embeddedTemplates.ReadFile("o11y/templates/ansible/observability.yml")
+	assert.NoError(t, err)
+}
+
+func TestRunPhasesSelection(t *testing.T) {
+	folder, configFile := phaseFolder(t)
+	r := &phaseRecorder{}
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{}))
+
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{From: "base"}))
+	assert.Equal(t, []string{"base", "consul"}, r.ran)
+
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{Only: "base"}))
+	assert.Equal(t, []string{"base"}, r.ran)
+
+	err := r.run(folder, configFile, PhaseOptions{Only: "nomda"})
+	assert.EqualError(t, err, "nomda is not a phase, expected one of terraform, base, consul")
+	assert.Empty(t, r.ran)
+}
+
+func TestRunPhasesWithEncryptedConfig(t *testing.T) {
+	t.Setenv(secrets.PassphraseEnv, "passphrase")
+	folder, configFile := phaseFolder(t)
+	kp := secrets.KeyProviderFromEnv()
+	assert.NoError(t, secrets.WriteFile(configFile, []byte("dc_name: dc1\n"), kp))
+	r := &phaseRecorder{}
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{}))
+	assert.Equal(t, []string{"terraform", "base", "consul"}, r.ran)
+
+	// written again on every sync, with a new ciphertext
+	assert.NoError(t, secrets.WriteFile(configFile, []byte("dc_name: dc1\n"), kp))
+	assert.NoError(t, r.run(folder, configFile, PhaseOptions{}))
+	assert.Equal(t, []string{"terraform"}, r.ran)
+}
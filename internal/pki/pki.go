@@ -0,0 +1,254 @@
+// Package pki creates the certificate authorities of consul and nomad, the certificates they issue and the
+// self-signed certificate of vault.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Request describes a certificate to create.
+type Request struct {
+	Subject pkix.Name
+	// Hosts are the DNS names and IP addresses the certificate is valid for.
+	Hosts    []string
+	Validity time.Duration
+}
+
+// KeyPair is a certificate and its private key.
+type KeyPair struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// GenerateKey creates an ECDSA P-256 key, the kind consul tls and cfssl create.
+func GenerateKey() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// NewCA creates a certificate authority with a new key. When permittedDomains are given, the CA only
+// issues certificates for DNS names in them.
+func NewCA(req Request, permittedDomains ...string) (*KeyPair, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	if len(permittedDomains) > 0 {
+		template.PermittedDNSDomainsCritical = true
+		template.PermittedDNSDomains = permittedDomains
+	}
+	return create(template, template, key, key)
+}
+
+// Issue creates a certificate with a new key, signed by the CA kp, that servers and clients can present.
+func (kp *KeyPair) Issue(req Request) (*KeyPair, error) {
+	if !kp.Cert.IsCA {
+		return nil, fmt.Errorf("%s is not a certificate authority", kp.Cert.Subject)
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	return create(template, kp.Cert, key, kp.Key)
+}
+
+// SelfSign creates a certificate signed by its own new key. The certificate is its own CA, so that clients
+// can trust it directly.
+func SelfSign(req Request) (*KeyPair, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	template, err := selfSignedTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	return create(template, template, key, key)
+}
+
+// Renew self-signs the self-signed certificate kp again for the hosts and validity of req, keeping its
+// key and subject, so that clients trusting the previous certificate trust the renewed one and the other
+// way around.
+func (kp *KeyPair) Renew(req Request) (*KeyPair, error) {
+	template, err := selfSignedTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	// as encoded by the tool that created it, which chains are built with
+	template.RawSubject = kp.Cert.RawSubject
+	return create(template, template, kp.Key, kp.Key)
+}
+
+func selfSignedTemplate(req Request) (*x509.Certificate, error) {
+	template, err := newTemplate(req)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	return template, nil
+}
+
+func newTemplate(req Request) (*x509.Certificate, error) {
+	if req.Validity <= 0 {
+		return nil, errors.New("certificates must be valid for some time")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      req.Subject,
+		// allows for clocks that are a bit behind
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(req.Validity),
+		BasicConstraintsValid: true,
+	}
+	for _, host := range req.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return template, nil
+}
+
+func create(template, parent *x509.Certificate, key, signer crypto.Signer) (*KeyPair, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{Cert: cert, Key: key}, nil
+}
+
+// Write writes the certificate and the key PEM encoded to certFile and keyFile.
+func (kp *KeyPair) Write(certFile, keyFile string) error {
+	var block *pem.Block
+	switch key := kp.Key.(type) {
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	err := os.WriteFile(filepath.Clean(certFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: kp.Cert.Raw}), 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Clean(keyFile), pem.EncodeToMemory(block), 0600)
+}
+
+// Load reads a certificate and its key from PEM files.
+func Load(certFile, keyFile string) (*KeyPair, error) {
+	cert, err := ReadCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ReadKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !publicKeysEqual(cert.PublicKey, key.Public()) {
+		return nil, fmt.Errorf("%s is not the key of %s", keyFile, certFile)
+	}
+	return &KeyPair{Cert: cert, Key: key}, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// ReadCertificate parses the first certificate of a PEM file.
+func ReadCertificate(file string) (*x509.Certificate, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// ReadKey parses the private key of a PEM file, in the PKCS #8, SEC 1 or PKCS #1 formats of openpaas,
+// cfssl, consul tls and openssl.
+func ReadKey(file string) (crypto.Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case *rsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key %T", key)
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	return block, nil
+}
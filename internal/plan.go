@@ -0,0 +1,353 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+	secret "github.com/OpenPaaSDev/openpaas/internal/secrets"
+	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// PlanReport describes the changes a sync would apply to a cluster.
+type PlanReport struct {
+	Infrastructure []ResourceChange
+	Config         []PlaybookChange
+	ACL            []PolicyChange
+}
+
+type ResourceChange struct {
+	Address string
+	Actions []string
+}
+
+type PlaybookChange struct {
+	Playbook string
+	Changed  int
+	Output   string
+	Err      error
+}
+
+type PolicyChange struct {
+	Name   string
+	Action string
+}
+
+const (
+	PolicyCreate = "create"
+	PolicyUpdate = "update"
+)
+
+var recapChanged = regexp.MustCompile(`changed=(\d+)`)
+
+// Plan reports the infrastructure, configuration and ACL drift between the config and a running cluster
+// without applying any of it. The cluster must have been synced before, as the plan works from its
+// inventory and secrets.
+func Plan(ctx context.Context, config *conf.Config, log *logging.Logger) (*PlanReport, error) {
+	_, err := ansible.LoadInventory(filepath.Join(config.BaseDir, "inventory"))
+	if err != nil {
+		return nil, fmt.Errorf("no inventory found, has the cluster been synced yet? %w", err)
+	}
+	// the configs and the terraform are rendered to a copy of base_dir, leaving what the last sync
+	// rendered as it is
+	baseDir, err := os.MkdirTemp("", "openpaas-plan")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(baseDir) //nolint
+	err = copyDir(config.BaseDir, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	planConfig := *config
+	planConfig.BaseDir = baseDir
+	config = &planConfig
+
+	configPath, err := writeResolvedConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	inventoryFile := filepath.Join(baseDir, "inventory")
+	inv, err := ansible.LoadInventory(inventoryFile)
+	if err != nil {
+		return nil, err
+	}
+	sec, err := secret.Load(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PlanReport{}
+
+	report.Infrastructure, err = planTerraform(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = renderConfigs(inv, baseDir, config.DC, config.ClusterConfig.AutoUnseal)
+	if err != nil {
+		return nil, err
+	}
+	err = renderFederation(config)
+	if err != nil {
+		return nil, err
+	}
+	ansibleClient := ansible.NewClient(inventoryFile, secret.File(baseDir), config.CloudProviderConfig.User, configPath, log)
+	report.Config = checkPlaybooks(ansibleClient, baseDir)
+
+	consul, err := hashistack.NewConsulAPI(inv, sec, baseDir, log)
+	if err != nil {
+		return nil, err
+	}
+	report.ACL, err = diffConsulPolicies(consul, inv, secondaryDC(config))
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// copyDir copies the files, folders and links of src to dst, keeping their permissions.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, e := os.Readlink(path)
+			if e != nil {
+				return e
+			}
+			return os.Symlink(link, target)
+		}
+		in, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		defer in.Close() //nolint
+		out, err := os.OpenFile(filepath.Clean(target), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+}
+
+func planTerraform(ctx context.Context, config *conf.Config) ([]ResourceChange, error) {
+	cloud, err := provider.For(config)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cloud.(provider.Static); ok {
+		return []ResourceChange{}, nil
+	}
+	err = allowPublicIP(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	tfVars, err := cloud.TFVars(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	err = hashistack.GenerateTerraform(config)
+	if err != nil {
+		return nil, err
+	}
+	var tfOut bytes.Buffer
+	tf, err := hashistack.InitTf(ctx, filepath.Join(config.BaseDir, "terraform"), hashistack.BackendEnv(config), &tfOut, os.Stderr, hashistack.BackendInitOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	planFile, err := os.CreateTemp("", "openpaas-plan")
+	if err != nil {
+		return nil, err
+	}
+	_ = planFile.Close()
+	defer os.Remove(planFile.Name()) //nolint
+
+	planOpts := []tfexec.PlanOption{tfexec.Out(planFile.Name())}
+	for _, v := range tfVars {
+		planOpts = append(planOpts, v)
+	}
+	hasChanges, err := tf.Plan(ctx, planOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if !hasChanges {
+		return []ResourceChange{}, nil
+	}
+	plan, err := tf.ShowPlanFile(ctx, planFile.Name())
+	if err != nil {
+		return nil, err
+	}
+	return resourceChanges(plan), nil
+}
+
+func resourceChanges(plan *tfjson.Plan) []ResourceChange {
+	changes := []ResourceChange{}
+	for _, rc := range plan.ResourceChanges {
+		if rc.Change == nil || rc.Change.Actions.NoOp() || rc.Change.Actions.Read() {
+			continue
+		}
+		actions := []string{}
+		for _, a := range rc.Change.Actions {
+			actions = append(actions, string(a))
+		}
+		changes = append(changes, ResourceChange{Address: rc.Address, Actions: actions})
+	}
+	return changes
+}
+
+func checkPlaybooks(client ansible.Client, baseDir string) []PlaybookChange {
+	playbooks := []string{"base.yml", "consul.yml", "gateways.yml", "vault.yml", "nomad.yml", "observability.yml"}
+	changes := []PlaybookChange{}
+	for _, playbook := range playbooks {
+		file := filepath.Join(baseDir, playbook)
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		var out bytes.Buffer
+		err := client.Check(file, &out)
+		changes = append(changes, PlaybookChange{
+			Playbook: playbook,
+			Changed:  countChanged(out.String()),
+			Output:   out.String(),
+			Err:      err,
+		})
+	}
+	return changes
+}
+
+// countChanged sums the changed task counts of all hosts in the PLAY RECAP of an ansible run.
+func countChanged(output string) int {
+	total := 0
+	inRecap := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "PLAY RECAP") {
+			inRecap = true
+			continue
+		}
+		if !inRecap {
+			continue
+		}
+		if m := recapChanged.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			total += n
+		}
+	}
+	return total
+}
+
+// diffConsulPolicies compares the desired rules of every managed ACL policy to what is registered in Consul.
+func diffConsulPolicies(consul hashistack.Consul, inventory *ansible.Inventory, dc string) ([]PolicyChange, error) {
+	dir, err := os.MkdirTemp("", "openpaas-policies")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir) //nolint
+
+	err = makeConsulPolicies(inventory, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []PolicyChange{}
+	for name, file := range consulPolicyFiles(dir) {
+		if name == nodePolicy("") {
+			name = nodePolicy(dc)
+		}
+		desired, e := os.ReadFile(filepath.Clean(file))
+		if e != nil {
+			return nil, e
+		}
+		current, e := consul.ReadPolicy(name)
+		if hashistack.IsConsulNotFound(e) {
+			changes = append(changes, PolicyChange{Name: name, Action: PolicyCreate})
+			continue
+		}
+		if e != nil {
+			return nil, e
+		}
+		if current != string(desired) {
+			changes = append(changes, PolicyChange{Name: name, Action: PolicyUpdate})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes, nil
+}
+
+// HasChanges reports whether a sync would change anything.
+func (r *PlanReport) HasChanges() bool {
+	for _, c := range r.Config {
+		if c.Changed > 0 || c.Err != nil {
+			return true
+		}
+	}
+	return len(r.Infrastructure) > 0 || len(r.ACL) > 0
+}
+
+func (r *PlanReport) Print(w io.Writer, verbose bool) {
+	fmt.Fprintln(w, "Infrastructure:")
+	if len(r.Infrastructure) == 0 {
+		fmt.Fprintln(w, "  no changes")
+	}
+	for _, c := range r.Infrastructure {
+		fmt.Fprintf(w, "  %s: %s\n", strings.Join(c.Actions, ", "), c.Address)
+	}
+
+	fmt.Fprintln(w, "\nConfiguration:")
+	for _, c := range r.Config {
+		switch {
+		case c.Err != nil:
+			fmt.Fprintf(w, "  %s: check failed: %v\n", c.Playbook, c.Err)
+		case c.Changed == 0:
+			fmt.Fprintf(w, "  %s: no changes\n", c.Playbook)
+		default:
+			fmt.Fprintf(w, "  %s: %d task(s) would change\n", c.Playbook, c.Changed)
+		}
+		if verbose && (c.Changed > 0 || c.Err != nil) {
+			fmt.Fprintln(w, c.Output)
+		}
+	}
+
+	fmt.Fprintln(w, "\nConsul ACL policies:")
+	if len(r.ACL) == 0 {
+		fmt.Fprintln(w, "  no changes")
+	}
+	for _, c := range r.ACL {
+		fmt.Fprintf(w, "  %s: %s\n", c.Action, c.Name)
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountChanged(t *testing.T) {
+	output := `TASK [copy agent config] *******
+changed: [10.0.0.1]
+
+PLAY RECAP *********************************************************************
+10.0.0.1                   : ok=5    changed=2    unreachable=0    failed=0    skipped=0
+10.0.0.2                   : ok=5    changed=1    unreachable=0    failed=0    skipped=0
+`
+	assert.Equal(t, 3, countChanged(output))
+	assert.Equal(t, 0, countChanged("changed=4"))
+}
+
+func TestDiffConsulPolicies(t *testing.T) {
+	inv, err := ansible.LoadInventory(filepath.Join("testdata", "inventory"))
+	assert.NoError(t, err)
+
+	consul := &MockConsul{
+		ReadPolicyFunc: func(name string) (string, error) {
+			switch name {
+			case "vault":
+				return "", &hashistack.ConsulAPIError{StatusCode: 404}
+			case "fabio":
+				return "outdated", nil
+			}
+			return readPolicyTemplate(t, inv, name), nil
+		},
+	}
+	changes, err := diffConsulPolicies(consul, inv, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []PolicyChange{
+		{Name: "fabio", Action: PolicyUpdate},
+		{Name: "vault", Action: PolicyCreate},
+	}, changes)
+	assert.Len(t, consul.ReadPolicyCalls(), 7)
+}
+
+func readPolicyTemplate(t *testing.T, inv *ansible.Inventory, name string) string {
+	dir := t.TempDir()
+	assert.NoError(t, makeConsulPolicies(inv, dir))
+	b, err := os.ReadFile(consulPolicyFiles(dir)[name])
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestPlanReport(t *testing.T) {
+	plan := &tfjson.Plan{ResourceChanges: []*tfjson.ResourceChange{
+		{Address: "hcloud_server.server_node[\"a\"]", Change: &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionCreate}}},
+		{Address: "hcloud_network.private_network", Change: &tfjson.Change{Actions: tfjson.Actions{tfjson.ActionNoop}}},
+	}}
+	report := &PlanReport{
+		Infrastructure: resourceChanges(plan),
+		Config:         []PlaybookChange{{Playbook: "base.yml"}, {Playbook: "nomad.yml", Changed: 2, Output: "--- before"}},
+		ACL:            []PolicyChange{{Name: "vault", Action: PolicyCreate}},
+	}
+	assert.Len(t, report.Infrastructure, 1)
+	assert.True(t, report.HasChanges())
+
+	var out bytes.Buffer
+	report.Print(&out, true)
+	assert.Contains(t, out.String(), "create: hcloud_server.server_node[\"a\"]")
+	assert.Contains(t, out.String(), "base.yml: no changes")
+	assert.Contains(t, out.String(), "nomad.yml: 2 task(s) would change\n--- before")
+	assert.Contains(t, out.String(), "create: vault")
+
+	assert.False(t, (&PlanReport{}).HasChanges())
+}
+
+func TestCopyDir(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "secrets", "consul"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "inventory"), []byte("all:"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "secrets", "consul", "consul-agent-ca-key.pem"), []byte("key"), 0600))
+	assert.NoError(t, os.Symlink("consul-agent-ca-key.pem", filepath.Join(src, "secrets", "consul", "link.pem")))
+
+	assert.NoError(t, copyDir(src, dst))
+	key, err := os.ReadFile(filepath.Join(dst, "secrets", "consul", "link.pem"))
+	assert.NoError(t, err)
+	assert.Equal(t, "key", string(key))
+	info, err := os.Stat(filepath.Join(dst, "secrets", "consul", "consul-agent-ca-key.pem"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// rendering to the copy leaves the original as it is
+	assert.NoError(t, os.WriteFile(filepath.Join(dst, "inventory"), []byte("changed"), 0600))
+	inv, err := os.ReadFile(filepath.Join(src, "inventory"))
+	assert.NoError(t, err)
+	assert.Equal(t, "all:", string(inv))
+}
@@ -0,0 +1,107 @@
+package aws
+
+import (
+	_ "embed"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+//go:embed templates/main.tf
+var main string
+
+//go:embed templates/vars.tf
+var vars string
+
+type ResourceNames struct {
+	BaseServerName string `yaml:"base_server_name" doc:"prefix of the instance names, instances are named <base_server_name>-<group>-<n>" schema:"required"`
+	FirewallName   string `yaml:"firewall_name" doc:"name of the security group" schema:"required"`
+	NetworkName    string `yaml:"network_name" doc:"name of the VPC" schema:"required"`
+}
+
+type Subnets struct {
+	Consul        string `yaml:"consul" doc:"CIDR of the consul server subnet" schema:"required"`
+	NomadServers  string `yaml:"nomad_servers" doc:"CIDR of the nomad server subnet" schema:"required"`
+	Vault         string `yaml:"vault" doc:"CIDR of the vault subnet" schema:"required"`
+	Clients       string `yaml:"clients" doc:"CIDR of the nomad client subnet" schema:"required"`
+	Observability string `yaml:"observability" doc:"CIDR of the observability subnet" schema:"required"`
+}
+
+type Settings struct {
+	Region                    string        `yaml:"region" doc:"AWS region the instances are created in" schema:"required"`
+	VPCCIDR                   string        `yaml:"vpc_cidr" doc:"CIDR of the VPC" schema:"required"`
+	Subnets                   Subnets       `yaml:"subnets" doc:"CIDRs of the subnets, within vpc_cidr" schema:"required"`
+	KeyPairName               string        `yaml:"key_pair_name" doc:"EC2 key pair allowed to log in to the instances" schema:"required"`
+	CertificateARN            string        `yaml:"certificate_arn" doc:"ACM certificate the load balancer serves" schema:"required"`
+	ServerInstanceType        string        `yaml:"server_instance_type" doc:"instance type of the consul, nomad and vault servers, such as t3.small" schema:"required"`
+	ClientInstanceType        string        `yaml:"client_instance_type" doc:"instance type of the nomad clients" schema:"required"`
+	ObservabilityInstanceType string        `yaml:"observability_instance_type" doc:"instance type of the observability servers" schema:"required"`
+	ResourceNames             ResourceNames `yaml:"resource_names" doc:"names of the created resources" schema:"required"`
+}
+
+type aws struct{}
+
+func init() {
+	provider.Register(New(), Settings{})
+}
+
+func New() provider.Provider {
+	return &aws{}
+}
+
+func (a *aws) Name() string {
+	return "aws"
+}
+
+func (a *aws) Settings(config *conf.Config) (interface{}, error) {
+	var settings Settings
+	err := provider.DecodeSettings(config, &settings)
+	if err != nil {
+		return nil, err
+	}
+	err = provider.CheckRequired(a.Name(), map[string]string{
+		"region":                          settings.Region,
+		"vpc_cidr":                        settings.VPCCIDR,
+		"subnets.consul":                  settings.Subnets.Consul,
+		"subnets.nomad_servers":           settings.Subnets.NomadServers,
+		"subnets.vault":                   settings.Subnets.Vault,
+		"subnets.clients":                 settings.Subnets.Clients,
+		"subnets.observability":           settings.Subnets.Observability,
+		"key_pair_name":                   settings.KeyPairName,
+		"certificate_arn":                 settings.CertificateARN,
+		"server_instance_type":            settings.ServerInstanceType,
+		"client_instance_type":            settings.ClientInstanceType,
+		"observability_instance_type":     settings.ObservabilityInstanceType,
+		"resource_names.base_server_name": settings.ResourceNames.BaseServerName,
+		"resource_names.firewall_name":    settings.ResourceNames.FirewallName,
+		"resource_names.network_name":     settings.ResourceNames.NetworkName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (a *aws) RenderTerraform(config *conf.Config, dir string) error {
+	_, err := a.Settings(config)
+	if err != nil {
+		return err
+	}
+	return provider.RenderTerraform(config, dir, main, vars)
+}
+
+// RequiredEnv is empty, as the AWS terraform provider resolves credentials itself from the standard
+// AWS environment variables, shared config profiles or an instance role.
+func (a *aws) RequiredEnv() []string {
+	return []string{}
+}
+
+func (a *aws) TFVars(getenv func(string) string) ([]*tfexec.VarOption, error) {
+	return []*tfexec.VarOption{}, nil
+}
+
+func (a *aws) Inventory(outputs []byte) (*ansible.InventoryJson, error) {
+	return provider.DecodeOutputs(outputs)
+}
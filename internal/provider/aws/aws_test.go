@@ -0,0 +1,53 @@
+package aws
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/provider/providertest"
+	"github.com/stretchr/testify/assert"
+)
+
+var configFile = filepath.Join("..", "..", "testdata", "config-aws.yaml")
+
+func TestContract(t *testing.T) {
+	providertest.Contract(t, New(), configFile, filepath.Join("testdata", "inventory-output.json"))
+}
+
+func TestSettings(t *testing.T) {
+	config, err := conf.Load(configFile)
+	assert.NoError(t, err)
+
+	settings, err := New().Settings(config)
+	assert.NoError(t, err)
+
+	expected := Settings{
+		Region:  "eu-central-1",
+		VPCCIDR: "10.0.0.0/16",
+		Subnets: Subnets{
+			Consul:        "10.0.0.0/24",
+			NomadServers:  "10.0.1.0/24",
+			Vault:         "10.0.2.0/24",
+			Clients:       "10.0.3.0/24",
+			Observability: "10.0.4.0/24",
+		},
+		KeyPairName:               "openpaas",
+		CertificateARN:            "arn:aws:acm:eu-central-1:123456789012:certificate/openpaas",
+		ServerInstanceType:        "t3.small",
+		ClientInstanceType:        "t3.medium",
+		ObservabilityInstanceType: "t3.medium",
+		ResourceNames: ResourceNames{
+			BaseServerName: "nomad-srv",
+			FirewallName:   "dev_firewall",
+			NetworkName:    "dev_network",
+		},
+	}
+	assert.Equal(t, expected, settings)
+}
+
+func TestTFVars(t *testing.T) {
+	vars, err := New().TFVars(func(string) string { return "" })
+	assert.NoError(t, err)
+	assert.Empty(t, vars)
+}
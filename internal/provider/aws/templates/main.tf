@@ -0,0 +1,331 @@
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 4.60"
+    }
+  }
+}
+
+# Configure the AWS Provider, credentials are picked up from the environment
+provider "aws" {
+  region = var.region
+}
+
+data "aws_availability_zones" "available" {
+  state = "available"
+}
+
+data "aws_ami" "ubuntu" {
+  most_recent = true
+  owners      = ["099720109477"] # Canonical
+
+  filter {
+    name   = "name"
+    values = ["ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-*"]
+  }
+}
+
+locals {
+  availability_zone = data.aws_availability_zones.available.names[0]
+
+  consul_group = var.separate_consul_servers ? "consul" : "nomad-server"
+  groups = {
+    consul = {
+      count = var.separate_consul_servers ? var.server_count : 0 * var.server_count,
+      subnet = var.subnets["consul"], instance_type = var.server_instance_type
+    }
+    nomad-server = {
+      count = var.server_count, subnet = var.subnets["nomad_servers"], instance_type = var.server_instance_type
+    },
+    vault = {
+      count = var.vault_count, subnet = var.subnets["vault"], instance_type = var.server_instance_type
+    },
+    client = {
+      count = var.client_count, subnet = var.subnets["clients"], instance_type = var.client_instance_type
+    },
+    observability = {
+      count = var.multi_instance_observability ? 4 : 1, subnet = var.subnets["observability"], instance_type = var.observability_instance_type
+    }
+  }
+
+  # AWS reserves the first four addresses of every subnet, so private IPs start at .10
+  servers = flatten([
+    for name, value in local.groups : [
+      for i in range(value.count) : {
+        group_name = name,
+        private_ip = cidrhost(value.subnet, i + 10),
+        name       = "${var.base_server_name}-${name}-${i + 1}",
+        index = i
+        instance_type = value.instance_type
+      }
+    ]
+  ])
+
+  # instance IDs are strings on AWS, the inventory matches volumes to hosts by a numeric server_id
+  server_ids = { for index, server in local.servers : server.name => index }
+
+  volumes = concat(
+    [
+      for server in local.servers : {
+        key = "consul-${server.index}", server = server.name, size = var.consul_volume_size,
+        mount = "/mnt/consul-${server.index}", path = "/opt/consul", name = "", is_nomad = false
+      } if server.group_name == local.consul_group
+    ],
+    [
+      for vol in var.client_volumes : {
+        key = vol.name, server = vol.client, size = vol.size,
+        mount = "/mnt/${vol.name}", path = vol.path, name = vol.name, is_nomad = true
+      }
+    ]
+  )
+
+  volume_devices = merge([
+    for server in distinct([for vol in local.volumes : vol.server]) : {
+      for i, vol in [for v in local.volumes : v if v.server == server] : vol.key => "/dev/sd${substr("fghijklmnop", i, 1)}"
+    }
+  ]...)
+}
+
+resource "aws_vpc" "vpc" {
+  cidr_block           = var.vpc_cidr
+  enable_dns_hostnames = true
+
+  tags = {
+    Name = var.network_name
+  }
+}
+
+resource "aws_internet_gateway" "gateway" {
+  vpc_id = aws_vpc.vpc.id
+}
+
+resource "aws_route_table" "public" {
+  vpc_id = aws_vpc.vpc.id
+
+  route {
+    cidr_block = "0.0.0.0/0"
+    gateway_id = aws_internet_gateway.gateway.id
+  }
+}
+
+resource "aws_subnet" "subnet" {
+  for_each                = local.groups
+  vpc_id                  = aws_vpc.vpc.id
+  cidr_block              = each.value.subnet
+  availability_zone       = local.availability_zone
+  map_public_ip_on_launch = true
+
+  tags = {
+    Name = "${var.network_name}-${each.key}"
+  }
+}
+
+resource "aws_route_table_association" "subnet" {
+  for_each       = local.groups
+  subnet_id      = aws_subnet.subnet[each.key].id
+  route_table_id = aws_route_table.public.id
+}
+
+resource "aws_security_group" "network_firewall" {
+  name   = var.firewall_name
+  vpc_id = aws_vpc.vpc.id
+
+  ingress {
+    protocol    = "tcp"
+    from_port   = 1
+    to_port     = 10000
+    cidr_blocks = var.allow_ips
+  }
+
+  ingress {
+    protocol    = "icmp"
+    from_port   = -1
+    to_port     = -1
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+
+  # cluster nodes talk to each other over the private network
+  ingress {
+    protocol    = "-1"
+    from_port   = 0
+    to_port     = 0
+    cidr_blocks = [var.vpc_cidr]
+  }
+
+  egress {
+    protocol    = "-1"
+    from_port   = 0
+    to_port     = 0
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+resource "aws_ebs_volume" "volume" {
+  for_each          = { for vol in local.volumes : vol.key => vol }
+  availability_zone = local.availability_zone
+  size              = each.value.size
+  type              = "gp3"
+
+  tags = {
+    Name = each.key
+  }
+}
+
+resource "aws_instance" "server_node" {
+  for_each               = { for entry in local.servers : "${entry.name}" => entry }
+  ami                    = data.aws_ami.ubuntu.id
+  instance_type          = each.value.instance_type
+  key_name               = var.key_pair_name
+  subnet_id              = aws_subnet.subnet[each.value.group_name].id
+  private_ip             = each.value.private_ip
+  vpc_security_group_ids = [aws_security_group.network_firewall.id]
+
+  # format and mount the EBS volumes of the node once they are attached, ansible expects them at their mount path
+  user_data = join("\n", concat([
+    "#!/bin/bash",
+    "mount_volume() {",
+    "  device=/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_$1",
+    "  until [ -e $device ]; do sleep 5; done",
+    "  blkid $device || mkfs.ext4 $device",
+    "  mkdir -p $2",
+    "  echo \"$device $2 ext4 defaults,nofail 0 2\" >> /etc/fstab",
+    "  mount $2",
+    "}",
+    ], [
+    for vol in local.volumes : "mount_volume ${replace(aws_ebs_volume.volume[vol.key].id, "-", "")} ${vol.mount} &" if vol.server == each.key
+    ], ["wait"]))
+
+  tags = {
+    Name  = each.value.name
+    group = each.value.group_name
+  }
+
+  lifecycle {
+    ignore_changes = [ami]
+  }
+
+  depends_on = [aws_route_table_association.subnet]
+}
+
+resource "aws_volume_attachment" "volume" {
+  for_each    = { for vol in local.volumes : vol.key => vol }
+  device_name = local.volume_devices[each.key]
+  volume_id   = aws_ebs_volume.volume[each.key].id
+  instance_id = aws_instance.server_node[each.value.server].id
+}
+
+resource "aws_lb" "lb1" {
+  name               = "lb1"
+  load_balancer_type = "network"
+  subnets            = [aws_subnet.subnet["client"].id]
+}
+
+resource "aws_lb_target_group" "clients" {
+  name        = "clients"
+  port        = 80
+  protocol    = "TCP"
+  vpc_id      = aws_vpc.vpc.id
+  target_type = "ip"
+}
+
+resource "aws_lb_listener" "https" {
+  load_balancer_arn = aws_lb.lb1.arn
+  port              = 443
+  protocol          = "TLS"
+  certificate_arn   = var.certificate_arn
+
+  default_action {
+    type             = "forward"
+    target_group_arn = aws_lb_target_group.clients.arn
+  }
+}
+
+resource "aws_lb_target_group_attachment" "clients" {
+  for_each         = { for entry in local.servers : entry.name => entry if entry.group_name == "client" }
+  target_group_arn = aws_lb_target_group.clients.arn
+  target_id        = aws_instance.server_node[each.key].private_ip
+  port             = 80
+}
+
+
+output "consul_servers" {
+  value = [
+    for server in local.servers :
+    {host = aws_instance.server_node[server.name].public_ip,
+      host_name = server.name,
+      private_ip = server.private_ip,
+      server_id = tostring(local.server_ids[server.name])
+    } if server.group_name == "consul"
+  ]
+}
+
+output "nomad_servers" {
+  value = [
+    for server in local.servers :
+    {host = aws_instance.server_node[server.name].public_ip,
+      host_name = server.name,
+      private_ip = server.private_ip,
+      server_id = tostring(local.server_ids[server.name])
+    } if server.group_name == "nomad-server"
+  ]
+}
+
+output "vault_servers" {
+  value = [
+    for server in local.servers :
+    {host = aws_instance.server_node[server.name].public_ip,
+      host_name = server.name,
+      private_ip = server.private_ip,
+      server_id = tostring(local.server_ids[server.name])
+    } if server.group_name == "vault"
+  ]
+}
+
+output "client_servers" {
+  value = [
+    for server in local.servers :
+    {host = aws_instance.server_node[server.name].public_ip,
+      host_name = server.name,
+      private_ip = server.private_ip,
+      server_id = tostring(local.server_ids[server.name])
+    } if server.group_name == "client"
+  ]
+}
+
+output "o11y_servers" {
+  value = [
+    for server in local.servers :
+    {host = aws_instance.server_node[server.name].public_ip,
+      host_name = server.name,
+      private_ip = server.private_ip,
+      server_id = tostring(local.server_ids[server.name])
+    } if server.group_name == "observability"
+  ]
+}
+
+output "consul_volumes" {
+  depends_on = [aws_volume_attachment.volume]
+  value = [
+    for vol in local.volumes :
+    {mount = vol.mount,
+      path = vol.path,
+      name = vol.name,
+      server_id = local.server_ids[vol.server],
+      is_nomad = false
+    } if !vol.is_nomad
+  ]
+}
+
+output "client_volumes" {
+  depends_on = [aws_volume_attachment.volume]
+  value = [
+    for vol in local.volumes :
+    {mount = vol.mount,
+      path = vol.path,
+      name = vol.name,
+      server_id = local.server_ids[vol.server],
+      is_nomad = true
+    } if vol.is_nomad
+  ]
+}
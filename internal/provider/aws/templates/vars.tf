@@ -0,0 +1,116 @@
+variable "server_count" {
+  type = number
+  default = {{.ClusterConfig.Servers}}
+}
+
+variable "consul_volume_size" {
+  type = number
+  default = {{.ClusterConfig.ConsulVolumeSize}}
+}
+
+variable "client_count" {
+  type = number
+  default = {{.ClusterConfig.Clients}}
+}
+
+variable "vault_count" {
+  type = number
+  default = {{.ClusterConfig.VaultServers}}
+}
+
+variable "separate_consul_servers"{
+  type = bool
+  default = {{.ClusterConfig.SeparateConsulServers}}
+}
+
+variable "client_volumes" {
+  type = list
+  default = [{{ range $key, $value := .ClusterConfig.ClientVolumes}}
+   {
+    name = "{{ $value.Name }}"
+    client = "{{ $value.Client}}"
+    path = "{{ $value.Path}}"
+    size = {{ $value.Size }}
+   },{{ end }}
+  ]
+}
+
+variable "multi_instance_observability" {
+  type = bool
+  default = {{.ObservabilityConfig.MultiInstance}}
+}
+
+variable "region" {
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.region}}"
+}
+
+variable "vpc_cidr" {
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.vpc_cidr}}"
+}
+
+variable "subnets" {
+  type = map(string)
+  default = {
+    consul = "{{.CloudProviderConfig.ProviderSettings.subnets.consul}}"
+    nomad_servers = "{{.CloudProviderConfig.ProviderSettings.subnets.nomad_servers}}"
+    vault = "{{.CloudProviderConfig.ProviderSettings.subnets.vault}}"
+    clients = "{{.CloudProviderConfig.ProviderSettings.subnets.clients}}"
+    observability = "{{.CloudProviderConfig.ProviderSettings.subnets.observability}}"
+  }
+}
+
+variable "key_pair_name" {
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.key_pair_name}}"
+}
+
+variable "base_server_name" {
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.resource_names.base_server_name}}"
+}
+
+variable "firewall_name" {
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.resource_names.firewall_name}}"
+}
+
+variable "network_name" {
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.resource_names.network_name}}"
+}
+
+variable "allow_ips" {
+  type = list
+  default = [{{ range $key, $value := .CloudProviderConfig.AllowedIPs}}
+   "{{ $value }}",{{ end }}
+  ]
+}
+
+variable "https_allowed_ips" {
+  type = list
+  default = [{{ range $key, $value := .CloudProviderConfig.ProviderSettings.https_allowed_ips}}
+   "{{ $value }}",{{ end }}
+  ]
+}
+
+variable "certificate_arn" {
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.certificate_arn}}"
+}
+
+variable "server_instance_type"{
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.server_instance_type}}"
+}
+
+variable "client_instance_type"{
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.client_instance_type}}"
+}
+
+variable "observability_instance_type"{
+  type = string
+  default = "{{.CloudProviderConfig.ProviderSettings.observability_instance_type}}"
+}
@@ -0,0 +1,97 @@
+package hetzner
+
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+//go:embed templates/main.tf
+var main string
+
+//go:embed templates/vars.tf
+var vars string
+
+const TokenEnv = "HETZNER_TOKEN"
+
+type ResourceNames struct {
+	BaseServerName string `yaml:"base_server_name" doc:"prefix of the server names, servers are named <base_server_name>-<group>-<n>" schema:"required"`
+	FirewallName   string `yaml:"firewall_name" doc:"name of the firewall" schema:"required"`
+	NetworkName    string `yaml:"network_name" doc:"name of the private network" schema:"required"`
+}
+
+type Settings struct {
+	Location                  string        `yaml:"location" doc:"hetzner location the servers are created in" schema:"required,enum=fsn1|nbg1|hel1|ash|hil|sin"`
+	SSHKeys                   []string      `yaml:"ssh_keys" doc:"names of the hetzner SSH keys allowed to log in to the servers"`
+	ServerInstanceType        string        `yaml:"server_instance_type" doc:"server type of the consul, nomad and vault servers, such as cx21" schema:"required"`
+	ClientInstanceType        string        `yaml:"client_instance_type" doc:"server type of the nomad clients" schema:"required"`
+	ObservabilityInstanceType string        `yaml:"observability_instance_type" doc:"server type of the observability servers" schema:"required"`
+	LoadBalancerType          string        `yaml:"load_balancer_type" doc:"type of the load balancer in front of the clients" schema:"required,enum=lb11|lb21|lb31"`
+	SSLCertificateIDs         []int         `yaml:"ssl_certificate_ids" doc:"numeric ids of the hetzner certificates the load balancer serves, see hcloud certificate list"`
+	ResourceNames             ResourceNames `yaml:"resource_names" doc:"names of the created resources" schema:"required"`
+}
+
+type hetzner struct{}
+
+func init() {
+	provider.Register(New(), Settings{})
+}
+
+func New() provider.Provider {
+	return &hetzner{}
+}
+
+func (h *hetzner) Name() string {
+	return "hetzner"
+}
+
+func (h *hetzner) Settings(config *conf.Config) (interface{}, error) {
+	var settings Settings
+	err := provider.DecodeSettings(config, &settings)
+	if err != nil {
+		return nil, err
+	}
+	err = provider.CheckRequired(h.Name(), map[string]string{
+		"location":                        settings.Location,
+		"server_instance_type":            settings.ServerInstanceType,
+		"client_instance_type":            settings.ClientInstanceType,
+		"observability_instance_type":     settings.ObservabilityInstanceType,
+		"load_balancer_type":              settings.LoadBalancerType,
+		"resource_names.base_server_name": settings.ResourceNames.BaseServerName,
+		"resource_names.firewall_name":    settings.ResourceNames.FirewallName,
+		"resource_names.network_name":     settings.ResourceNames.NetworkName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (h *hetzner) RenderTerraform(config *conf.Config, dir string) error {
+	_, err := h.Settings(config)
+	if err != nil {
+		return err
+	}
+	return provider.RenderTerraform(config, dir, main, vars)
+}
+
+func (h *hetzner) RequiredEnv() []string {
+	return []string{TokenEnv}
+}
+
+func (h *hetzner) TFVars(getenv func(string) string) ([]*tfexec.VarOption, error) {
+	token := getenv(TokenEnv)
+	err := provider.CheckRequired(h.Name(), map[string]string{TokenEnv: token})
+	if err != nil {
+		return nil, err
+	}
+	return []*tfexec.VarOption{tfexec.Var(fmt.Sprintf("hcloud_token=%s", token))}, nil
+}
+
+func (h *hetzner) Inventory(outputs []byte) (*ansible.InventoryJson, error) {
+	return provider.DecodeOutputs(outputs)
+}
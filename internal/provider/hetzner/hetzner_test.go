@@ -0,0 +1,57 @@
+package hetzner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+	"github.com/OpenPaaSDev/openpaas/internal/provider/providertest"
+	"github.com/stretchr/testify/assert"
+)
+
+var configFile = filepath.Join("..", "..", "testdata", "config.yaml")
+
+func TestContract(t *testing.T) {
+	providertest.Contract(t, New(), configFile, filepath.Join("..", "..", "ansible", "testdata", "inventory-output.json"))
+}
+
+func TestSettings(t *testing.T) {
+	config, err := conf.Load(configFile)
+	assert.NoError(t, err)
+
+	settings, err := New().Settings(config)
+	assert.NoError(t, err)
+
+	expected := Settings{
+		SSHKeys:                   []string{"wille.faler@gmail.com"},
+		ServerInstanceType:        "cx21",
+		ClientInstanceType:        "cx21",
+		ObservabilityInstanceType: "cx21",
+		LoadBalancerType:          "lb11",
+		Location:                  "nbg1",
+		ResourceNames: ResourceNames{
+			BaseServerName: "nomad-srv",
+			FirewallName:   "dev_firewall",
+			NetworkName:    "dev_network",
+		},
+	}
+	assert.Equal(t, expected, settings)
+}
+
+func TestTFVars(t *testing.T) {
+	_, err := New().TFVars(func(string) string { return "" })
+	var missing *provider.MissingSettingsError
+	assert.ErrorAs(t, err, &missing)
+	assert.Equal(t, []string{TokenEnv}, missing.Keys)
+
+	vars, err := New().TFVars(func(string) string { return "token" })
+	assert.NoError(t, err)
+	assert.Len(t, vars, 1)
+}
+
+func TestSampleConfigIsValid(t *testing.T) {
+	config, err := conf.Load(filepath.Join("..", "..", "..", "config.yaml"))
+	assert.NoError(t, err)
+	assert.NoError(t, config.Validate())
+}
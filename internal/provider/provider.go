@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/hashicorp/terraform-exec/tfexec"
+	"gopkg.in/yaml.v3"
+)
+
+// Provider is a cloud provider openpaas can create a cluster on. Implementations live in their own
+// package and make themselves available by calling Register from an init function.
+type Provider interface {
+	// Name is the value of cloud_provider_config.provider that selects the provider.
+	Name() string
+	// Settings decodes and validates the provider_settings of config into the provider's typed settings.
+	Settings(config *conf.Config) (interface{}, error)
+	// RenderTerraform writes the main.tf and vars.tf for config into dir.
+	RenderTerraform(config *conf.Config, dir string) error
+	// RequiredEnv lists the environment variables holding the credentials the provider needs.
+	RequiredEnv() []string
+	// TFVars returns the terraform variables the generated terraform needs on plan, apply and destroy,
+	// reading credentials through getenv.
+	TFVars(getenv func(string) string) ([]*tfexec.VarOption, error)
+	// Inventory maps the terraform outputs of a cluster to the hosts and volumes of the inventory.
+	Inventory(outputs []byte) (*ansible.InventoryJson, error)
+}
+
+// Static is implemented by providers for machines that already exist. They describe the hosts of the
+// cluster themselves, so no terraform is generated or applied for them.
+type Static interface {
+	Provider
+	Hosts(config *conf.Config) (*ansible.InventoryJson, error)
+}
+
+var (
+	ErrUnknownProvider = errors.New("not a supported cloud provider")
+	ErrNoTerraform     = errors.New("provider does not use terraform")
+)
+
+// MissingSettingsError is returned when required provider_settings or credentials are not set.
+type MissingSettingsError struct {
+	Provider string
+	Keys     []string
+}
+
+func (e *MissingSettingsError) Error() string {
+	return fmt.Sprintf("%s: missing required settings: %s", e.Provider, strings.Join(e.Keys, ", "))
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{}
+)
+
+// Register makes a provider available under its name, and settings, an empty value of its typed settings,
+// known to conf.Config.Validate. It panics if a provider with the same name is already registered, as that
+// is a programming error.
+func Register(p Provider, settings interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := providers[p.Name()]; ok {
+		panic(fmt.Sprintf("provider %s is already registered", p.Name()))
+	}
+	providers[p.Name()] = p
+	_, static := p.(Static)
+	conf.RegisterProvider(p.Name(), conf.ProviderSettings{
+		Settings: settings,
+		Static:   static,
+		Check: func(config *conf.Config) []conf.Problem {
+			_, err := p.Settings(config)
+			return problems(err)
+		},
+	})
+}
+
+// problems converts an error of Provider.Settings into problems with paths relative to provider_settings.
+func problems(err error) []conf.Problem {
+	if err == nil {
+		return nil
+	}
+	var missing *MissingSettingsError
+	if !errors.As(err, &missing) {
+		return []conf.Problem{{Message: err.Error()}}
+	}
+	res := []conf.Problem{}
+	for _, key := range missing.Keys {
+		res = append(res, conf.Problem{Path: key, Message: "is required"})
+	}
+	return res
+}
+
+// Get returns the provider registered under name.
+func Get(name string) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is %w, expected one of %s", name, ErrUnknownProvider, strings.Join(names(), ", "))
+	}
+	return p, nil
+}
+
+// For returns the provider selected in config.
+func For(config *conf.Config) (Provider, error) {
+	return Get(config.CloudProviderConfig.Provider)
+}
+
+// Names returns the names of all registered providers, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return names()
+}
+
+func names() []string {
+	res := []string{}
+	for name := range providers {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// DecodeSettings decodes the untyped provider_settings of config into out.
+func DecodeSettings(config *conf.Config, out interface{}) error {
+	bytes, err := yaml.Marshal(config.CloudProviderConfig.ProviderSettings)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(bytes, out)
+}
+
+// CheckRequired returns a MissingSettingsError naming every key in values that has an empty value.
+func CheckRequired(provider string, values map[string]string) error {
+	missing := []string{}
+	for key, value := range values {
+		if value == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return &MissingSettingsError{Provider: provider, Keys: missing}
+}
+
+// RenderTerraform renders the vars template with config and writes it along with main into dir.
+func RenderTerraform(config *conf.Config, dir, main, vars string) error {
+	tmpl, e := template.New("tf-vars").Parse(vars)
+	if e != nil {
+		return e
+	}
+	var buf bytes.Buffer
+
+	allowedIps := []string{}
+
+	if config.CloudProviderConfig.ProviderSettings == nil {
+		config.CloudProviderConfig.ProviderSettings = map[string]interface{}{}
+	}
+	config.CloudProviderConfig.ProviderSettings["https_allowed_ips"] = allowedIps
+
+	err := tmpl.Execute(&buf, config)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Clean(dir), 0750)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Clean(filepath.Join(dir, "vars.tf")), buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Clean(filepath.Join(dir, "main.tf")), []byte(main), 0600)
+}
+
+// DecodeOutputs decodes terraform outputs that already follow the inventory layout.
+func DecodeOutputs(outputs []byte) (*ansible.InventoryJson, error) {
+	var inventory ansible.InventoryJson
+	err := json.Unmarshal(outputs, &inventory)
+	if err != nil {
+		return nil, err
+	}
+	return &inventory, nil
+}
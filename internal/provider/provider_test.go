@@ -0,0 +1,24 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUnknownProvider(t *testing.T) {
+	_, err := For(&conf.Config{CloudProviderConfig: conf.CloudProvider{Provider: "digitalocean"}})
+	assert.True(t, errors.Is(err, ErrUnknownProvider))
+}
+
+func TestCheckRequired(t *testing.T) {
+	assert.NoError(t, CheckRequired("test", map[string]string{"a": "1"}))
+
+	err := CheckRequired("test", map[string]string{"b": "", "a": "", "c": "3"})
+	var missing *MissingSettingsError
+	assert.True(t, errors.As(err, &missing))
+	assert.Equal(t, []string{"a", "b"}, missing.Keys)
+	assert.Equal(t, "test: missing required settings: a, b", err.Error())
+}
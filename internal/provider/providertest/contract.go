@@ -0,0 +1,201 @@
+// Package providertest holds the contract every cloud provider has to fulfil, for use in the tests of
+// the provider packages.
+package providertest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hclparse"
+	"github.com/stretchr/testify/assert"
+)
+
+// Outputs are the terraform outputs ansible.WriteInventory builds the inventory from.
+var Outputs = []string{"consul_servers", "nomad_servers", "vault_servers", "client_servers", "o11y_servers", "consul_volumes", "client_volumes"}
+
+var varReference = regexp.MustCompile(`var\.([a-zA-Z0-9_]+)`)
+
+var tfSchema = &hcl.BodySchema{Blocks: []hcl.BlockHeaderSchema{
+	{Type: "terraform"},
+	{Type: "provider", LabelNames: []string{"name"}},
+	{Type: "data", LabelNames: []string{"type", "name"}},
+	{Type: "locals"},
+	{Type: "resource", LabelNames: []string{"type", "name"}},
+	{Type: "variable", LabelNames: []string{"name"}},
+	{Type: "output", LabelNames: []string{"name"}},
+}}
+
+// Contract runs the tests every provider has to pass. configFile must be a valid config selecting p and
+// outputsFile the terraform outputs of a cluster created by p, which static providers do not have.
+func Contract(t *testing.T, p provider.Provider, configFile, outputsFile string) {
+	static, isStatic := p.(provider.Static)
+
+	t.Run("registered", func(t *testing.T) {
+		registered, err := provider.Get(p.Name())
+		assert.NoError(t, err)
+		if registered != nil {
+			assert.Equal(t, p.Name(), registered.Name())
+		}
+		assert.Contains(t, provider.Names(), p.Name())
+	})
+
+	t.Run("settings", func(t *testing.T) {
+		config := load(t, configFile)
+		assert.Equal(t, p.Name(), config.CloudProviderConfig.Provider)
+		settings, err := p.Settings(config)
+		assert.NoError(t, err)
+		assert.NotNil(t, settings)
+		assert.NoError(t, config.Validate())
+
+		config.CloudProviderConfig.ProviderSettings = map[string]interface{}{}
+		_, err = p.Settings(config)
+		var missing *provider.MissingSettingsError
+		assert.True(t, errors.As(err, &missing), "empty provider_settings must be rejected, got %v", err)
+		var invalid *conf.ValidationError
+		assert.True(t, errors.As(config.Validate(), &invalid), "empty provider_settings must not validate")
+		if missing != nil {
+			schema := settingsSchema(p.Name())
+			for _, key := range missing.Keys {
+				assert.True(t, isRequired(schema, key), "%s is required by the provider, but not by its schema", key)
+			}
+		}
+		assert.Error(t, p.RenderTerraform(config, t.TempDir()))
+	})
+
+	t.Run("terraform", func(t *testing.T) {
+		config := load(t, configFile)
+		if isStatic {
+			assert.ErrorIs(t, p.RenderTerraform(config, t.TempDir()), provider.ErrNoTerraform)
+			return
+		}
+		folder := util.RandString(8)
+		defer func() {
+			e := os.RemoveAll(filepath.Clean(folder))
+			assert.NoError(t, e)
+		}()
+		err := p.RenderTerraform(config, folder)
+		assert.NoError(t, err)
+
+		parser := hclparse.NewParser()
+		varsFile, diags := parser.ParseHCLFile(filepath.Join(folder, "vars.tf"))
+		assert.False(t, diags.HasErrors(), diags.Error())
+		mainFile, diags := parser.ParseHCLFile(filepath.Join(folder, "main.tf"))
+		assert.False(t, diags.HasErrors(), diags.Error())
+		if varsFile == nil || mainFile == nil {
+			return
+		}
+
+		declared := map[string]bool{}
+		vars, _ := varsFile.Body.Content(tfSchema)
+		for _, block := range vars.Blocks.OfType("variable") {
+			declared[block.Labels[0]] = true
+		}
+		for _, ref := range varReference.FindAllStringSubmatch(string(mainFile.Bytes), -1) {
+			assert.True(t, declared[ref[1]], "main.tf uses var.%s, which vars.tf does not declare", ref[1])
+		}
+
+		outputs := []string{}
+		content, _ := mainFile.Body.Content(tfSchema)
+		for _, block := range content.Blocks.OfType("output") {
+			outputs = append(outputs, block.Labels[0])
+		}
+		assert.ElementsMatch(t, Outputs, outputs)
+	})
+
+	t.Run("credentials", func(t *testing.T) {
+		_, err := p.TFVars(func(string) string { return "" })
+		if len(p.RequiredEnv()) > 0 {
+			assert.Error(t, err, "missing credentials must be rejected")
+		} else {
+			assert.NoError(t, err)
+		}
+
+		env := map[string]string{}
+		for i, key := range p.RequiredEnv() {
+			env[key] = fmt.Sprintf("credential-%d", i)
+		}
+		tfVars, err := p.TFVars(func(key string) string { return env[key] })
+		assert.NoError(t, err)
+		assert.NotNil(t, tfVars)
+	})
+
+	t.Run("inventory", func(t *testing.T) {
+		var inventory *ansible.InventoryJson
+		if isStatic {
+			var err error
+			inventory, err = static.Hosts(load(t, configFile))
+			assert.NoError(t, err)
+		} else {
+			outputs, err := os.ReadFile(filepath.Clean(outputsFile))
+			assert.NoError(t, err)
+			inventory, err = p.Inventory(outputs)
+			assert.NoError(t, err)
+		}
+		if inventory == nil {
+			return
+		}
+		assert.NotEmpty(t, inventory.NomadServers.Value)
+		assert.NotEmpty(t, inventory.Clients.Value)
+		assert.NotEmpty(t, inventory.ObservabilityServers.Value)
+
+		hosts := map[string]bool{}
+		for _, group := range [][]ansible.Host{inventory.ConsulServers.Value, inventory.NomadServers.Value, inventory.Clients.Value} {
+			for _, h := range group {
+				assert.NotEmpty(t, h.Host)
+				assert.NotEmpty(t, h.PrivateIP)
+				hosts[h.ServerID] = true
+			}
+		}
+		for _, vol := range append(inventory.ConsulVolumes.Value, inventory.ClientVolumes.Value...) {
+			assert.True(t, hosts[fmt.Sprintf("%v", vol.ServerID)], "volume %s is not attached to a known host", vol.Mount)
+		}
+	})
+}
+
+// settingsSchema returns the schema of the provider_settings of provider from the exported schema.
+func settingsSchema(provider string) *conf.Schema {
+	for _, variant := range conf.JSONSchema().AllOf {
+		if variant.If.Properties["cloud_provider_config"].Properties["provider"].Const == provider {
+			return variant.Then.Properties["cloud_provider_config"].Properties["provider_settings"]
+		}
+	}
+	return nil
+}
+
+// isRequired reports whether schema requires the dotted key and all of its parents.
+func isRequired(schema *conf.Schema, key string) bool {
+	for _, name := range strings.Split(key, ".") {
+		if schema == nil || !contains(schema.Required, name) {
+			return false
+		}
+		schema = schema.Properties[name]
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func load(t *testing.T, configFile string) *conf.Config {
+	config, err := conf.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
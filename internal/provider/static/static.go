@@ -0,0 +1,195 @@
+package static
+
+import (
+	"fmt"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+// consulPath is where the consul data volume is linked to on consul servers.
+const consulPath = "/opt/consul"
+
+type Volume struct {
+	Name  string `yaml:"name" doc:"name of the volume, required on client servers"`
+	Path  string `yaml:"path" doc:"path the volume is linked to, /opt/consul for the consul data volume" schema:"required"`
+	Mount string `yaml:"mount" doc:"where the volume is mounted already" schema:"required"`
+}
+
+type Host struct {
+	Host      string   `yaml:"host" doc:"address ansible connects to" schema:"required"`
+	PrivateIP string   `yaml:"private_ip" doc:"address in the private network of the cluster" schema:"required"`
+	HostName  string   `yaml:"host_name" doc:"host name of the machine" schema:"required"`
+	Volumes   []Volume `yaml:"volumes" doc:"volumes mounted on the machine"`
+}
+
+// Settings lists the pre-provisioned machines per role. The same machine can take several roles by
+// listing it under each of them. Volumes have to be mounted at their mount path already.
+type Settings struct {
+	ConsulServers        []Host `yaml:"consul_servers" doc:"consul servers, 3 or 5, when consul does not run on the nomad servers"`
+	NomadServers         []Host `yaml:"nomad_servers" doc:"nomad servers, 3 or 5" schema:"required"`
+	VaultServers         []Host `yaml:"vault_servers" doc:"vault servers" schema:"required"`
+	ClientServers        []Host `yaml:"client_servers" doc:"nomad clients" schema:"required"`
+	ObservabilityServers []Host `yaml:"o11y_servers" doc:"observability servers, 1 or 4" schema:"required"`
+}
+
+type static struct{}
+
+func init() {
+	provider.Register(New(), Settings{})
+}
+
+func New() provider.Static {
+	return &static{}
+}
+
+func (s *static) Name() string {
+	return "static"
+}
+
+func (s *static) Settings(config *conf.Config) (interface{}, error) {
+	var settings Settings
+	err := provider.DecodeSettings(config, &settings)
+	if err != nil {
+		return nil, err
+	}
+	err = s.validate(settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (s *static) validate(settings Settings) error {
+	groups := map[string][]Host{
+		"consul_servers": settings.ConsulServers,
+		"nomad_servers":  settings.NomadServers,
+		"vault_servers":  settings.VaultServers,
+		"client_servers": settings.ClientServers,
+		"o11y_servers":   settings.ObservabilityServers,
+	}
+	required := map[string]string{}
+	for _, group := range []string{"nomad_servers", "vault_servers", "client_servers", "o11y_servers"} {
+		if len(groups[group]) == 0 {
+			required[group] = ""
+		}
+	}
+	for group, hosts := range groups {
+		for i, h := range hosts {
+			prefix := fmt.Sprintf("%s[%d].", group, i)
+			required[prefix+"host"] = h.Host
+			required[prefix+"private_ip"] = h.PrivateIP
+			required[prefix+"host_name"] = h.HostName
+			for j, v := range h.Volumes {
+				required[fmt.Sprintf("%svolumes[%d].path", prefix, j)] = v.Path
+				required[fmt.Sprintf("%svolumes[%d].mount", prefix, j)] = v.Mount
+				if group == "client_servers" && v.Path != consulPath {
+					required[fmt.Sprintf("%svolumes[%d].name", prefix, j)] = v.Name
+				}
+			}
+		}
+	}
+	err := provider.CheckRequired(s.Name(), required)
+	if err != nil {
+		return err
+	}
+	if n := len(settings.NomadServers); n != 3 && n != 5 {
+		return fmt.Errorf("%s: nomad_servers must list 3 or 5 hosts for a quorum, got %d", s.Name(), n)
+	}
+	if n := len(settings.ConsulServers); n != 0 && n != 3 && n != 5 {
+		return fmt.Errorf("%s: consul_servers must list 3 or 5 hosts for a quorum, got %d", s.Name(), n)
+	}
+	if n := len(settings.ObservabilityServers); n != 1 && n != 4 {
+		return fmt.Errorf("%s: o11y_servers must list 1 or 4 hosts, got %d", s.Name(), n)
+	}
+	return nil
+}
+
+func (s *static) Hosts(config *conf.Config) (*ansible.InventoryJson, error) {
+	raw, err := s.Settings(config)
+	if err != nil {
+		return nil, err
+	}
+	settings := raw.(Settings)
+
+	// machines listed under several roles keep the same server id, which is what volumes are matched by
+	ids := map[string]int{}
+	serverID := func(h Host) int {
+		id, ok := ids[h.Host]
+		if !ok {
+			id = len(ids)
+			ids[h.Host] = id
+		}
+		return id
+	}
+	hostValues := func(hosts []Host) ansible.HostValues {
+		values := ansible.HostValues{Value: []ansible.Host{}}
+		for _, h := range hosts {
+			values.Value = append(values.Value, ansible.Host{
+				Host:      h.Host,
+				HostName:  h.HostName,
+				PrivateIP: h.PrivateIP,
+				ServerID:  fmt.Sprintf("%d", serverID(h)),
+			})
+		}
+		return values
+	}
+
+	inventory := &ansible.InventoryJson{
+		ConsulServers:        hostValues(settings.ConsulServers),
+		NomadServers:         hostValues(settings.NomadServers),
+		VaultServers:         hostValues(settings.VaultServers),
+		Clients:              hostValues(settings.ClientServers),
+		ObservabilityServers: hostValues(settings.ObservabilityServers),
+		ConsulVolumes:        ansible.Volumes{Value: []ansible.Volume{}},
+		ClientVolumes:        ansible.Volumes{Value: []ansible.Volume{}},
+	}
+
+	consulHosts := settings.ConsulServers
+	if len(consulHosts) == 0 {
+		consulHosts = settings.NomadServers
+	}
+	for _, h := range consulHosts {
+		for _, v := range h.Volumes {
+			if v.Path == consulPath {
+				inventory.ConsulVolumes.Value = append(inventory.ConsulVolumes.Value, ansible.Volume{
+					Mount:    v.Mount,
+					Path:     v.Path,
+					ServerID: serverID(h),
+				})
+			}
+		}
+	}
+	for _, h := range settings.ClientServers {
+		for _, v := range h.Volumes {
+			if v.Path == consulPath {
+				continue
+			}
+			inventory.ClientVolumes.Value = append(inventory.ClientVolumes.Value, ansible.Volume{
+				Mount:    v.Mount,
+				Name:     v.Name,
+				Path:     v.Path,
+				ServerID: serverID(h),
+			})
+		}
+	}
+	return inventory, nil
+}
+
+func (s *static) RenderTerraform(config *conf.Config, dir string) error {
+	return provider.ErrNoTerraform
+}
+
+func (s *static) RequiredEnv() []string {
+	return []string{}
+}
+
+func (s *static) TFVars(getenv func(string) string) ([]*tfexec.VarOption, error) {
+	return []*tfexec.VarOption{}, nil
+}
+
+func (s *static) Inventory(outputs []byte) (*ansible.InventoryJson, error) {
+	return nil, provider.ErrNoTerraform
+}
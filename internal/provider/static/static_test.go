@@ -0,0 +1,67 @@
+package static
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+	"github.com/OpenPaaSDev/openpaas/internal/provider/providertest"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+var configFile = filepath.Join("..", "..", "testdata", "config-static.yaml")
+
+func TestContract(t *testing.T) {
+	providertest.Contract(t, New(), configFile, "")
+}
+
+func TestHosts(t *testing.T) {
+	config, err := conf.Load(configFile)
+	assert.NoError(t, err)
+
+	folder := util.RandString(8)
+	config.BaseDir = folder
+	err = os.MkdirAll(folder, 0700)
+	assert.NoError(t, err)
+	defer func() {
+		e := os.RemoveAll(filepath.Join(folder))
+		assert.NoError(t, e)
+	}()
+
+	hosts, err := New().Hosts(config)
+	assert.NoError(t, err)
+	assert.Len(t, hosts.NomadServers.Value, 3)
+	assert.Empty(t, hosts.ConsulServers.Value)
+	// node-1 is both a nomad and a vault server
+	assert.Equal(t, hosts.NomadServers.Value[0].ServerID, hosts.VaultServers.Value[0].ServerID)
+	assert.Equal(t, []ansible.Volume{{Mount: "/mnt/consul", Path: "/opt/consul", ServerID: 0}}, hosts.ConsulVolumes.Value)
+
+	inventory, err := ansible.WriteInventory(config, hosts)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(folder, "inventory"))
+
+	consul := inventory.All.Children.ConsulServers.Hosts["192.168.56.10"]
+	assert.Equal(t, "/mnt/consul", consul.Mounts[0].MountPath)
+	assert.Len(t, inventory.All.Children.ConsulServers.Hosts, 3)
+
+	client := inventory.All.Children.Clients.Hosts["192.168.56.20"]
+	assert.Equal(t, []ansible.Mount{{Name: "data_vol", Path: "/opt/nomad_client_data", MountPath: "/mnt/data", IsNomad: true, Owner: "root"}}, client.Mounts)
+	assert.Len(t, inventory.All.Children.Grafana.Hosts, 1)
+}
+
+func TestSettingsValidation(t *testing.T) {
+	config, err := conf.Load(configFile)
+	assert.NoError(t, err)
+	config.CloudProviderConfig.ProviderSettings["client_servers"] = []map[string]interface{}{
+		{"host": "192.168.56.20", "host_name": "worker-1", "volumes": []map[string]string{{"path": "/data", "mount": "/mnt/data"}}},
+	}
+
+	_, err = New().Settings(config)
+	var missing *provider.MissingSettingsError
+	assert.ErrorAs(t, err, &missing)
+	assert.Equal(t, []string{"client_servers[0].private_ip", "client_servers[0].volumes[0].name"}, missing.Keys)
+}
@@ -0,0 +1,89 @@
+package runtime
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// minSecretLength keeps short values, such as placeholders, from being redacted all over the output.
+const minSecretLength = 8
+
+const redacted = "[REDACTED]"
+
+var (
+	secretsMu    sync.RWMutex
+	secretValues = []string{}
+)
+
+// RegisterSecret marks values that must never be logged. Every command line and every output written to
+// the terminal is redacted of them.
+func RegisterSecret(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		if len(v) < minSecretLength || containsString(secretValues, v) {
+			continue
+		}
+		secretValues = append(secretValues, v)
+	}
+	// longer values first, so a secret containing another one is redacted as a whole
+	sort.Slice(secretValues, func(i, j int) bool {
+		return len(secretValues[i]) > len(secretValues[j])
+	})
+}
+
+// Redact replaces the registered secret values in s.
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, v := range secretValues {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	return s
+}
+
+// RedactWriter is an io.Writer that redacts registered secrets before writing to the underlying writer.
+// Output is passed on by line, so secrets are not split across writes; Flush writes what is left.
+type RedactWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewRedactWriter(w io.Writer) *RedactWriter {
+	return &RedactWriter{w: w}
+}
+
+func (r *RedactWriter) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	end := bytes.LastIndexByte(r.buf, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	_, err := io.WriteString(r.w, Redact(string(r.buf[:end+1])))
+	r.buf = r.buf[end+1:]
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *RedactWriter) Flush() error {
+	if len(r.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(r.w, Redact(string(r.buf)))
+	r.buf = nil
+	return err
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -1,13 +1,13 @@
 package runtime
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"runtime"
 	"strings"
+
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
 )
 
 type Environment interface {
@@ -31,24 +31,51 @@ func (e *EmptyEnv) WorkingDir() string {
 	return e.baseDir
 }
 
+type varsEnv struct {
+	EmptyEnv
+	vars map[string]string
+}
+
+// NewEnv returns an environment that adds vars to the environment of openpaas when running commands in baseDir.
+func NewEnv(baseDir string, vars map[string]string) Environment {
+	return &varsEnv{EmptyEnv: EmptyEnv{baseDir: baseDir}, vars: vars}
+}
+
+func (e *varsEnv) Get() map[string]string {
+	return e.vars
+}
+
+// Exec runs command with /bin/sh, for commands that need a shell, such as pipelines. Prefer Run otherwise.
 func Exec(env Environment, command string, stdOut io.Writer) error {
+	return run(env, exec.Command("/bin/sh", "-c", command), command, stdOut)
+}
 
-	vars := env.Get()
-	runCmd := ""
-	for k, v := range vars {
-		runCmd = runCmd + fmt.Sprintf("export %s=\"%s\" && ", k, v)
-	}
-	runCmd = runCmd + command
+// Run runs name with args, without a shell.
+func Run(env Environment, stdOut io.Writer, name string, args ...string) error {
+	return run(env, exec.Command(name, args...), strings.Join(append([]string{name}, args...), " "), stdOut)
+}
 
-	fmt.Println(runCmd)
+// run passes the variables of env through the process environment, so they do not show up in the command
+// line. Output to the terminal is redacted of registered secrets.
+func run(env Environment, cmd *exec.Cmd, display string, stdOut io.Writer) error {
+	logging.Default().Debug("running command", "command", Redact(display), "dir", env.WorkingDir())
 
-	cmd := exec.Command("/bin/sh", "-c", runCmd)
-	fmt.Println(env.WorkingDir())
+	cmd.Env = os.Environ()
+	for k, v := range env.Get() {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
 	if env.WorkingDir() != "" {
 		cmd.Dir = env.WorkingDir()
 	}
+	stderr := NewRedactWriter(os.Stderr)
+	cmd.Stderr = stderr
 	cmd.Stdout = stdOut
-	cmd.Stderr = os.Stderr
+	if stdOut == os.Stdout {
+		stdout := NewRedactWriter(os.Stdout)
+		defer stdout.Flush() //nolint
+		cmd.Stdout = stdout
+	}
+	defer stderr.Flush() //nolint
 
 	err := cmd.Start()
 	if err != nil {
@@ -64,26 +91,7 @@ func commandExists(cmd string) bool {
 
 func HasDependencies() bool {
 	dependencies := []string{
-		"consul",
-		"nomad",
-		"vault",
 		"ansible-playbook",
-		"cfssl",
-		"openssl",
-	}
-
-	var b bytes.Buffer
-	if runtime.GOOS == "darwin" {
-		err := Exec(&EmptyEnv{}, "which openssl", &b)
-		if err != nil {
-			fmt.Println("openssl not present")
-			fmt.Println(err)
-			return false
-		}
-		if strings.Contains(b.String(), "/usr/bin/openssl") {
-			fmt.Println("openssl is required, however on MacOS, the default MacOS is not compatible with our requirements. Please install openssl with brew or nix and ensure it is on the PATH")
-			return false
-		}
 	}
 
 	missing := []string{}
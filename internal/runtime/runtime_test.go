@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -19,3 +20,33 @@ func TestHasDependencies(t *testing.T) {
 	})
 
 }
+
+func TestRunPassesEnvironment(t *testing.T) {
+	var out bytes.Buffer
+	err := Run(NewEnv("", map[string]string{"OPENPAAS_TEST_VAR": "a value; with $hell chars"}), &out, "sh", "-c", `printf %s "$OPENPAAS_TEST_VAR"`)
+	assert.NoError(t, err)
+	assert.Equal(t, "a value; with $hell chars", out.String())
+
+	out.Reset()
+	err = Run(NewEnv("", nil), &out, "printf", "%s", "a; echo injected")
+	assert.NoError(t, err)
+	assert.Equal(t, "a; echo injected", out.String(), "arguments must not go through a shell")
+}
+
+func TestRedact(t *testing.T) {
+	RegisterSecret("TBD", "", "s.root-token-1234", "root-token")
+	assert.Equal(t, "token: [REDACTED], placeholder: TBD", Redact("token: s.root-token-1234, placeholder: TBD"))
+	assert.Equal(t, "[REDACTED] alone", Redact("root-token alone"))
+
+	var out bytes.Buffer
+	w := NewRedactWriter(&out)
+	_, err := w.Write([]byte("first s.root-to"))
+	assert.NoError(t, err)
+	assert.Empty(t, out.String(), "incomplete lines must be held back")
+	_, err = w.Write([]byte("ken-1234 line\nsecond root-"))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("token"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, "first [REDACTED] line\nsecond [REDACTED]", out.String())
+}
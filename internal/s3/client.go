@@ -0,0 +1,262 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Client stores objects in one bucket of an S3 compatible store.
+type Client interface {
+	// Put stores data at key, replacing the object that was there.
+	Put(key string, data []byte) error
+	// Get writes the object at key to w.
+	Get(key string, w io.Writer) error
+	// List returns the objects whose key starts with prefix, sorted by key.
+	List(prefix string) ([]Object, error)
+	// Delete removes the object at key. Deleting a missing object is not an error.
+	Delete(key string) error
+}
+
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// APIError is returned when the store responds with a non-2xx status.
+type APIError struct {
+	Method     string
+	Key        string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("s3: %s %s returned %d: %s %s", e.Method, e.Key, e.StatusCode, e.Code, e.Message)
+}
+
+type client struct {
+	endpoint, bucket, region string
+	accessKey, secretKey     string
+	http                     *http.Client
+	now                      func() time.Time
+}
+
+// NewClient returns a client for bucket at endpoint, such as https://s3.eu-central-1.amazonaws.com or a
+// MinIO server, which signs its requests with AWS signature version 4. Objects are addressed by path, as
+// S3 compatible stores rarely serve buckets as subdomains. Without endpoint, the AWS endpoint of region
+// is used.
+func NewClient(endpoint, region, bucket, accessKey, secretKey string) Client {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	return &client{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		http:      &http.Client{Timeout: 10 * time.Minute},
+		now:       time.Now,
+	}
+}
+
+func (c *client) Put(key string, data []byte) error {
+	return c.do(http.MethodPut, key, nil, data, nil)
+}
+
+func (c *client) Get(key string, w io.Writer) error {
+	return c.do(http.MethodGet, key, nil, nil, w)
+}
+
+func (c *client) Delete(key string) error {
+	err := c.do(http.MethodDelete, key, nil, nil, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func (c *client) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var buf bytes.Buffer
+		err := c.do(http.MethodGet, "", query, nil, &buf)
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.Unmarshal(buf.Bytes(), &res)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range res.Contents {
+			objects = append(objects, Object{Key: o.Key, Size: o.Size, LastModified: o.LastModified})
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+// do sends a request for key, or for the bucket if key is empty, and copies the response to out.
+func (c *client) do(method, key string, query url.Values, body []byte, out io.Writer) error {
+	path := "/" + c.bucket
+	if key != "" {
+		path += "/" + key
+	}
+	u := c.endpoint + escapePath(path)
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	sign(req, hex.EncodeToString(sum[:]), c.accessKey, c.secretKey, c.region, c.now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var res struct {
+			Code    string
+			Message string
+		}
+		b, _ := io.ReadAll(resp.Body)
+		_ = xml.Unmarshal(b, &res)
+		return &APIError{Method: method, Key: c.bucket + "/" + key, StatusCode: resp.StatusCode, Code: res.Code, Message: res.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// sign adds the AWS signature version 4 of req to its headers, covering the host and every header set on
+// req, along with the hash of its payload.
+func sign(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("x-amz-date", stamp)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data)) //nolint
+	return mac.Sum(nil)
+}
+
+// escapePath encodes every byte of path but the unreserved characters and slashes, as signature
+// version 4 expects.
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		ch := path[i]
+		if ch == '/' || unreserved(ch) {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by key, with spaces as %20 rather than +.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escapeQuery(key)+"="+escapeQuery(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escapeQuery(s string) string {
+	return strings.ReplaceAll(escapePath(s), "/", "%2F")
+}
+
+func unreserved(ch byte) bool {
+	return ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.' || ch == '~'
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/OpenPaaSDev/openpaas/internal/provider"
+)
+
+//go:embed templates/ansible/leave.yml
+var leaveAnsible string
+
+// drainDeadline is how long the allocations of a removed client get to migrate before nomad stops them.
+const drainDeadline = time.Hour
+
+// ScaleClients changes the number of nomad clients of the cluster to n and writes n to the config once it
+// has. The clients terraform removes are drained first, so their allocations migrate to the clients that
+// remain, and leave consul before they are destroyed. The playbooks only run on the clients that are added.
+func ScaleClients(ctx context.Context, config *conf.Config, n int, log *logging.Logger) error {
+	if n < 1 {
+		return errors.New("a cluster needs at least 1 client")
+	}
+	cloud, err := provider.For(config)
+	if err != nil {
+		return err
+	}
+	if _, ok := cloud.(provider.Static); ok {
+		return fmt.Errorf("the clients of the %s provider are listed in provider_settings, change them there and sync", cloud.Name())
+	}
+	config.ClusterConfig.Clients = n
+	err = config.Validate()
+	if err != nil {
+		return err
+	}
+	c, err := newCluster(config, log.With("dc", config.DC))
+	if err != nil {
+		return err
+	}
+	inv, err := c.inventory()
+	if err != nil {
+		return err
+	}
+	current := len(inv.All.Children.Clients.Hosts)
+	c.log.Info("scaling clients", "from", current, "to", n)
+	switch {
+	case n < current:
+		err = c.scaleIn(ctx, n)
+	case n > current:
+		err = c.scaleOut(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	// only a cluster that has n clients gets n written to the config, so a failed run can be retried
+	return config.SetClients(n)
+}
+
+// scaleIn drains the clients above n, stops them and removes them from consul and nomad, before terraform
+// destroys them.
+func (c *cluster) scaleIn(ctx context.Context, n int) error {
+	baseDir := c.config.BaseDir
+	inv, err := c.inventory()
+	if err != nil {
+		return err
+	}
+	removed, err := removedClients(inv, n)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, host := range removed {
+		names = append(names, inv.All.Children.Clients.Hosts[host].HostName)
+	}
+	nomad := newNomadClient(baseDir, inv, c.log)
+	nodeIDs, err := drainClients(ctx, nomad, names, c.log)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(baseDir, "leave.yml"), []byte(leaveAnsible), 0600)
+	if err != nil {
+		return err
+	}
+	err = c.ansible.Limit(removed...).Run(filepath.Join(baseDir, "leave.yml"))
+	if err != nil {
+		return err
+	}
+	consul, err := c.consulClient()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = consul.ForceLeave(name)
+		if err != nil {
+			return fmt.Errorf("removing %s from consul: %w", name, err)
+		}
+	}
+	for _, id := range nodeIDs {
+		err = nomad.PurgeNode(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.provision(ctx)
+	if err != nil {
+		return err
+	}
+	return regenerateConsulPolicies(consul, c.inv, baseDir, nodePolicy(secondaryDC(c.config)), c.log)
+}
+
+// scaleOut creates the clients terraform adds and runs the base, consul and nomad playbooks on them only.
+func (c *cluster) scaleOut(ctx context.Context) error {
+	baseDir := c.config.BaseDir
+	before, err := c.inventory()
+	if err != nil {
+		return err
+	}
+	err = c.provision(ctx)
+	if err != nil {
+		return err
+	}
+	added := []string{}
+	for host := range c.inv.All.Children.Clients.Hosts {
+		if _, ok := before.All.Children.Clients.Hosts[host]; !ok {
+			added = append(added, host)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	sort.Strings(added)
+	c.log.Info("configuring new clients", "hosts", strings.Join(added, ","))
+
+	consul, err := c.consulClient()
+	if err != nil {
+		return err
+	}
+	// the agents of the new clients need the node policy to cover them before they join
+	err = regenerateConsulPolicies(consul, c.inv, baseDir, nodePolicy(secondaryDC(c.config)), c.log)
+	if err != nil {
+		return err
+	}
+	c.ansible = c.ansible.Limit(added...)
+	err = c.base()
+	if err != nil {
+		return err
+	}
+	for _, playbook := range []string{"consul.yml", "nomad.yml"} {
+		err = c.ansible.Run(filepath.Join(baseDir, playbook))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removedClients returns the hosts of the clients terraform destroys when the cluster shrinks to n
+// clients, which are the ones with the highest index in <base_server_name>-client-<index>.
+func removedClients(inv *ansible.Inventory, n int) ([]string, error) {
+	hosts := inv.All.Children.Clients.GetHosts()
+	indices := map[string]int{}
+	for _, host := range hosts {
+		name := inv.All.Children.Clients.Hosts[host].HostName
+		index, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+		if err != nil {
+			return nil, fmt.Errorf("client %s is not named <base_server_name>-client-<index>", name)
+		}
+		indices[host] = index
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return indices[hosts[i]] < indices[hosts[j]]
+	})
+	if n >= len(hosts) {
+		return []string{}, nil
+	}
+	return hosts[n:], nil
+}
+
+// drainClients marks the nomad nodes of the named clients ineligible before it drains any of them, so that
+// no allocation migrates from one of them to another, and returns their node IDs once their allocations
+// migrated.
+func drainClients(ctx context.Context, nomad hashistack.NomadClient, names []string, log *logging.Logger) ([]string, error) {
+	nodes, err := nomad.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]hashistack.Node{}
+	for _, node := range nodes {
+		byName[node.Name] = node
+	}
+
+	nodeIDs := []string{}
+	drained := []string{}
+	for _, name := range names {
+		node, ok := byName[name]
+		if !ok {
+			log.Warn("client is not registered with nomad, nothing to drain", "client", name)
+			continue
+		}
+		err = nomad.MarkIneligible(node.ID)
+		if err != nil {
+			return nil, fmt.Errorf("marking %s ineligible: %w", name, err)
+		}
+		nodeIDs = append(nodeIDs, node.ID)
+		drained = append(drained, name)
+	}
+
+	errs := make([]error, len(drained))
+	var wg sync.WaitGroup
+	for i, name := range drained {
+		log.Info("draining client", "client", name, "deadline", drainDeadline)
+		wg.Add(1)
+		go func(i int, name, id string) {
+			defer wg.Done()
+			err := nomad.DrainNode(ctx, id, drainDeadline)
+			if err != nil {
+				errs[i] = fmt.Errorf("draining %s: %w", name, err)
+			}
+		}(i, name, nodeIDs[i])
+	}
+	wg.Wait()
+	err = errors.Join(errs...)
+	if err != nil {
+		return nil, err
+	}
+	return nodeIDs, nil
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func clientInventory(count int) *ansible.Inventory {
+	inv := &ansible.Inventory{}
+	inv.All.Children.Clients.Hosts = map[string]ansible.AnsibleHost{}
+	for i := 1; i <= count; i++ {
+		inv.All.Children.Clients.Hosts[fmt.Sprintf("127.0.1.%d", i)] = ansible.AnsibleHost{HostName: fmt.Sprintf("srv-client-%d", i)}
+	}
+	return inv
+}
+
+func TestRemovedClientsHaveTheHighestIndex(t *testing.T) {
+	removed, err := removedClients(clientInventory(12), 9)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.1.10", "127.0.1.11", "127.0.1.12"}, removed)
+
+	removed, err = removedClients(clientInventory(3), 3)
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+
+	_, err = removedClients(mustInventory(t), 1)
+	assert.ErrorContains(t, err, "is not named <base_server_name>-client-<index>")
+}
+
+func TestDrainClients(t *testing.T) {
+	var mu sync.Mutex
+	calls := []string{}
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+	nomad := &MockNomadClient{
+		NodesFunc: func() ([]hashistack.Node, error) {
+			return []hashistack.Node{{ID: "id-1", Name: "srv-client-1"}, {ID: "id-2", Name: "srv-client-2"}, {ID: "id-3", Name: "srv-client-3"}}, nil
+		},
+		MarkIneligibleFunc: func(nodeID string) error {
+			record("ineligible " + nodeID)
+			return nil
+		},
+		DrainNodeFunc: func(ctx context.Context, nodeID string, deadline time.Duration) error {
+			record("drain " + nodeID)
+			return nil
+		},
+	}
+
+	ids, err := drainClients(context.Background(), nomad, []string{"srv-client-2", "srv-client-3", "srv-client-4"}, logging.Discard())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id-2", "id-3"}, ids)
+	// no drain starts before every removed client is ineligible, so none takes the allocations of another
+	assert.Equal(t, []string{"ineligible id-2", "ineligible id-3"}, calls[:2])
+	assert.ElementsMatch(t, []string{"drain id-2", "drain id-3"}, calls[2:])
+	drained := []string{}
+	for _, call := range nomad.DrainNodeCalls() {
+		drained = append(drained, call.NodeID)
+		assert.Equal(t, drainDeadline, call.Deadline)
+	}
+	assert.ElementsMatch(t, []string{"id-2", "id-3"}, drained)
+
+	nomad.DrainNodeFunc = func(ctx context.Context, nodeID string, deadline time.Duration) error {
+		if nodeID == "id-3" {
+			return errors.New("allocations did not migrate")
+		}
+		return nil
+	}
+	_, err = drainClients(context.Background(), nomad, []string{"srv-client-2", "srv-client-3"}, logging.Discard())
+	assert.EqualError(t, err, "draining srv-client-3: allocations did not migrate")
+
+	drains := len(nomad.DrainNodeCalls())
+	nomad.MarkIneligibleFunc = func(nodeID string) error {
+		return errors.New("permission denied")
+	}
+	_, err = drainClients(context.Background(), nomad, []string{"srv-client-2", "srv-client-3"}, logging.Discard())
+	assert.EqualError(t, err, "marking srv-client-2 ineligible: permission denied")
+	assert.Len(t, nomad.DrainNodeCalls(), drains)
+}
+
+func TestScaleClientsKeepsTheConfigWhenScalingFails(t *testing.T) {
+	dir := t.TempDir()
+	b, err := os.ReadFile(filepath.Join("testdata", "config.yaml"))
+	assert.NoError(t, err)
+	file := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, os.WriteFile(file, b, 0600))
+	config, err := conf.Load(file)
+	assert.NoError(t, err)
+	config.BaseDir = filepath.Join(dir, "base")
+
+	err = ScaleClients(context.Background(), config, 4, logging.Discard())
+	assert.ErrorContains(t, err, "no inventory found")
+	saved, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, string(b), string(saved))
+}
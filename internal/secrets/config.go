@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/OpenPaaSDev/openpaas/internal/runtime"
 	"gopkg.in/yaml.v3"
 )
 
@@ -21,16 +22,33 @@ type Config struct {
 	S3AccessKey            string       `yaml:"s3_access_key"`
 	S3SecretKey            string       `yaml:"s3_secret_key"`
 	VaultConfig            VaultSecrets `yaml:"vault"`
+
+	// ConsulReplicationToken replicates the ACLs of the primary datacenter to secondaries of a federation.
+	ConsulReplicationToken string `yaml:"CONSUL_REPLICATION_TOKEN,omitempty"`
+	// ConsulMeshGatewayToken registers the mesh gateways of a federated datacenter.
+	ConsulMeshGatewayToken string `yaml:"CONSUL_MESH_GATEWAY_TOKEN,omitempty"`
+	// ConsulBackupToken takes the consul snapshots of the periodic backup job.
+	ConsulBackupToken string `yaml:"CONSUL_BACKUP_TOKEN,omitempty"`
 }
 
 type VaultSecrets struct {
 	RootToken      string   `yaml:"root_token"`
 	UnsealKeys     []string `yaml:"unseal_keys"`
 	NomadRootToken string   `yaml:"nomad_root_token"`
+	// RecoveryKeys replace the unseal keys when vault is auto-unsealed, they cannot unseal it.
+	RecoveryKeys []string `yaml:"recovery_keys,omitempty"`
+	// SealToken authenticates an auto-unsealing vault against the transit seal.
+	SealToken string `yaml:"seal_token,omitempty"`
 }
 
+// Load reads the secrets of the cluster in baseDir, decrypting them with the key provider configured
+// in the environment if they are encrypted.
 func Load(baseDir string) (*Config, error) {
-	bytes, err := os.ReadFile(filepath.Clean(File(baseDir)))
+	return LoadWith(baseDir, KeyProviderFromEnv())
+}
+
+func LoadWith(baseDir string, kp KeyProvider) (*Config, error) {
+	bytes, err := ReadFile(File(baseDir), kp)
 	if err != nil {
 		return nil, err
 	}
@@ -39,20 +57,42 @@ func Load(baseDir string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	secrets.register()
 	return &secrets, nil
 }
 
+// Write stores the secrets in baseDir, encrypted if a key provider is configured in the environment.
 func (sec *Config) Write(baseDir string) error {
+	return sec.WriteWith(baseDir, KeyProviderFromEnv())
+}
+
+func (sec *Config) WriteWith(baseDir string, kp KeyProvider) error {
+	sec.register()
 	bytes, err := yaml.Marshal(sec)
 	if err != nil {
 		return err
 	}
+	return WriteFile(File(baseDir), bytes, kp)
+}
 
-	err = os.WriteFile(filepath.Clean(File(baseDir)), bytes, 0600)
-	if err != nil {
-		return err
+// register keeps the secret values out of the output of the commands openpaas runs.
+func (sec *Config) register() {
+	runtime.RegisterSecret(sec.ConsulGossipKey, sec.NomadGossipKey, sec.NomadClientConsulToken,
+		sec.NomadServerConsulToken, sec.ConsulAgentToken, sec.ConsulBootstrapToken, sec.PrometheusConsulToken,
+		sec.FabioConsulToken, sec.VaultConsulToken, sec.ConsulReplicationToken, sec.ConsulMeshGatewayToken, sec.ConsulBackupToken, sec.S3AccessKey, sec.S3SecretKey,
+		sec.VaultConfig.RootToken, sec.VaultConfig.NomadRootToken, sec.VaultConfig.SealToken)
+	runtime.RegisterSecret(sec.VaultConfig.UnsealKeys...)
+	runtime.RegisterSecret(sec.VaultConfig.RecoveryKeys...)
+}
+
+// Exists reports whether secrets have been written to baseDir, encrypted or not.
+func Exists(baseDir string) bool {
+	for _, file := range []string{File(baseDir), File(baseDir) + EncryptedSuffix} {
+		if _, err := os.Stat(filepath.Clean(file)); err == nil {
+			return true
+		}
 	}
-	return nil
+	return false
 }
 
 func File(baseDir string) string {
@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// OpenSSLIterations is the PBKDF2 iteration count of the files the openssl key provider encrypts.
+const OpenSSLIterations = 100000
+
+// OpenSSLMACLabel is the message the HMAC key is derived from, with the AES key of the file as the key.
+const OpenSSLMACLabel = "openpaas backup mac"
+
+// opensslMagic starts the files of openssl enc with a salt, followed by the salt and the ciphertext.
+var opensslMagic = []byte("Salted__")
+
+type opensslKeyProvider struct {
+	passphrase []byte
+}
+
+// NewOpenSSLKeyProvider encrypts in the format of
+//
+//	openssl enc -aes-256-cbc -pbkdf2 -iter 100000 -md sha256
+//
+// followed by an HMAC-SHA256 of the salt and the ciphertext, so that files encrypted by shell scripts,
+// such as the periodic backup job, and by openpaas can be decrypted by either. The HMAC key is the
+// HMAC-SHA256 of OpenSSLMACLabel with the AES key, and the HMAC is checked before anything is decrypted.
+func NewOpenSSLKeyProvider(passphrase string) KeyProvider {
+	return &opensslKeyProvider{passphrase: []byte(passphrase)}
+}
+
+func (p *opensslKeyProvider) keys(salt []byte) (cipher.Block, []byte, []byte, error) {
+	keyIV := pbkdf2.Key(p.passphrase, salt, OpenSSLIterations, 32+aes.BlockSize, sha256.New)
+	block, err := aes.NewCipher(keyIV[:32])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mac := hmac.New(sha256.New, keyIV[:32])
+	mac.Write([]byte(OpenSSLMACLabel)) //nolint
+	return block, keyIV[32:], mac.Sum(nil), nil
+}
+
+func opensslMAC(key, saltAndCiphertext []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(saltAndCiphertext) //nolint
+	return mac.Sum(nil)
+}
+
+func (p *opensslKeyProvider) Encrypt(plaintext []byte) ([]byte, error) {
+	salt := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	block, iv, macKey, err := p.keys(salt)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+	rest := append(append([]byte{}, salt...), ciphertext...)
+	out := append(append([]byte{}, opensslMagic...), rest...)
+	return append(out, opensslMAC(macKey, rest)...), nil
+}
+
+func (p *opensslKeyProvider) Decrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, opensslMagic) {
+		return nil, fmt.Errorf("%w: not encrypted with openssl enc and a salt", ErrDecrypt)
+	}
+	rest := ciphertext[len(opensslMagic):]
+	if len(rest) < 8+aes.BlockSize+sha256.Size || (len(rest)-8-sha256.Size)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: file is truncated", ErrDecrypt)
+	}
+	rest, tag := rest[:len(rest)-sha256.Size], rest[len(rest)-sha256.Size:]
+	block, iv, macKey, err := p.keys(rest[:8])
+	if err != nil {
+		return nil, err
+	}
+	// the padding is only looked at once the ciphertext is known to be untouched
+	if !hmac.Equal(tag, opensslMAC(macKey, rest)) {
+		return nil, ErrDecrypt
+	}
+	plaintext := make([]byte, len(rest)-8)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, rest[8:])
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrDecrypt
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenSSLKeyProvider(t *testing.T) {
+	kp := NewOpenSSLKeyProvider("backup passphrase")
+	for _, size := range []int{0, 15, 16, 1000} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i)
+		}
+		encrypted, err := kp.Encrypt(plaintext)
+		assert.NoError(t, err)
+		decrypted, err := kp.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, plaintext, decrypted)
+	}
+
+	// encrypted as the backup job does, printf 'consul snapshot' | openssl enc -aes-256-cbc -pbkdf2 -iter 100000
+	// -md sha256 -pass pass:'backup passphrase' followed by the HMAC of its salt and ciphertext
+	encrypted, err := base64.StdEncoding.DecodeString("U2FsdGVkX18QDZs23ZoAWmDrrjxT69YdeFiZM7jh8ak/h0Gm31sb0Pdlh6qwCL0eNFNtJR1iiBNJNop0d+cylA==")
+	assert.NoError(t, err)
+	decrypted, err := kp.Decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "consul snapshot", string(decrypted))
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[20] ^= 1
+	_, err = kp.Decrypt(tampered)
+	assert.ErrorIs(t, err, ErrDecrypt)
+	// without the HMAC, as openssl enc alone writes it
+	_, err = kp.Decrypt(encrypted[:len(encrypted)-32])
+	assert.ErrorIs(t, err, ErrDecrypt)
+	_, err = NewOpenSSLKeyProvider("wrong").Decrypt(encrypted)
+	assert.ErrorIs(t, err, ErrDecrypt)
+	_, err = kp.Decrypt(encrypted[:20])
+	assert.ErrorIs(t, err, ErrDecrypt)
+	_, err = kp.Decrypt([]byte("openpaas-secrets-v1\n"))
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseEnv holds the passphrase secrets are encrypted with. Secrets are stored in plaintext if it is not set.
+// The private keys of the CAs and certificates are always stored in plaintext, as ansible and the consul and
+// nomad CLIs read them as files.
+const PassphraseEnv = "OPENPAAS_SECRETS_PASSPHRASE"
+
+// EncryptedSuffix is appended to the name of a secrets file when it is stored encrypted.
+const EncryptedSuffix = ".enc"
+
+var (
+	ErrNoKeyProvider = fmt.Errorf("secrets are encrypted, but no key is configured, please set %s", PassphraseEnv)
+	ErrDecrypt       = errors.New("secrets could not be decrypted, is the key correct?")
+)
+
+// KeyProvider encrypts secrets at rest.
+type KeyProvider interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// KeyProviderFromEnv returns the key provider configured in the environment, or nil if secrets are
+// stored in plaintext.
+func KeyProviderFromEnv() KeyProvider {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return NewPassphraseKeyProvider(passphrase)
+	}
+	return nil
+}
+
+// ReadFile returns the plaintext contents of a secrets file, decrypting file+EncryptedSuffix with kp
+// if it exists.
+func ReadFile(file string, kp KeyProvider) ([]byte, error) {
+	encrypted, err := os.ReadFile(filepath.Clean(file + EncryptedSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return os.ReadFile(filepath.Clean(file))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if kp == nil {
+		return nil, ErrNoKeyProvider
+	}
+	return kp.Decrypt(encrypted)
+}
+
+// WriteFile writes a secrets file, encrypted to file+EncryptedSuffix if kp is set, in which case any
+// plaintext copy is removed.
+func WriteFile(file string, data []byte, kp KeyProvider) error {
+	if kp == nil {
+		if _, err := os.Stat(filepath.Clean(file + EncryptedSuffix)); err == nil {
+			return ErrNoKeyProvider
+		}
+		return os.WriteFile(filepath.Clean(file), data, 0600)
+	}
+	encrypted, err := kp.Encrypt(data)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Clean(file+EncryptedSuffix), encrypted, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Clean(file))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// passphraseMagic marks files encrypted by the passphrase key provider and versions their layout:
+// magic | scrypt salt | AES-GCM nonce | ciphertext.
+var passphraseMagic = []byte("openpaas-secrets-v1\n")
+
+const saltSize = 16
+
+type passphraseKeyProvider struct {
+	passphrase []byte
+}
+
+// NewPassphraseKeyProvider encrypts with AES-256-GCM, using a key derived from passphrase with scrypt.
+func NewPassphraseKeyProvider(passphrase string) KeyProvider {
+	return &passphraseKeyProvider{passphrase: []byte(passphrase)}
+}
+
+func (p *passphraseKeyProvider) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(p.passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (p *passphraseKeyProvider) Encrypt(plaintext []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := p.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, passphraseMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, passphraseMagic), nil
+}
+
+func (p *passphraseKeyProvider) Decrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, passphraseMagic) {
+		return nil, fmt.Errorf("%w: unknown file format", ErrDecrypt)
+	}
+	rest := ciphertext[len(passphraseMagic):]
+	if len(rest) < saltSize {
+		return nil, fmt.Errorf("%w: file is truncated", ErrDecrypt)
+	}
+	aead, err := p.aead(rest[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	rest = rest[saltSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: file is truncated", ErrDecrypt)
+	}
+	plaintext, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], passphraseMagic)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,63 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPassphraseKeyProvider(t *testing.T) {
+	kp := NewPassphraseKeyProvider("correct horse battery staple")
+	encrypted, err := kp.Encrypt([]byte("root_token: s.123"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "s.123")
+
+	plaintext, err := kp.Decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "root_token: s.123", string(plaintext))
+
+	_, err = NewPassphraseKeyProvider("wrong").Decrypt(encrypted)
+	assert.ErrorIs(t, err, ErrDecrypt)
+	_, err = kp.Decrypt(encrypted[:len(passphraseMagic)+4])
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestEncryptedSecrets(t *testing.T) {
+	folder := util.RandString(8)
+	err := os.MkdirAll(filepath.Join(folder, "secrets"), 0750)
+	assert.NoError(t, err)
+	defer func() {
+		e := os.RemoveAll(folder)
+		assert.NoError(t, e)
+	}()
+
+	sec := &Config{ConsulBootstrapToken: "TBD", VaultConfig: VaultSecrets{RootToken: "s.123", UnsealKeys: []string{"a", "b"}}}
+	err = sec.WriteWith(folder, nil)
+	assert.NoError(t, err)
+	assert.FileExists(t, File(folder))
+	assert.True(t, Exists(folder))
+
+	// once a key is configured, the next write encrypts and removes the plaintext secrets
+	kp := NewPassphraseKeyProvider("passphrase")
+	err = sec.WriteWith(folder, kp)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, File(folder))
+	assert.FileExists(t, File(folder)+EncryptedSuffix)
+	assert.True(t, Exists(folder))
+
+	loaded, err := LoadWith(folder, kp)
+	assert.NoError(t, err)
+	assert.Equal(t, sec, loaded)
+
+	_, err = LoadWith(folder, nil)
+	assert.ErrorIs(t, err, ErrNoKeyProvider)
+	assert.ErrorIs(t, sec.WriteWith(folder, nil), ErrNoKeyProvider)
+
+	t.Setenv(PassphraseEnv, "passphrase")
+	loaded, err = Load(folder)
+	assert.NoError(t, err)
+	assert.Equal(t, "s.123", loaded.VaultConfig.RootToken)
+}
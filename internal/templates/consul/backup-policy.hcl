@@ -0,0 +1,2 @@
+# consul snapshot save needs acl = "write", the token of the backup job can do nothing else
+acl = "write"
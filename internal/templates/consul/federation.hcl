@@ -0,0 +1,25 @@
+[[- if not .Primary -]]
+# the datacenter is not federated
+[[ else -]]
+primary_datacenter = "[[ .Primary ]]"
+[[- if .Gateways ]]
+primary_gateways = [ [[ .Gateways ]] ]
+[[- end ]]
+
+connect {
+  enable_mesh_gateway_wan_federation = true
+}
+
+ports {
+  grpc = 8502
+}
+[[- if .Secondary ]]
+
+acl {
+  enable_token_replication = true
+  tokens {
+    replication = "{{ CONSUL_REPLICATION_TOKEN }}"
+  }
+}
+[[- end ]]
+[[ end -]]
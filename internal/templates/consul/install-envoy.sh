@@ -0,0 +1,10 @@
+#!/bin/bash
+FILE=/usr/local/bin/envoy
+if [ -f "$FILE" ]; then
+    echo "$FILE exists."
+else 
+    curl -L https://func-e.io/install.sh | bash -s -- -b /usr/local/bin
+    FUNC_E_HOME=/opt/func-e /usr/local/bin/func-e use 1.27.2
+    cp /opt/func-e/versions/1.27.2/bin/envoy /usr/local/bin/envoy
+    chmod 755 /usr/local/bin/envoy
+fi
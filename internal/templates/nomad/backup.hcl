@@ -0,0 +1,127 @@
+job "openpaas-backup" {
+  datacenters = ["[[ .DC ]]"]
+  type        = "batch"
+
+  periodic {
+    cron             = "[[ .Schedule ]]"
+    prohibit_overlap = true
+  }
+
+  group "backup" {
+    restart {
+      attempts = 0
+      mode     = "fail"
+    }
+
+    # takes and encrypts the snapshots in the alloc dir, which the backup task uploads
+    task "snapshot" {
+      driver = "docker"
+
+      lifecycle {
+        hook = "prestart"
+      }
+
+      config {
+        # ships the consul cli, curl and openssl
+        image        = "hashicorp/consul:1.16"
+        network_mode = "host"
+        entrypoint   = ["/bin/sh"]
+        args         = ["/local/snapshot.sh"]
+        # the nomad client certificates authenticate the snapshot request to the nomad servers
+        volumes = ["/etc/nomad.d/certs:/certs:ro"]
+      }
+
+      template {
+        destination = "secrets/snapshot.env"
+        env         = true
+        data        = <<EOF
+{{ with nomadVar "nomad/jobs/openpaas-backup" }}
+CONSUL_HTTP_TOKEN={{ .consul_token }}
+BACKUP_PASSPHRASE={{ .passphrase }}
+{{ end }}
+EOF
+      }
+
+      # encrypted like openpaas backup does, so that openpaas restore reads the backups of both
+      template {
+        destination = "local/snapshot.sh"
+        data        = <<EOF
+set -eu
+cd /alloc/data
+consul snapshot save consul.snap >/dev/null
+for server in[[ range .NomadServers ]] [[ . ]][[ end ]]; do
+  if curl -sSf --cacert /certs/nomad-ca.pem --cert /certs/client.pem --key /certs/client-key.pem \
+    --resolve server.global.nomad:4646:$server -o nomad.snap https://server.global.nomad:4646/v1/operator/snapshot; then
+    break
+  fi
+done
+test -s nomad.snap
+
+enc="openssl enc -aes-256-cbc -pbkdf2 -iter [[ .Iterations ]] -md sha256 -pass env:BACKUP_PASSPHRASE"
+for name in consul nomad; do
+  $enc -in $name.snap -out $name.snap.enc
+  rm $name.snap
+  # appends the HMAC of the salt and the ciphertext, keyed with the HMAC of the label with the AES key
+  salt=$(head -c 16 $name.snap.enc | tail -c 8 | od -An -v -tx1 | tr -d ' \n')
+  key=$($enc -S $salt -P | sed -n 's/^key=//p')
+  mackey=$(printf '[[ .MACLabel ]]' | openssl dgst -sha256 -mac HMAC -macopt hexkey:$key -binary | od -An -v -tx1 | tr -d ' \n')
+  tail -c +9 $name.snap.enc | openssl dgst -sha256 -mac HMAC -macopt hexkey:$mackey -binary > $name.snap.mac
+  cat $name.snap.mac >> $name.snap.enc
+  rm $name.snap.mac
+done
+EOF
+      }
+
+      resources {
+        cpu    = 200
+        memory = 256
+      }
+    }
+
+    task "backup" {
+      driver = "docker"
+
+      config {
+        image      = "amazon/aws-cli:2.13.0"
+        entrypoint = ["/bin/sh"]
+        args       = ["/local/backup.sh"]
+      }
+
+      template {
+        destination = "secrets/backup.env"
+        env         = true
+        data        = <<EOF
+{{ with nomadVar "nomad/jobs/openpaas-backup" }}
+AWS_ACCESS_KEY_ID={{ .s3_access_key }}
+AWS_SECRET_ACCESS_KEY={{ .s3_secret_key }}
+{{ end }}
+EOF
+      }
+
+      template {
+        destination = "local/backup.sh"
+        data        = <<EOF
+set -eu
+cd /alloc/data
+stamp=$(date -u +%Y%m%dT%H%M%SZ)
+dest="s3://[[ .Bucket ]]/[[ .Dir ]]"
+s3="aws s3 --region [[ .Region ]][[ if .Endpoint ]] --endpoint-url [[ .Endpoint ]][[ end ]]"
+
+for name in consul nomad; do
+  $s3 cp --only-show-errors $name.snap.enc "$dest$stamp/$name.snap.enc"
+done
+
+# keeps the newest [[ .Keep ]] backups
+$s3 ls "$dest" | awk '$1 == "PRE" { print $2 }' | sort -r | tail -n +[[ .PruneFrom ]] | while read -r old; do
+  $s3 rm --recursive --only-show-errors "$dest$old"
+done
+EOF
+      }
+
+      resources {
+        cpu    = 200
+        memory = 256
+      }
+    }
+  }
+}
@@ -0,0 +1,363 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack/vault"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+
+	secret "github.com/OpenPaaSDev/openpaas/internal/secrets"
+)
+
+//go:embed templates/ansible/upgrade.yml
+var upgradeAnsible string
+
+var (
+	// upgradeSettleTimeout is how long the cluster gets to recover its health after a host was upgraded.
+	upgradeSettleTimeout = 5 * time.Minute
+	// upgradePollInterval is how often the health of the cluster is checked while it recovers.
+	upgradePollInterval = 5 * time.Second
+)
+
+// Upgrade rolls the versions pinned in cluster_config.versions out to the cluster, one host at a time.
+// The consul servers are upgraded first, then the nomad servers and the vault servers, each with their
+// leader or active server last, and then the agents on the other hosts. Between hosts, the autopilot
+// health of consul and nomad, the nomad leader and the unsealed vault servers are checked, and the
+// upgrade aborts when they regressed. Restarted vault servers are unsealed with the unseal keys in the
+// secrets, then with the shares of keyFiles, then with shares asked for on in.
+func Upgrade(ctx context.Context, config *conf.Config, keyFiles []string, in *os.File, out io.Writer, log *logging.Logger) error {
+	versions := map[string]string{
+		"consul": config.ClusterConfig.Versions.Consul,
+		"nomad":  config.ClusterConfig.Versions.Nomad,
+		"vault":  config.ClusterConfig.Versions.Vault,
+	}
+	if config.ClusterConfig.Versions == (conf.Versions{}) {
+		return errors.New("no versions are pinned in cluster_config.versions")
+	}
+	c, err := newCluster(config, log.With("dc", config.DC))
+	if err != nil {
+		return err
+	}
+	u, err := c.newUpgrader(shareSource(keyFiles, in, out))
+	if err != nil {
+		return err
+	}
+
+	before, err := u.health()
+	if err != nil {
+		return err
+	}
+	if problems := u.healthy().regressions(before); len(problems) > 0 {
+		return fmt.Errorf("the cluster is not healthy, fix it before upgrading: %s", strings.Join(problems, ", "))
+	}
+	for _, pkg := range []string{"consul", "nomad", "vault"} {
+		if versions[pkg] == "" {
+			continue
+		}
+		playbook, err := renderUpgrade(config.BaseDir, pkg)
+		if err != nil {
+			return err
+		}
+		servers, err := u.servers(pkg)
+		if err != nil {
+			return err
+		}
+		for _, host := range servers {
+			c.log.Info("upgrading server", "package", pkg, "version", versions[pkg], "host", host)
+			err = u.rollout(ctx, "upgrade", playbook, before, host)
+			if err != nil {
+				return err
+			}
+		}
+		agents := otherHosts(u.inv, servers)
+		if len(agents) > 0 {
+			c.log.Info("upgrading agents", "package", pkg, "version", versions[pkg], "hosts", len(agents))
+			err = u.rollout(ctx, "upgrade", playbook, before, agents...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderUpgrade writes the playbook upgrading pkg to base_dir and returns its path.
+func renderUpgrade(baseDir, pkg string) (string, error) {
+	tmpl, err := template.New("upgrade").Delims("[[", "]]").Parse(upgradeAnsible)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]string{"Package": pkg})
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(baseDir, fmt.Sprintf("upgrade-%s.yml", pkg))
+	return file, os.WriteFile(file, buf.Bytes(), 0600)
+}
+
+// health is the state of the cluster that must not regress while it is upgraded.
+type health struct {
+	ConsulHealthy   bool
+	ConsulTolerance int
+	NomadHealthy    bool
+	NomadTolerance  int
+	NomadLeader     string
+	VaultUnsealed   int
+	VaultActive     bool
+}
+
+// regressions describes how after is worse than h.
+func (h *health) regressions(after *health) []string {
+	problems := []string{}
+	if h.ConsulHealthy && !after.ConsulHealthy {
+		problems = append(problems, "consul servers are unhealthy")
+	}
+	if after.ConsulTolerance < h.ConsulTolerance {
+		problems = append(problems, fmt.Sprintf("consul failure tolerance dropped from %d to %d", h.ConsulTolerance, after.ConsulTolerance))
+	}
+	if h.NomadHealthy && !after.NomadHealthy {
+		problems = append(problems, "nomad servers are unhealthy")
+	}
+	if after.NomadTolerance < h.NomadTolerance {
+		problems = append(problems, fmt.Sprintf("nomad failure tolerance dropped from %d to %d", h.NomadTolerance, after.NomadTolerance))
+	}
+	if h.NomadLeader != "" && after.NomadLeader == "" {
+		problems = append(problems, "nomad has no leader")
+	}
+	if after.VaultUnsealed < h.VaultUnsealed {
+		problems = append(problems, fmt.Sprintf("%d of %d vault servers are unsealed", after.VaultUnsealed, h.VaultUnsealed))
+	}
+	if h.VaultActive && !after.VaultActive {
+		problems = append(problems, "vault has no active server")
+	}
+	return problems
+}
+
+// upgrader upgrades the hosts of a cluster and checks its health in between.
+type upgrader struct {
+	c          *cluster
+	inv        *ansible.Inventory
+	consul     hashistack.Consul
+	nomad      hashistack.NomadClient
+	vault      vault.Client
+	vaultHosts []string
+	// shares unseals restarted vault servers, which auto-unsealing vaults do themselves
+	shares vault.ShareSource
+}
+
+func (c *cluster) newUpgrader(next vault.ShareSource) (*upgrader, error) {
+	inv, err := c.inventory()
+	if err != nil {
+		return nil, err
+	}
+	sec, err := c.secrets()
+	if err != nil {
+		return nil, err
+	}
+	consul, err := c.consulClient()
+	if err != nil {
+		return nil, err
+	}
+	vaultHosts := inv.All.Children.VaultServers.GetHosts()
+	if len(vaultHosts) == 0 {
+		return nil, errors.New("no vault servers found in inventory")
+	}
+	sort.Strings(vaultHosts)
+	vaultClient, err := vault.NewClient(c.config.BaseDir, vaultHosts[0], sec, c.log)
+	if err != nil {
+		return nil, err
+	}
+	u := &upgrader{
+		c:          c,
+		inv:        inv,
+		consul:     consul,
+		nomad:      newNomadClient(c.config.BaseDir, inv, c.log),
+		vault:      vaultClient,
+		vaultHosts: vaultHosts,
+	}
+	if c.config.ClusterConfig.AutoUnseal.Seal == "" {
+		u.shares = keptShares(sec, next)
+	}
+	return u, nil
+}
+
+// keptShares hands out the unseal keys kept in the secrets before asking next for shares. The key is
+// picked by the unseal progress of the server, so every restarted server gets all of them.
+func keptShares(sec *secret.Config, next vault.ShareSource) vault.ShareSource {
+	keys := sec.VaultConfig.UnsealKeys
+	return func(host string, status *vault.SealStatus) (string, error) {
+		if status.Progress < len(keys) {
+			return keys[status.Progress], nil
+		}
+		return next(host, status)
+	}
+}
+
+// healthy is the health of a cluster that has a leader and every vault server unsealed.
+func (u *upgrader) healthy() *health {
+	return &health{ConsulHealthy: true, NomadHealthy: true, NomadLeader: "leader", VaultUnsealed: len(u.vaultHosts), VaultActive: true}
+}
+
+func (u *upgrader) health() (*health, error) {
+	consul, err := u.consul.AutopilotHealth()
+	if err != nil {
+		return nil, fmt.Errorf("consul autopilot health: %w", err)
+	}
+	nomad, err := u.nomad.AutopilotHealth()
+	if err != nil {
+		return nil, fmt.Errorf("nomad autopilot health: %w", err)
+	}
+	leader, err := u.nomad.Leader()
+	if err != nil {
+		return nil, fmt.Errorf("nomad leader: %w", err)
+	}
+	h := &health{
+		ConsulHealthy:   consul.Healthy,
+		ConsulTolerance: consul.FailureTolerance,
+		NomadHealthy:    nomad.Healthy,
+		NomadTolerance:  nomad.FailureTolerance,
+		NomadLeader:     leader,
+	}
+	for _, host := range u.vaultHosts {
+		status, err := u.vault.Health(host)
+		if err != nil {
+			// a vault server that does not answer is no better than a sealed one
+			u.c.log.Debug("vault health", "host", host, "error", err)
+			continue
+		}
+		if !status.Sealed {
+			h.VaultUnsealed++
+			h.VaultActive = h.VaultActive || !status.Standby
+		}
+	}
+	return h, nil
+}
+
+// servers returns the servers of pkg in the order they are upgraded, with the leader of the consul or
+// nomad servers, or the active vault server, last so that a new one is elected only once.
+func (u *upgrader) servers(pkg string) ([]string, error) {
+	switch pkg {
+	case "consul", "nomad":
+		group := u.inv.All.Children.ConsulServers
+		autopilot := u.consul.AutopilotHealth
+		if pkg == "nomad" {
+			group = u.inv.All.Children.NomadServers
+			autopilot = u.nomad.AutopilotHealth
+		}
+		h, err := autopilot()
+		if err != nil {
+			return nil, err
+		}
+		leader := h.LeaderIP()
+		return leaderLast(group.GetHosts(), func(host string) bool {
+			return leader != "" && (host == leader || group.Hosts[host].PrivateIP == leader)
+		}), nil
+	case "vault":
+		return leaderLast(u.vaultHosts, func(host string) bool {
+			status, err := u.vault.Health(host)
+			return err == nil && !status.Sealed && !status.Standby
+		}), nil
+	}
+	return nil, fmt.Errorf("%s is not a package openpaas upgrades", pkg)
+}
+
+// leaderLast sorts hosts, moving the one isLeader reports last.
+func leaderLast(hosts []string, isLeader func(string) bool) []string {
+	sorted := append([]string{}, hosts...)
+	sort.Strings(sorted)
+	leaders := map[string]bool{}
+	for _, host := range sorted {
+		leaders[host] = isLeader(host)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return !leaders[sorted[i]] && leaders[sorted[j]]
+	})
+	return sorted
+}
+
+// otherHosts returns the hosts of the inventory that are not in servers.
+func otherHosts(inv *ansible.Inventory, servers []string) []string {
+	skip := map[string]bool{}
+	for _, host := range servers {
+		skip[host] = true
+	}
+	children := inv.All.Children
+	hosts := []string{}
+	for _, group := range []ansible.HostGroup{children.ConsulServers, children.NomadServers, children.VaultServers,
+		children.Clients, children.Prometheus, children.Grafana, children.Loki, children.Tempo} {
+		for host := range group.Hosts {
+			if !skip[host] {
+				skip[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// rollout runs playbook on hosts, which it changes one at a time, and waits for the cluster to be as
+// healthy as before. what names the change when it is aborted.
+func (u *upgrader) rollout(ctx context.Context, what, playbook string, before *health, hosts ...string) error {
+	err := u.c.ansible.Limit(hosts...).Run(playbook)
+	if err != nil {
+		return err
+	}
+	err = u.settle(ctx, before)
+	if err != nil {
+		return fmt.Errorf("%s aborted after %s: %w", what, strings.Join(hosts, ","), err)
+	}
+	return nil
+}
+
+// settle waits until the cluster is as healthy as before, unsealing restarted vault servers, and fails
+// with what regressed when it is not within upgradeSettleTimeout.
+func (u *upgrader) settle(ctx context.Context, before *health) error {
+	deadline := time.Now().Add(upgradeSettleTimeout)
+	for {
+		var problems []string
+		if u.shares != nil {
+			err := vault.UnsealWith(u.vault, u.vaultHosts, u.shares)
+			if errors.Is(err, vault.ErrSealed) {
+				return err
+			}
+			if err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		after, err := u.health()
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			problems = append(problems, before.regressions(after)...)
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(strings.Join(problems, ", "))
+		}
+		u.c.log.Debug("waiting for the cluster to recover", "problems", strings.Join(problems, ", "))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(upgradePollInterval):
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack/vault"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"github.com/stretchr/testify/assert"
+
+	secret "github.com/OpenPaaSDev/openpaas/internal/secrets"
+)
+
+func TestHealthRegressions(t *testing.T) {
+	before := &health{ConsulHealthy: true, ConsulTolerance: 1, NomadHealthy: true, NomadTolerance: 1, NomadLeader: "10.0.0.1:4647", VaultUnsealed: 2, VaultActive: true}
+	assert.Empty(t, before.regressions(before))
+
+	after := *before
+	after.ConsulTolerance = 0
+	after.NomadLeader = ""
+	after.VaultUnsealed = 1
+	assert.Equal(t, []string{"consul failure tolerance dropped from 1 to 0", "nomad has no leader", "1 of 2 vault servers are unsealed"}, before.regressions(&after))
+
+	after = *before
+	after.NomadHealthy = false
+	after.VaultActive = false
+	assert.Equal(t, []string{"nomad servers are unhealthy", "vault has no active server"}, before.regressions(&after))
+}
+
+// newTestUpgrader returns an upgrader for the test inventory, whose consul leader is 127.0.0.2, nomad
+// leader 127.0.0.1 and active vault server 127.0.0.7.
+func newTestUpgrader(t *testing.T) (*upgrader, *MockVaultClient) {
+	sealed := map[string]bool{}
+	vaultClient := &MockVaultClient{
+		HealthFunc: func(host string) (*vault.Health, error) {
+			return &vault.Health{Initialized: true, Sealed: sealed[host], Standby: host != "127.0.0.7"}, nil
+		},
+		StatusFunc: func(host string) (*vault.SealStatus, error) {
+			return &vault.SealStatus{Sealed: sealed[host], Threshold: 1}, nil
+		},
+		UnsealFunc: func(host, key string) (*vault.SealStatus, error) {
+			assert.Equal(t, "kept-key", key)
+			sealed[host] = false
+			return &vault.SealStatus{}, nil
+		},
+	}
+	sealed["127.0.0.6"] = true
+	u := &upgrader{
+		c:   &cluster{log: logging.Discard()},
+		inv: mustInventory(t),
+		consul: &MockConsul{
+			AutopilotHealthFunc: func() (*hashistack.AutopilotHealth, error) {
+				return &hashistack.AutopilotHealth{Healthy: true, FailureTolerance: 1, Servers: []hashistack.AutopilotServer{
+					{Address: "10.0.0.1:8300"}, {Address: "10.0.0.2:8300", Leader: true}, {Address: "10.0.0.3:8300"},
+				}}, nil
+			},
+		},
+		nomad: &MockNomadClient{
+			AutopilotHealthFunc: func() (*hashistack.AutopilotHealth, error) {
+				return &hashistack.AutopilotHealth{Healthy: true, FailureTolerance: 1, Servers: []hashistack.AutopilotServer{
+					{Address: "10.0.0.1:4647", Leader: true}, {Address: "10.0.0.2:4647"}, {Address: "10.0.0.3:4647"},
+				}}, nil
+			},
+			LeaderFunc: func() (string, error) {
+				return "10.0.0.1:4647", nil
+			},
+		},
+		vault:      vaultClient,
+		vaultHosts: []string{"127.0.0.6", "127.0.0.7"},
+		shares: keptShares(&secret.Config{VaultConfig: secret.VaultSecrets{UnsealKeys: []string{"kept-key"}}}, func(host string, status *vault.SealStatus) (string, error) {
+			return "", vault.ErrSealed
+		}),
+	}
+	return u, vaultClient
+}
+
+func TestUpgradeServersLeaderLast(t *testing.T) {
+	u, _ := newTestUpgrader(t)
+
+	for pkg, expected := range map[string][]string{
+		"consul": {"127.0.0.1", "127.0.0.3", "127.0.0.2"},
+		"nomad":  {"127.0.0.2", "127.0.0.3", "127.0.0.1"},
+		"vault":  {"127.0.0.6", "127.0.0.7"},
+	} {
+		servers, err := u.servers(pkg)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, servers, pkg)
+	}
+	assert.Equal(t, []string{"127.0.0.4", "127.0.0.5", "127.0.0.6", "127.0.0.7", "195.201.222.106"}, otherHosts(u.inv, []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}))
+}
+
+func TestUpgradeSettlesOrAborts(t *testing.T) {
+	u, vaultClient := newTestUpgrader(t)
+	before, err := u.health()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1 of 2 vault servers are unsealed"}, u.healthy().regressions(before))
+
+	// a restarted vault server is unsealed with the unseal keys in the secrets
+	before.VaultUnsealed = 2
+	assert.NoError(t, u.settle(context.Background(), before))
+	assert.Len(t, vaultClient.UnsealCalls(), 1)
+	// the kept keys are handed out again to the next server that restarts
+	key, err := u.shares("127.0.0.7", &vault.SealStatus{Sealed: true, Threshold: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "kept-key", key)
+	_, err = u.shares("127.0.0.7", &vault.SealStatus{Sealed: true, Threshold: 2, Progress: 1})
+	assert.ErrorIs(t, err, vault.ErrSealed)
+
+	timeout, interval := upgradeSettleTimeout, upgradePollInterval
+	upgradeSettleTimeout, upgradePollInterval = 20*time.Millisecond, time.Millisecond
+	defer func() {
+		upgradeSettleTimeout, upgradePollInterval = timeout, interval
+	}()
+	u.nomad.(*MockNomadClient).LeaderFunc = func() (string, error) {
+		return "", nil
+	}
+	assert.EqualError(t, u.settle(context.Background(), before), "nomad has no leader")
+
+	u.consul.(*MockConsul).AutopilotHealthFunc = func() (*hashistack.AutopilotHealth, error) {
+		return nil, errors.New("connection refused")
+	}
+	assert.EqualError(t, u.settle(context.Background(), before), "consul autopilot health: connection refused")
+}
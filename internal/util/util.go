@@ -3,7 +3,6 @@ package util
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
@@ -38,10 +37,7 @@ func GetPublicIP(ctx context.Context) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	defer func() {
-		e := resp.Body.Close()
-		fmt.Println(e)
-	}()
+	defer resp.Body.Close() //nolint
 
 	body, err := io.ReadAll(resp.Body)
 
@@ -2,8 +2,10 @@ package util
 
 import (
 	"context"
+	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -27,3 +29,15 @@ func TestGetPublicIP(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, strings.Count(ip, "."), 3)
 }
+
+func TestNewStreamingClient(t *testing.T) {
+	client := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{}}
+	streaming := NewStreamingClient(client, time.Minute)
+	assert.Zero(t, streaming.Timeout)
+	assert.Equal(t, time.Minute, streaming.Transport.(*http.Transport).ResponseHeaderTimeout)
+	assert.Equal(t, 30*time.Second, client.Timeout)
+	assert.Zero(t, client.Transport.(*http.Transport).ResponseHeaderTimeout)
+
+	streaming = NewStreamingClient(&http.Client{}, time.Minute)
+	assert.Equal(t, time.Minute, streaming.Transport.(*http.Transport).ResponseHeaderTimeout)
+}
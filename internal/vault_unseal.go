@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/OpenPaaSDev/openpaas/internal/ansible"
+	"github.com/OpenPaaSDev/openpaas/internal/conf"
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack/vault"
+	"github.com/OpenPaaSDev/openpaas/internal/logging"
+	"golang.org/x/term"
+
+	secret "github.com/OpenPaaSDev/openpaas/internal/secrets"
+)
+
+// UnsealVault unseals the vault servers of the cluster. Shares are taken from keyFiles first, each
+// holding one decrypted share, and then asked for on in until vault reports every server unsealed.
+func UnsealVault(config *conf.Config, keyFiles []string, in *os.File, out io.Writer, log *logging.Logger) error {
+	sec, err := secret.Load(config.BaseDir)
+	if err != nil {
+		return err
+	}
+	inv, err := ansible.LoadInventory(filepath.Clean(filepath.Join(config.BaseDir, "inventory")))
+	if err != nil {
+		return err
+	}
+	return vault.Unseal(config, inv, sec, shareSource(keyFiles, in, out), log)
+}
+
+// shareSource hands out the shares of keyFiles and then prompts for more. Input is hidden when in is a terminal.
+func shareSource(keyFiles []string, in *os.File, out io.Writer) vault.ShareSource {
+	lines := bufio.NewReader(in)
+	return func(host string, status *vault.SealStatus) (string, error) {
+		if len(keyFiles) > 0 {
+			content, err := os.ReadFile(filepath.Clean(keyFiles[0]))
+			if err != nil {
+				return "", err
+			}
+			keyFiles = keyFiles[1:]
+			return strings.TrimSpace(string(content)), nil
+		}
+
+		fmt.Fprintf(out, "Unseal key share for %s (progress %d/%d): ", host, status.Progress, status.Threshold) //nolint
+		var share string
+		if term.IsTerminal(int(in.Fd())) {
+			raw, err := term.ReadPassword(int(in.Fd()))
+			fmt.Fprintln(out) //nolint
+			if err != nil {
+				return "", err
+			}
+			share = string(raw)
+		} else {
+			line, err := lines.ReadString('\n')
+			if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+				return "", fmt.Errorf("%w: %s needs more unseal key shares", vault.ErrSealed, host)
+			}
+			share = line
+		}
+		share = strings.TrimSpace(share)
+		if share == "" {
+			return "", fmt.Errorf("%w: no unseal key share given for %s", vault.ErrSealed, host)
+		}
+		return share, nil
+	}
+}
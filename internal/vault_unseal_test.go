@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OpenPaaSDev/openpaas/internal/hashistack/vault"
+	"github.com/OpenPaaSDev/openpaas/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareSource(t *testing.T) {
+	folder := util.RandString(8)
+	assert.NoError(t, os.MkdirAll(folder, 0750))
+	defer func() {
+		e := os.RemoveAll(filepath.Clean(folder))
+		assert.NoError(t, e)
+	}()
+	keyFile := filepath.Join(folder, "share")
+	assert.NoError(t, os.WriteFile(keyFile, []byte("from-file\n"), 0600))
+	input := filepath.Join(folder, "input")
+	assert.NoError(t, os.WriteFile(input, []byte("typed\n"), 0600))
+	in, err := os.Open(input)
+	assert.NoError(t, err)
+	defer in.Close() //nolint
+
+	var out strings.Builder
+	next := shareSource([]string{keyFile}, in, &out)
+	status := &vault.SealStatus{Sealed: true, Threshold: 3}
+
+	share, err := next("vault-1", status)
+	assert.NoError(t, err)
+	assert.Equal(t, "from-file", share)
+	share, err = next("vault-1", status)
+	assert.NoError(t, err)
+	assert.Equal(t, "typed", share)
+	assert.Contains(t, out.String(), "vault-1 (progress 0/3)")
+
+	_, err = next("vault-1", status)
+	assert.ErrorIs(t, err, vault.ErrSealed)
+}